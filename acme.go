@@ -1,43 +1,79 @@
 package acme
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/tonyzzp/acme/utils"
 )
 
+const LetsEncryptStaging = "https://acme-staging-v02.api.letsencrypt.org/directory"
+const LetsEncryptProduction = "https://acme-v02.api.letsencrypt.org/directory"
+
 type Client struct {
-	JWK         *JWK
-	Directory   *Directory
-	Account     *Account
-	storeRoot   string
-	storeCerts  string
-	storeOrders string
+	JWK            *JWK
+	Signer         crypto.Signer
+	Directory      *Directory
+	Account        *Account
+	PollInterval   time.Duration
+	PollTimeout    time.Duration
+	PreferredChain *ChainPreference
+	DirectoryUrl   string
+	Contact        []string
+	KeyType        string
+	DeployHooks    []DeployHook
+	AutoExport     *ExportOptions
+	KeyStore       KeyStore
+	Logger         *slog.Logger
+	DebugWire      bool
+	HTTPClient     *http.Client
+	Proxy          string
+	RootCAs        *x509.CertPool
+	Timeout        time.Duration
+	UserAgent      string
+	Retry          *RetryPolicy
+	CAA            *CAAOptions
+	httpLock       sync.Mutex
+	rc             *resty.Client
+	storeRoot      string
+	storeCerts     string
+	storeOrders    string
+	storePending   string
 }
 
 func NewAcmeClient(store string) *Client {
 	rtn := &Client{
-		storeRoot:   store,
-		storeOrders: filepath.Join(store, "orders"),
-		storeCerts:  filepath.Join(store, "certs"),
+		DirectoryUrl: LetsEncryptStaging,
+		KeyType:      KeyTypeEC256,
+		PollInterval: 3 * time.Second,
+		PollTimeout:  5 * time.Minute,
+		Timeout:      30 * time.Second,
+		Retry:        DefaultRetryPolicy(),
+		storeRoot:    store,
+		storeOrders:  filepath.Join(store, "orders"),
+		storeCerts:   filepath.Join(store, "certs"),
+		storePending: filepath.Join(store, "pending"),
 	}
 	os.MkdirAll(rtn.storeOrders, os.ModePerm)
 	os.MkdirAll(rtn.storeCerts, os.ModePerm)
+	os.MkdirAll(rtn.storePending, os.ModePerm)
 	return rtn
 }
 
@@ -46,26 +82,39 @@ func (client *Client) saveOrder(order *Order) error {
 	return writeJson(file, order)
 }
 
+// InitKey loads or creates account.jwk.json. When Signer is set, e.g. a
+// key held in an HSM, the account JWK is derived from its public key and
+// nothing is written to the store.
 func (client *Client) InitKey() error {
 	if client.JWK != nil {
 		return nil
 	}
-	var jwk = &JWK{}
+	if client.Signer != nil {
+		jwk, e := publicJWK(client.Signer.Public())
+		if e != nil {
+			return e
+		}
+		client.JWK = jwk
+		return nil
+	}
+	var jwk *JWK
 	var file = filepath.Join(client.storeRoot, "account.jwk.json")
 	_, e := os.Stat(file)
 	if e == nil {
-		bs, e := os.ReadFile(file)
+		bs, e := readSecret(file, client.KeyStore)
 		if e != nil {
-			log.Println(e)
 			return e
 		}
-		e = json.Unmarshal(bs, jwk)
+		jwk, e = parseJWK(bs)
 		if e != nil {
-			return e
+			return fmt.Errorf("%s: %w", file, e)
 		}
 	} else {
-		jwk = NewECDSA()
-		e = writeJson(file, jwk)
+		jwk, e = NewECDSA()
+		if e != nil {
+			return e
+		}
+		e = writeSecretJson(file, jwk, client.KeyStore)
 		if e != nil {
 			return e
 		}
@@ -74,16 +123,46 @@ func (client *Client) InitKey() error {
 	return nil
 }
 
+func (client *Client) accountSigner() (crypto.Signer, error) {
+	if client.Signer != nil {
+		return client.Signer, nil
+	}
+	pk, e := client.JWK.PrivateKey()
+	if e != nil {
+		return nil, fmt.Errorf("account key: %w", e)
+	}
+	return pk, nil
+}
+
+// request sends req and retries it according to client.Retry.
 func (client *Client) request(req HttpRequestParam) (*resty.Response, error) {
-	log.Println("request")
-	log.Println("req")
-	dumpJson(req)
+	for attempt := 0; ; attempt++ {
+		res, e := client.send(req)
+		if e == nil {
+			return res, nil
+		}
+		delay, ok := client.Retry.retry(req, e, attempt)
+		if !ok {
+			return nil, e
+		}
+		client.logger().Info("retrying request", "url", req.Url, "attempt", attempt+1, "delay", delay, "error", e)
+		time.Sleep(delay)
+	}
+}
+
+func (client *Client) send(req HttpRequestParam) (*resty.Response, error) {
+	logger := client.logger()
+	wire := client.wireEnabled()
 	e := client.InitKey()
 	if e != nil {
 		return nil, e
 	}
+	rc, e := client.http()
+	if e != nil {
+		return nil, e
+	}
 
-	r := resty.New().R()
+	r := rc.R()
 	r.Method = req.Method
 	r.URL = req.Url
 	if req.Method != "" && req.Method != http.MethodGet {
@@ -92,9 +171,16 @@ func (client *Client) request(req HttpRequestParam) (*resty.Response, error) {
 		if e != nil {
 			return nil, e
 		}
-		jwk := client.JWK
+		signer, e := client.accountSigner()
+		if e != nil {
+			return nil, e
+		}
+		alg, _, e := jwsAlg(signer.Public())
+		if e != nil {
+			return nil, e
+		}
 		protected := Protected{
-			Alg:   jwk.Alg,
+			Alg:   alg,
 			Nonce: nonce,
 			Url:   req.Url,
 		}
@@ -103,46 +189,47 @@ func (client *Client) request(req HttpRequestParam) (*resty.Response, error) {
 		} else {
 			protected.Jwk = json.RawMessage(client.JWK.Encode())
 		}
-		log.Println("protected")
-		dumpJson(protected)
+		if wire {
+			logger.Debug("jws", "url", req.Url, "protected", redact(protected), "payload", redact(req.Payload))
+		}
 
-		body := Req{
-			Protected: mustBase64Json(protected),
+		body := Req{}
+		body.Protected, e = base64Json(protected)
+		if e != nil {
+			return nil, e
 		}
 		if req.Payload != nil {
-			body.Payload = mustBase64Json(req.Payload)
+			body.Payload, e = base64Json(req.Payload)
+			if e != nil {
+				return nil, e
+			}
 		} else {
 			body.Payload = ""
 		}
-		sign, e := client.JWK.sign(body.Protected + "." + body.Payload)
+		sign, e := signJWS(signer, body.Protected+"."+body.Payload)
 		if e != nil {
 			return nil, e
 		}
 		body.Signature = sign
-		log.Println("body")
-		dumpJson(body)
 		r.SetBody(body)
 	}
 	if req.Result != nil {
 		r.SetResult(req.Result)
 	}
 	res, e := r.Send()
-	httpBody := ""
 	if e != nil {
-		log.Println(e)
-	}
-	if res != nil {
-		log.Println(res.Status())
-		log.Println("headers")
-		for k, v := range res.Header() {
-			log.Println(k, v)
+		logger.Warn("request failed", "method", req.Method, "url", req.Url, "error", e)
+	} else {
+		logger.Debug("request", "method", req.Method, "url", req.Url, "status", res.StatusCode())
+		if wire {
+			logger.Debug("response", "url", req.Url, "status", res.Status(), "header", flattenHeader(res.Header()), "body", redactBody(res.Header(), res.Body()))
 		}
-		httpBody = string(res.Body())
-		log.Println(httpBody)
 	}
 	if e != nil || !res.IsSuccess() {
 		if e == nil {
-			e = errors.New(httpBody)
+			problem := parseProblem(res.StatusCode(), res.Body())
+			problem.RetryAfter = parseRetryAfter(res.Header(), time.Now())
+			e = problem
 		}
 		return nil, e
 	}
@@ -154,22 +241,29 @@ func (client *Client) newNonce() (string, error) {
 	if e != nil {
 		return "", e
 	}
-	res, e := resty.New().R().Head(client.Directory.NewNonce)
-	if e != nil || !res.IsSuccess() {
-		log.Println(e)
+	rc, e := client.http()
+	if e != nil {
 		return "", e
 	}
-	return res.Header()["Replay-Nonce"][0], nil
+	res, e := rc.R().Head(client.Directory.NewNonce)
+	if e != nil {
+		return "", e
+	}
+	nonce := res.Header().Get("Replay-Nonce")
+	if !res.IsSuccess() || nonce == "" {
+		return "", fmt.Errorf("get nonce failed: %s", res.Status())
+	}
+	return nonce, nil
 }
 
 func (client *Client) InitDirectory() error {
 	if client.Directory != nil {
 		return nil
 	}
-	log.Println("------------------InitDirectory")
+	client.logger().Debug("init directory", "url", client.DirectoryUrl)
 	rtn := &Directory{}
 	_, e := client.request(HttpRequestParam{
-		Url:    "https://acme-staging-v02.api.letsencrypt.org/directory",
+		Url:    client.DirectoryUrl,
 		Method: http.MethodGet,
 		Result: rtn,
 	})
@@ -185,13 +279,13 @@ func (client *Client) GetLocalAccount() *Account {
 	if utils.FileExists(file) {
 		bs, e := os.ReadFile(file)
 		if e != nil {
-			log.Println(e)
+			client.logger().Warn("read account failed", "file", file, "error", e)
 			return nil
 		}
 		account := &Account{}
 		e = json.Unmarshal(bs, account)
 		if e != nil {
-			log.Println(e)
+			client.logger().Warn("read account failed", "file", file, "error", e)
 			return nil
 		}
 		return account
@@ -233,7 +327,7 @@ func (client *Client) InitAccount() error {
 		return e
 	}
 
-	log.Println("------------------InitAccount")
+	client.logger().Debug("init account")
 
 	e = client.InitDirectory()
 	if e != nil {
@@ -244,13 +338,11 @@ func (client *Client) InitAccount() error {
 	if utils.FileExists(file) {
 		bs, e := os.ReadFile(file)
 		if e != nil {
-			log.Println(e)
 			return e
 		}
 		account := &Account{}
 		e = json.Unmarshal(bs, account)
 		if e != nil {
-			log.Println(e)
 			return e
 		}
 		client.Account = account
@@ -263,7 +355,7 @@ func (client *Client) InitAccount() error {
 		Method: http.MethodPost,
 		Payload: NewAccountPayload{
 			TermsOfServiceAgreed: true,
-			Contact:              []string{"mailto:i@izzp.me"},
+			Contact:              client.Contact,
 		},
 		Result: rtn,
 	}
@@ -271,12 +363,14 @@ func (client *Client) InitAccount() error {
 	if e != nil {
 		return e
 	}
-	rtn.Uri = res.Header()["Location"][0]
+	rtn.Uri = res.Header().Get("Location")
+	if rtn.Uri == "" {
+		return errors.New("new account response has no Location header")
+	}
 	client.Account = rtn
-	dumpJson(rtn)
+	client.logger().Info("account created", "uri", rtn.Uri)
 	e = writeJson(file, rtn)
 	if e != nil {
-		log.Println(e)
 		return e
 	}
 	return nil
@@ -298,6 +392,7 @@ func (client *Client) FetchAccount() (*Account, error) {
 		return nil, e
 	}
 	rtn.Uri = client.Account.Uri
+	client.Account = rtn
 	file := filepath.Join(client.storeRoot, "account.json")
 	e = writeJson(file, rtn)
 	if e != nil {
@@ -306,10 +401,34 @@ func (client *Client) FetchAccount() (*Account, error) {
 	return rtn, nil
 }
 
+func (client *Client) DeactivateAccount() (*Account, error) {
+	e := client.InitAccount()
+	if e != nil {
+		return nil, e
+	}
+	rtn := &Account{}
+	_, e = client.request(HttpRequestParam{
+		Url:     client.Account.Uri,
+		Method:  http.MethodPost,
+		Kid:     client.Account.Uri,
+		Payload: map[string]string{"status": "deactivated"},
+		Result:  rtn,
+	})
+	if e != nil {
+		return nil, e
+	}
+	rtn.Uri = client.Account.Uri
+	client.Account = rtn
+	e = writeJson(filepath.Join(client.storeRoot, "account.json"), rtn)
+	if e != nil {
+		return nil, e
+	}
+	return rtn, nil
+}
+
 func (client *Client) GetLocalOrders() ([]*Order, error) {
 	entries, e := os.ReadDir(client.storeOrders)
 	if e != nil {
-		log.Println("read dir error", e)
 		return nil, e
 	}
 	rtn := make([]*Order, 0)
@@ -320,15 +439,12 @@ func (client *Client) GetLocalOrders() ([]*Order, error) {
 		file := filepath.Join(client.storeOrders, entry.Name())
 		bs, e := os.ReadFile(file)
 		if e != nil {
-			log.Println(e)
 			return nil, e
 		}
 		order := &Order{}
 		e = json.Unmarshal(bs, order)
 		if e != nil {
-			log.Println("read local order file failed")
-			log.Println(file)
-			log.Println(e)
+			client.logger().Warn("read local order file failed", "file", file, "error", e)
 		} else {
 			rtn = append(rtn, order)
 		}
@@ -354,43 +470,54 @@ func (client *Client) DelLocalOrders() error {
 func (client *Client) DelOrder(order *Order) error {
 	file := filepath.Join(client.storeOrders, "order-"+utils.Md5String([]byte(order.Uri))+".json")
 	e := os.Remove(file)
+	client.removePending(order)
 	return e
 }
 
 func (client *Client) NewOrder(identifiers []Identifier) (*Order, error) {
-	log.Println("----------------------------newOrder")
+	return client.newOrder(NewOrderPayload{Identifiers: identifiers}, "")
+}
+
+// newOrder creates an order, method is the challenge type that will be
+// used for the CAA check, empty when not known yet.
+func (client *Client) newOrder(payload NewOrderPayload, method string) (*Order, error) {
+	client.logger().Debug("new order", "identifiers", payload.Identifiers)
 	e := client.InitAccount()
 	if e != nil {
 		return nil, e
 	}
+	e = client.preflightCAA(payload.Identifiers, method)
+	if e != nil {
+		return nil, e
+	}
 	rtn := &Order{}
 	req := HttpRequestParam{
-		Url:    client.Directory.NewOrder,
-		Method: http.MethodPost,
-		Kid:    client.Account.Uri,
-		Result: rtn,
-		Payload: NewOrderPayload{
-			Identifiers: identifiers,
-		},
+		Url:     client.Directory.NewOrder,
+		Method:  http.MethodPost,
+		Kid:     client.Account.Uri,
+		Result:  rtn,
+		Payload: payload,
 	}
 	res, e := client.request(req)
 	if e != nil {
 		return nil, e
 	}
-	rtn.Uri = res.Header()["Location"][0]
-	dumpJson(rtn)
+	rtn.Uri = res.Header().Get("Location")
+	if rtn.Uri == "" {
+		return nil, errors.New("new order response has no Location header")
+	}
+	client.logger().Info("order created", "uri", rtn.Uri, "status", rtn.Status)
 	e = client.saveOrder(rtn)
 	if e != nil {
-		log.Println("保存order到本地失败", e)
+		client.logger().Warn("保存order到本地失败", "error", e)
 	}
 	return rtn, nil
 }
 
 func (client *Client) GetOrderAuth(authUrl string) (*Authorization, error) {
-	log.Println("------------------GetOrderInfo")
+	client.logger().Debug("get authorization", "url", authUrl)
 	e := client.InitAccount()
 	if e != nil {
-		log.Println(e)
 		return nil, e
 	}
 	rtn := &Authorization{}
@@ -408,13 +535,13 @@ func (client *Client) GetOrderAuth(authUrl string) (*Authorization, error) {
 }
 
 func (client *Client) FetchOrder(orderUrl string) (*Order, error) {
-	log.Println("-----------------------FetchOrder")
+	client.logger().Debug("fetch order", "url", orderUrl)
 	e := client.InitAccount()
 	if e != nil {
 		return nil, e
 	}
 	rtn := &Order{}
-	_, e = client.request(HttpRequestParam{
+	res, e := client.request(HttpRequestParam{
 		Url:    orderUrl,
 		Method: http.MethodPost,
 		Kid:    client.Account.Uri,
@@ -423,13 +550,14 @@ func (client *Client) FetchOrder(orderUrl string) (*Order, error) {
 	if e != nil {
 		return nil, e
 	}
+	rtn.RetryAfter = retryAfterSeconds(res.Header())
 	rtn.Uri = orderUrl
 	client.saveOrder(rtn)
 	return rtn, nil
 }
 
 func (client *Client) SubmitChallenge(challengeUrl string) (*Challenge, error) {
-	log.Println("------------------------SubmitChallenge")
+	client.logger().Debug("submit challenge", "url", challengeUrl)
 	rtn := &Challenge{}
 	_, e := client.request(HttpRequestParam{
 		Url:     challengeUrl,
@@ -444,41 +572,61 @@ func (client *Client) SubmitChallenge(challengeUrl string) (*Challenge, error) {
 	return rtn, nil
 }
 
+func (client *Client) KeyAuthorization(token string) string {
+	return token + "." + client.JWK.Thumbprint()
+}
+
 func (client *Client) GenDNSToken(token string) string {
-	pk := client.JWK.Encode()
-	b := sha256.Sum256([]byte(pk))
-	print := base64.RawURLEncoding.EncodeToString(b[:])
-	s := token + "." + print
-	b = sha256.Sum256([]byte(s))
+	return dnsValue(client.KeyAuthorization(token))
+}
+
+func dnsValue(keyAuth string) string {
+	b := sha256.Sum256([]byte(keyAuth))
 	return base64.RawURLEncoding.EncodeToString(b[:])
 }
 
+func (client *Client) pendingDir(order *Order) string {
+	return filepath.Join(client.storePending, utils.Md5String([]byte(order.Uri)))
+}
+
 func (client *Client) Finalize(order *Order) (*Order, error) {
-	certJwk := NewECDSA()
-	dir := filepath.Join(client.storeCerts, order.Identifiers[0].Value)
-	os.MkdirAll(dir, os.ModePerm)
-	file := filepath.Join(dir, "pk.json")
-	e := writeJson(file, certJwk)
+	pk, e := newCertKey(client.KeyType)
 	if e != nil {
-		log.Println("保证域名私钥失败", e)
 		return nil, e
 	}
-	pk := certJwk.PrivateKey()
-	bs, e := convertPkToPEM(pk)
+	dir := client.pendingDir(order)
+	e = os.MkdirAll(dir, os.ModePerm)
 	if e != nil {
-		log.Println("转换私钥失败", e)
-		return nil, e
+		return nil, fmt.Errorf("create pending dir: %w", e)
+	}
+	file := filepath.Join(dir, "pk.json")
+	if ec, ok := pk.(*ecdsa.PrivateKey); ok {
+		e = writeSecretJson(file, jwkFromECDSA(ec), client.KeyStore)
+		if e != nil {
+			return nil, fmt.Errorf("save private key: %w", e)
+		}
+	}
+	bs, e := encodeKeyPEM(pk)
+	if e != nil {
+		return nil, fmt.Errorf("encode private key: %w", e)
 	}
 	file = filepath.Join(dir, "privkey.pem")
-	e = os.WriteFile(file, bs, os.ModePerm)
+	e = writeSecret(file, bs, client.KeyStore)
 	if e != nil {
-		log.Println("保证域名私钥失败", e)
-		return nil, e
+		return nil, fmt.Errorf("save private key: %w", e)
+	}
+	template := &x509.CertificateRequest{}
+	for _, identifier := range order.Identifiers {
+		if identifier.Type == "ip" {
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(identifier.Value))
+		} else {
+			template.DNSNames = append(template.DNSNames, identifier.Value)
+		}
 	}
-	csr, e := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
-		Subject:  pkix.Name{CommonName: order.Identifiers[0].Value},
-		DNSNames: utils.SliceMap(order.Identifiers, func(v Identifier) string { return v.Value }),
-	}, pk)
+	if len(template.DNSNames) > 0 {
+		template.Subject = pkix.Name{CommonName: template.DNSNames[0]}
+	}
+	csr, e := x509.CreateCertificateRequest(rand.Reader, template, pk)
 	if e != nil {
 		return nil, e
 	}
@@ -494,91 +642,172 @@ func (client *Client) Finalize(order *Order) (*Order, error) {
 		Result:  rtn,
 	})
 	if res != nil && res.IsSuccess() {
-		value, e := strconv.Atoi(res.Header().Get("Retry-After"))
-		if e == nil {
-			rtn.RetryAfter = value
-		}
+		rtn.RetryAfter = retryAfterSeconds(res.Header())
 		rtn.Uri = res.Header().Get("Location")
 		if rtn.Uri == "" {
 			rtn.Uri = order.Uri
 		}
 		client.saveOrder(rtn)
+		if rtn.Status == OrderStatusInvalid {
+			client.removePending(rtn)
+		}
 	}
 	return rtn, e
 }
 
+// removePending deletes the pending dir of order, with the private key of
+// a certificate that will not be issued.
+func (client *Client) removePending(order *Order) {
+	e := os.RemoveAll(client.pendingDir(order))
+	if e != nil {
+		client.logger().Warn("删除待签发目录失败", "order", order.Uri, "error", e)
+	}
+}
+
 func (client *Client) DownloadCert(order *Order) (dir string, cert string, e error) {
-	res, e := client.request(HttpRequestParam{
-		Url:    order.Certificate,
-		Method: http.MethodPost,
-		Kid:    client.Account.Uri,
-	})
+	if len(order.Identifiers) == 0 {
+		return "", "", errors.New("order has no identifiers")
+	}
+	chain, info, e := client.selectChain(order)
 	if e != nil {
 		return "", "", e
 	}
-	body := string(res.Body())
-	if !res.IsSuccess() {
-		return "", "", errors.New(body)
-	}
-	dir = filepath.Join(client.storeCerts, order.Identifiers[0].Value)
-	os.MkdirAll(dir, os.ModePerm)
-	file := filepath.Join(dir, "fullchain.pem")
-	e = os.WriteFile(file, []byte(body), os.ModePerm)
+	body := chain.PEM
+	name, e := client.certName(order)
 	if e != nil {
 		return "", "", e
 	}
-	return dir, body, nil
+	renewal := utils.FileExists(client.certDir(name))
+	pending := client.pendingDir(order)
+	if !utils.FileExists(filepath.Join(pending, "privkey.pem")) {
+		// key was written straight into the cert dir by an older Finalize
+		name = order.Identifiers[0].Value
+		dir = client.certDir(name)
+		if !utils.FileExists(filepath.Join(dir, "privkey.pem")) {
+			return "", "", fmt.Errorf("no private key for order %s", order.Uri)
+		}
+		e = writeChain(dir, body, info)
+		if e != nil {
+			return "", "", e
+		}
+	} else {
+		e = writeChain(pending, body, info)
+		if e != nil {
+			return "", "", e
+		}
+		dir, e = client.installVersion(pending, name, order)
+		if e != nil {
+			return "", "", e
+		}
+	}
+	client.removePending(order)
+	event := &DeployEvent{
+		Name:          name,
+		Dir:           dir,
+		CertPath:      filepath.Join(dir, "fullchain.pem"),
+		KeyPath:       filepath.Join(dir, "privkey.pem"),
+		FullChainPath: filepath.Join(dir, "fullchain.pem"),
+		Domains:       utils.SliceMap(order.Identifiers, func(v Identifier) string { return v.Value }),
+		Renewal:       renewal,
+	}
+	return dir, body, client.runDeployHooks(event)
 }
 
+// GetLocalCerts loads the current version of every stored certificate.
 func (client *Client) GetLocalCerts() ([]Cert, error) {
-	rootDir := client.storeCerts
 	entries, e := os.ReadDir(client.storeCerts)
 	if e != nil {
 		return nil, e
 	}
 	rtn := make([]Cert, 0)
 	for _, entry := range entries {
-		cert := Cert{}
+		if !entry.IsDir() {
+			continue
+		}
+		cert, e := client.LoadStoredCert(entry.Name())
+		if e != nil {
+			client.logger().Warn("读取证书失败", "name", entry.Name(), "error", e)
+			continue
+		}
+		rtn = append(rtn, *cert)
+	}
+	return rtn, nil
+}
 
-		dir := filepath.Join(rootDir, entry.Name())
-		cert.Path = dir
+// LoadCert reads a certificate bundle written by DownloadCert. store may be
+// nil when the keys are not encrypted.
+func LoadCert(dir string, store KeyStore) (*Cert, error) {
+	cert := &Cert{Path: dir}
 
-		jwk, e := ReadJWKFromFile(path.Join(dir, "pk.json"))
+	file := path.Join(dir, "pk.json")
+	if utils.FileExists(file) {
+		bs, e := readSecret(file, store)
 		if e != nil {
-			log.Println("读取 jwk 失败", entry.Name(), e)
-			continue
+			return nil, fmt.Errorf("read pk.json: %w", e)
+		}
+		jwk, e := parseJWK(bs)
+		if e != nil {
+			return nil, fmt.Errorf("read pk.json: %w", e)
 		}
 		cert.JWK = jwk
+	}
 
-		bs, e := os.ReadFile(filepath.Join(dir, "privkey.pem"))
+	bs, e := readSecret(filepath.Join(dir, "privkey.pem"), store)
+	if e != nil {
+		return nil, fmt.Errorf("read privkey.pem: %w", e)
+	}
+	cert.PrivateKeyPEM = string(bs)
+	cert.PrivateKey, e = parseKeyPEM(bs)
+	if e != nil {
+		return nil, fmt.Errorf("parse privkey.pem: %w", e)
+	}
+
+	bs, e = os.ReadFile(filepath.Join(dir, "fullchain.pem"))
+	if e != nil {
+		return nil, fmt.Errorf("read fullchain.pem: %w", e)
+	}
+	cert.FullChainPEM = string(bs)
+	cert.Certs, e = parseCertsPEM(bs)
+	if e != nil {
+		return nil, fmt.Errorf("parse fullchain.pem: %w", e)
+	}
+
+	file = filepath.Join(dir, "chain.json")
+	if utils.FileExists(file) {
+		bs, e = os.ReadFile(file)
 		if e != nil {
-			log.Println("读取 privkey.pem 失败", entry.Name(), e)
-			continue
+			return nil, fmt.Errorf("read chain.json: %w", e)
 		}
-		cert.PrivateKeyPEM = string(bs)
-
-		bs, e = os.ReadFile(filepath.Join(dir, "fullchain.pem"))
+		info := &ChainInfo{}
+		e = json.Unmarshal(bs, info)
 		if e != nil {
-			log.Println("读取 fullchain.pem 失败", entry.Name(), e)
-			continue
+			return nil, fmt.Errorf("parse chain.json: %w", e)
 		}
-		cert.FullChainPEM = string(bs)
+		cert.Chain = info
+	}
 
-		cert.Certs = []*x509.Certificate{}
-		for {
-			var block *pem.Block
-			block, bs = pem.Decode(bs)
-			if block == nil {
-				break
-			}
-			c, e := x509.ParseCertificate(block.Bytes)
-			if e != nil {
-				fmt.Println("parse cert failed", block, e)
-				continue
-			}
-			cert.Certs = append(cert.Certs, c)
-		}
-		rtn = append(rtn, cert)
+	// an expired or mismatched response is not stapled, RefreshOCSP
+	// replaces it
+	if utils.FileExists(filepath.Join(dir, ocspFile)) {
+		resp, _ := LoadOCSP(cert)
+		cert.OCSPStaple = stapleOf(resp)
 	}
-	return rtn, nil
+	return cert, nil
+}
+
+func (client *Client) RevokeCert(cert *x509.Certificate, reason int) error {
+	e := client.InitAccount()
+	if e != nil {
+		return e
+	}
+	_, e = client.request(HttpRequestParam{
+		Url:    client.Directory.RevokeCert,
+		Method: http.MethodPost,
+		Kid:    client.Account.Uri,
+		Payload: RevokePayload{
+			Certificate: base64.RawURLEncoding.EncodeToString(cert.Raw),
+			Reason:      reason,
+		},
+	})
+	return e
 }
@@ -0,0 +1,294 @@
+package acme
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tonyzzp/acme/acmetest"
+)
+
+// newTestClient returns a client with a store in a temp dir talking to a
+// new acmetest server. Polling and retries are fast.
+func newTestClient(t *testing.T, options *acmetest.Options) (*Client, *acmetest.Server) {
+	t.Helper()
+	s, e := acmetest.NewServer(options)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(s.Close)
+	client := NewAcmeClient(t.TempDir())
+	client.DirectoryUrl = s.DirectoryURL()
+	client.PollInterval = 10 * time.Millisecond
+	client.PollTimeout = 10 * time.Second
+	client.Retry = &RetryPolicy{MaxRetries: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Second}
+	return client, s
+}
+
+// acceptSolver presents nothing, acmetest accepts challenges unless it
+// validates them. It answers dns-01 unless challenge is set.
+type acceptSolver struct {
+	challenge string
+	lock      sync.Mutex
+	presented []string
+	cleaned   []string
+}
+
+func (solver *acceptSolver) Type() string {
+	if solver.challenge != "" {
+		return solver.challenge
+	}
+	return ChallengeTypeDNS01
+}
+
+func (solver *acceptSolver) Present(domain string, token string, keyAuth string) error {
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	solver.presented = append(solver.presented, domain)
+	return nil
+}
+
+func (solver *acceptSolver) CleanUp(domain string, token string, keyAuth string) error {
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	solver.cleaned = append(solver.cleaned, domain)
+	return nil
+}
+
+func dnsIdentifiers(names ...string) []Identifier {
+	rtn := make([]Identifier, 0)
+	for _, name := range names {
+		rtn = append(rtn, Identifier{Type: "dns", Value: name})
+	}
+	return rtn
+}
+
+// obtainTestCert obtains a certificate for names and loads it.
+func obtainTestCert(t *testing.T, client *Client, names ...string) *Cert {
+	t.Helper()
+	dir, e := client.ObtainCert(dnsIdentifiers(names...), &acceptSolver{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	cert, e := LoadCert(dir, client.KeyStore)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return cert
+}
+
+func verifyTestCert(t *testing.T, s *acmetest.Server, cert *Cert, name string) {
+	t.Helper()
+	intermediates := x509.NewCertPool()
+	for _, c := range cert.Certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, e := cert.Certs[0].Verify(x509.VerifyOptions{DNSName: name, Roots: s.Roots(), Intermediates: intermediates})
+	if e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestAccount(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	e := client.InitAccount()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if client.Account == nil || client.Account.Uri == "" {
+		t.Fatal("account has no uri")
+	}
+	account, e := client.FetchAccount()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if account.Status != "valid" {
+		t.Fatalf("account status %q", account.Status)
+	}
+	if local := client.GetLocalAccount(); local == nil || local.Uri != account.Uri {
+		t.Fatal("account not saved to the store")
+	}
+}
+
+func TestOrderFinalizeDownload(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	order, e := client.NewOrder(dnsIdentifiers("example.test", "www.example.test"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if order.Status != OrderStatusPending || len(order.Authorizations) != 2 {
+		t.Fatalf("new order is %s with %d authorizations", order.Status, len(order.Authorizations))
+	}
+	for _, url := range order.Authorizations {
+		auth, e := client.GetOrderAuth(url)
+		if e != nil {
+			t.Fatal(e)
+		}
+		challenge := auth.Challenges[0]
+		_, e = client.SubmitChallenge(challenge.Url)
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	order, e = client.waitOrder(order, OrderStatusPending)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if order.Status != OrderStatusReady {
+		t.Fatalf("order is %s after the challenges", order.Status)
+	}
+	_, e = client.Finalize(order)
+	if e != nil {
+		t.Fatal(e)
+	}
+	order, e = client.waitOrder(order, OrderStatusReady, OrderStatusProcessing)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if order.Status != OrderStatusValid || order.Certificate == "" {
+		t.Fatalf("order is %s after finalize", order.Status)
+	}
+	dir, body, e := client.DownloadCert(order)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(body, "BEGIN CERTIFICATE") {
+		t.Fatal("downloaded chain is not PEM")
+	}
+	cert, e := client.LoadStoredCert("example.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if cert.Version != 1 || cert.Path != dir {
+		t.Fatalf("stored as version %d in %s, downloaded to %s", cert.Version, cert.Path, dir)
+	}
+	verifyTestCert(t, s, cert, "www.example.test")
+}
+
+func TestObtainWildcard(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	solver := &acceptSolver{}
+	dir, e := client.ObtainCert(dnsIdentifiers("example.test", "*.example.test"), solver)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(solver.presented) != 2 || len(solver.cleaned) != 2 {
+		t.Fatalf("presented %v, cleaned %v", solver.presented, solver.cleaned)
+	}
+	cert, e := LoadCert(dir, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	verifyTestCert(t, s, cert, "any.example.test")
+}
+
+func TestBadNonceRetry(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	e := client.InitAccount()
+	if e != nil {
+		t.Fatal(e)
+	}
+	s.InjectBadNonce(2)
+	_, e = client.NewOrder(dnsIdentifiers("example.test"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	s.InjectBadNonce(5)
+	_, e = client.NewOrder(dnsIdentifiers("example.test"))
+	problem := &Problem{}
+	if !errors.As(e, &problem) || problem.Type != ProblemBadNonce {
+		t.Fatalf("expected badNonce after the retries, got %v", e)
+	}
+}
+
+func TestRateLimitedRetry(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	e := client.InitAccount()
+	if e != nil {
+		t.Fatal(e)
+	}
+	s.FailRequests(2, http.StatusTooManyRequests, 0)
+	_, e = client.FetchAccount()
+	if e != nil {
+		t.Fatal(e)
+	}
+	// new orders are not idempotent and not retried by default
+	s.FailRequests(1, http.StatusServiceUnavailable, 0)
+	_, e = client.NewOrder(dnsIdentifiers("example.test"))
+	problem := &Problem{}
+	if !errors.As(e, &problem) || problem.Status != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %v", e)
+	}
+	client.Retry.RetryNonIdempotent = true
+	s.FailRequests(1, http.StatusServiceUnavailable, 0)
+	_, e = client.NewOrder(dnsIdentifiers("example.test"))
+	if e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	e := client.InitAccount()
+	if e != nil {
+		t.Fatal(e)
+	}
+	s.FailRequests(1, http.StatusTooManyRequests, time.Hour)
+	start := time.Now()
+	_, e = client.FetchAccount()
+	problem := &Problem{}
+	if !errors.As(e, &problem) || problem.RetryAfter.IsZero() {
+		t.Fatalf("expected a problem with Retry-After, got %v", e)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("waited for a Retry-After beyond MaxBackoff")
+	}
+}
+
+func TestAlternateChains(t *testing.T) {
+	client, s := newTestClient(t, &acmetest.Options{AlternateChains: 1})
+	cert := obtainTestCert(t, client, "example.test")
+	if cert.Chain == nil || !cert.Chain.Default || len(cert.Chain.Alternates) != 1 {
+		t.Fatalf("chain info %+v", cert.Chain)
+	}
+	if cert.Certs[len(cert.Certs)-1].CheckSignatureFrom(s.Root(0)) != nil {
+		t.Fatal("default chain does not end at root 0")
+	}
+
+	client.PreferredChain = &ChainPreference{IssuerCN: s.Root(1).Subject.CommonName}
+	cert = obtainTestCert(t, client, "example.test")
+	if cert.Chain.Default || !cert.Chain.Preferred {
+		t.Fatalf("alternate chain not selected: %+v", cert.Chain)
+	}
+	if e := cert.Certs[len(cert.Certs)-1].CheckSignatureFrom(s.Root(1)); e != nil {
+		t.Fatal(e)
+	}
+	verifyTestCert(t, s, cert, "example.test")
+
+	client.PreferredChain = &ChainPreference{IssuerCN: "nobody"}
+	cert = obtainTestCert(t, client, "example.test")
+	if !cert.Chain.Default || cert.Chain.Preferred {
+		t.Fatalf("default chain not kept when nothing matches: %+v", cert.Chain)
+	}
+}
+
+func TestProcessingDelay(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	s.SetProcessingDelay(200 * time.Millisecond)
+	start := time.Now()
+	cert := obtainTestCert(t, client, "example.test")
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("order was not kept processing")
+	}
+	verifyTestCert(t, s, cert, "example.test")
+
+	client.PollTimeout = 50 * time.Millisecond
+	_, e := client.ObtainCert(dnsIdentifiers("slow.test"), &acceptSolver{})
+	if !errors.Is(e, ErrPollTimeout) {
+		t.Fatalf("expected a poll timeout, got %v", e)
+	}
+}
@@ -0,0 +1,193 @@
+package acme
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// acmeDNSFile holds the acme-dns registrations in the store.
+const acmeDNSFile = "acme-dns.json"
+
+// AcmeDNSAccount is a registration at an acme-dns server, the
+// _acme-challenge name of its domain has to be a CNAME of FullDomain.
+type AcmeDNSAccount struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	FullDomain string   `json:"fulldomain"`
+	SubDomain  string   `json:"subdomain"`
+	AllowFrom  []string `json:"allowfrom,omitempty"`
+}
+
+// AcmeDNSProvider publishes dns-01 records through the HTTP API of an
+// acme-dns compatible server. Every domain gets its own registration,
+// saved in Storage. The first Present for a domain registers it and fails
+// asking for the CNAME to be created, later ones update the TXT record.
+// acme-dns keeps the two latest values, enough for a name and its
+// wildcard, so CleanUp does nothing.
+type AcmeDNSProvider struct {
+	Server string
+	// Storage is the JSON file of the registrations by domain, sealed
+	// with KeyStore when it is set.
+	Storage   string
+	KeyStore  KeyStore
+	AllowFrom []string
+	// Logger is the logger of the client the provider was made by, or
+	// slog.Default(), when nil.
+	Logger *slog.Logger
+	lock   sync.Mutex
+	// client shares its proxy, roots, timeout and User-Agent, a plain
+	// resty client is used when nil.
+	client   *Client
+	httpLock sync.Mutex
+	rc       *resty.Client
+}
+
+// NewAcmeDNSProvider returns a provider for server keeping its
+// registrations in the store, sealed like the private keys. It talks to
+// server like client talks to the CA.
+func (client *Client) NewAcmeDNSProvider(server string) *AcmeDNSProvider {
+	return &AcmeDNSProvider{
+		Server:   server,
+		Storage:  filepath.Join(client.storeRoot, acmeDNSFile),
+		KeyStore: client.KeyStore,
+		client:   client,
+	}
+}
+
+func (p *AcmeDNSProvider) logger() *slog.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	if p.client != nil {
+		return p.client.logger()
+	}
+	return slog.Default()
+}
+
+func (p *AcmeDNSProvider) http() (*resty.Client, error) {
+	if p.client != nil {
+		return p.client.http()
+	}
+	p.httpLock.Lock()
+	defer p.httpLock.Unlock()
+	if p.rc == nil {
+		p.rc = resty.New().SetTimeout(30 * time.Second)
+	}
+	return p.rc, nil
+}
+
+func (p *AcmeDNSProvider) url(path string) string {
+	return strings.TrimSuffix(p.Server, "/") + path
+}
+
+func (p *AcmeDNSProvider) accounts() (map[string]*AcmeDNSAccount, error) {
+	rtn := make(map[string]*AcmeDNSAccount)
+	bs, e := readSecret(p.Storage, p.KeyStore)
+	if errors.Is(e, os.ErrNotExist) {
+		return rtn, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	e = json.Unmarshal(bs, &rtn)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %w", p.Storage, e)
+	}
+	return rtn, nil
+}
+
+// Account returns the registration of domain, nil when there is none.
+func (p *AcmeDNSProvider) Account(domain string) (*AcmeDNSAccount, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	accounts, e := p.accounts()
+	if e != nil {
+		return nil, e
+	}
+	return accounts[strings.ToLower(domain)], nil
+}
+
+// Register registers domain at the server and saves the credentials.
+func (p *AcmeDNSProvider) Register(domain string) (*AcmeDNSAccount, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	accounts, e := p.accounts()
+	if e != nil {
+		return nil, e
+	}
+	rc, e := p.http()
+	if e != nil {
+		return nil, e
+	}
+	req := rc.R()
+	if len(p.AllowFrom) > 0 {
+		req.SetBody(map[string]any{"allowfrom": p.AllowFrom})
+	}
+	res, e := req.Post(p.url("/register"))
+	if e != nil {
+		return nil, e
+	}
+	if !res.IsSuccess() {
+		return nil, fmt.Errorf("acme-dns register: %s %s", res.Status(), strings.TrimSpace(res.String()))
+	}
+	rtn := &AcmeDNSAccount{}
+	e = json.Unmarshal(res.Body(), rtn)
+	if e != nil {
+		return nil, fmt.Errorf("acme-dns register: %w", e)
+	}
+	if rtn.Username == "" || rtn.FullDomain == "" {
+		return nil, errors.New("acme-dns register: incomplete response")
+	}
+	accounts[strings.ToLower(domain)] = rtn
+	e = writeSecretJson(p.Storage, accounts, p.KeyStore)
+	if e != nil {
+		return nil, e
+	}
+	return rtn, nil
+}
+
+func (p *AcmeDNSProvider) Present(record *DNSRecord) error {
+	account, e := p.Account(record.Domain)
+	if e != nil {
+		return e
+	}
+	if account == nil {
+		account, e = p.Register(record.Domain)
+		if e != nil {
+			return e
+		}
+		return fmt.Errorf("registered %s at acme-dns, create the record %s CNAME %s. and try again", record.Domain, record.Challenge, account.FullDomain)
+	}
+	if !strings.EqualFold(record.FQDN, account.FullDomain+".") {
+		p.logger().Warn("_acme-challenge is not delegated to acme-dns, validation will fail", "challenge", record.Challenge, "fqdn", record.FQDN, "want", account.FullDomain)
+	}
+	rc, e := p.http()
+	if e != nil {
+		return e
+	}
+	res, e := rc.R().
+		SetHeader("X-Api-User", account.Username).
+		SetHeader("X-Api-Key", account.Password).
+		SetBody(map[string]string{"subdomain": account.SubDomain, "txt": record.Value}).
+		Post(p.url("/update"))
+	if e != nil {
+		return e
+	}
+	if !res.IsSuccess() {
+		return fmt.Errorf("acme-dns update %s: %s %s", record.Domain, res.Status(), strings.TrimSpace(res.String()))
+	}
+	return nil
+}
+
+func (p *AcmeDNSProvider) CleanUp(record *DNSRecord) error {
+	return nil
+}
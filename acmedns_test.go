@@ -0,0 +1,75 @@
+package acme
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAcmeDNS is an acme-dns API keeping the last value of every
+// subdomain.
+type fakeAcmeDNS struct {
+	lock       sync.Mutex
+	userAgents []string
+	txt        map[string]string
+}
+
+func (f *fakeAcmeDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.userAgents = append(f.userAgents, r.UserAgent())
+	switch r.URL.Path {
+	case "/register":
+		json.NewEncoder(w).Encode(&AcmeDNSAccount{
+			Username:   "user",
+			Password:   "secret",
+			FullDomain: "d420c923.auth.example.net",
+			SubDomain:  "d420c923",
+		})
+	case "/update":
+		if r.Header.Get("X-Api-User") != "user" || r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		f.txt[body["subdomain"]] = body["txt"]
+		json.NewEncoder(w).Encode(map[string]string{"txt": body["txt"]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestAcmeDNSProviderSharedClient(t *testing.T) {
+	fake := &fakeAcmeDNS{txt: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := NewAcmeClient(t.TempDir())
+	client.UserAgent = "acme-dns-test"
+	p := client.NewAcmeDNSProvider(server.URL + "/")
+	record := &DNSRecord{
+		Domain:    "example.test",
+		Challenge: "_acme-challenge.example.test.",
+		FQDN:      "d420c923.auth.example.net.",
+		Value:     "value",
+	}
+	e := p.Present(record)
+	if e == nil || !strings.Contains(e.Error(), "CNAME d420c923.auth.example.net") {
+		t.Fatalf("expected to be asked for the CNAME, got %v", e)
+	}
+	e = p.Present(record)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if fake.txt["d420c923"] != "value" {
+		t.Fatalf("txt %v", fake.txt)
+	}
+	for _, ua := range fake.userAgents {
+		if !strings.HasSuffix(ua, " acme-dns-test") {
+			t.Fatalf("request without the client User-Agent: %q", ua)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package acmetest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// ca is an in-memory two level CA. Every alternate chain has its own root
+// that cross signs the same intermediate key, so a leaf validates through
+// any of them.
+type ca struct {
+	key           *ecdsa.PrivateKey
+	roots         []*x509.Certificate
+	intermediates []*x509.Certificate
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func subjectKeyId(pub crypto.PublicKey) ([]byte, error) {
+	der, e := x509.MarshalPKIXPublicKey(pub)
+	if e != nil {
+		return nil, e
+	}
+	sum := sha1.Sum(der)
+	return sum[:], nil
+}
+
+func newCA(alternates int) (*ca, error) {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		return nil, e
+	}
+	keyId, e := subjectKeyId(&key.PublicKey)
+	if e != nil {
+		return nil, e
+	}
+	rtn := &ca{key: key}
+	now := time.Now()
+	for i := 0; i <= alternates; i++ {
+		name := "acmetest root"
+		if i > 0 {
+			name = fmt.Sprintf("acmetest alternate root %d", i)
+		}
+		rootKey, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if e != nil {
+			return nil, e
+		}
+		serial, e := randomSerial()
+		if e != nil {
+			return nil, e
+		}
+		template := &x509.Certificate{
+			SerialNumber:          serial,
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, e := x509.CreateCertificate(rand.Reader, template, template, &rootKey.PublicKey, rootKey)
+		if e != nil {
+			return nil, e
+		}
+		root, e := x509.ParseCertificate(der)
+		if e != nil {
+			return nil, e
+		}
+		serial, e = randomSerial()
+		if e != nil {
+			return nil, e
+		}
+		template = &x509.Certificate{
+			SerialNumber:          serial,
+			Subject:               pkix.Name{CommonName: "acmetest intermediate"},
+			SubjectKeyId:          keyId,
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(5 * 365 * 24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			MaxPathLenZero:        true,
+		}
+		der, e = x509.CreateCertificate(rand.Reader, template, root, &key.PublicKey, rootKey)
+		if e != nil {
+			return nil, e
+		}
+		intermediate, e := x509.ParseCertificate(der)
+		if e != nil {
+			return nil, e
+		}
+		rtn.roots = append(rtn.roots, root)
+		rtn.intermediates = append(rtn.intermediates, intermediate)
+	}
+	return rtn, nil
+}
+
+func (ca *ca) issue(csr *x509.CertificateRequest, validity time.Duration, ocspServer string) (*x509.Certificate, error) {
+	serial, e := randomSerial()
+	if e != nil {
+		return nil, e
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(validity),
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{ocspServer},
+	}
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, e := x509.CreateCertificate(rand.Reader, template, ca.intermediates[0], csr.PublicKey, ca.key)
+	if e != nil {
+		return nil, e
+	}
+	return x509.ParseCertificate(der)
+}
+
+// chainPEM returns the leaf followed by the intermediate of chain n.
+func (ca *ca) chainPEM(leaf *x509.Certificate, n int) []byte {
+	buf := &bytes.Buffer{}
+	pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: ca.intermediates[n].Raw})
+	return buf.Bytes()
+}
+
+// csrIdentifiers returns the names a CSR asks for, the common name included.
+func csrIdentifiers(csr *x509.CertificateRequest) []identifier {
+	rtn := make([]identifier, 0)
+	seen := make(map[string]bool)
+	add := func(typ string, value string) {
+		if value == "" || seen[typ+":"+value] {
+			return
+		}
+		seen[typ+":"+value] = true
+		rtn = append(rtn, identifier{Type: typ, Value: value})
+	}
+	if net.ParseIP(csr.Subject.CommonName) != nil {
+		add("ip", net.ParseIP(csr.Subject.CommonName).String())
+	} else {
+		add("dns", csr.Subject.CommonName)
+	}
+	for _, name := range csr.DNSNames {
+		add("dns", name)
+	}
+	for _, ip := range csr.IPAddresses {
+		add("ip", ip.String())
+	}
+	return rtn
+}
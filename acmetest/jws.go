@@ -0,0 +1,155 @@
+package acmetest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+type jwsBody struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	Url   string          `json:"url"`
+	Kid   string          `json:"kid"`
+	Jwk   json.RawMessage `json:"jwk"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	bs, e := base64.RawURLEncoding.DecodeString(s)
+	if e != nil {
+		return nil, e
+	}
+	return new(big.Int).SetBytes(bs), nil
+}
+
+// parseJWK returns the public key and its RFC 7638 thumbprint.
+func parseJWK(raw json.RawMessage) (crypto.PublicKey, string, error) {
+	v := &jwk{}
+	e := json.Unmarshal(raw, v)
+	if e != nil {
+		return nil, "", e
+	}
+	var canonical string
+	var pub crypto.PublicKey
+	switch v.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch v.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, "", fmt.Errorf("unsupported curve %q", v.Crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, e := base64.RawURLEncoding.DecodeString(v.X)
+		if e != nil || len(x) != size {
+			return nil, "", fmt.Errorf("bad x coordinate")
+		}
+		y, e := base64.RawURLEncoding.DecodeString(v.Y)
+		if e != nil || len(y) != size {
+			return nil, "", fmt.Errorf("bad y coordinate")
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if _, e := key.ECDH(); e != nil {
+			return nil, "", fmt.Errorf("point is not on the curve")
+		}
+		pub = key
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, v.Crv, v.X, v.Y)
+	case "RSA":
+		n, e := decodeInt(v.N)
+		if e != nil {
+			return nil, "", e
+		}
+		exp, e := decodeInt(v.E)
+		if e != nil {
+			return nil, "", e
+		}
+		if n.BitLen() < 2048 {
+			return nil, "", fmt.Errorf("rsa key too small")
+		}
+		pub = &rsa.PublicKey{N: n, E: int(exp.Int64())}
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, v.E, v.N)
+	default:
+		return nil, "", fmt.Errorf("unsupported kty %q", v.Kty)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return pub, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func verifyJWS(pub crypto.PublicKey, alg string, data string, signature string) error {
+	sig, e := base64.RawURLEncoding.DecodeString(signature)
+	if e != nil {
+		return e
+	}
+	var hash crypto.Hash
+	switch alg {
+	case "ES256", "RS256":
+		hash = crypto.SHA256
+	case "ES384":
+		hash = crypto.SHA384
+	case "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(data))
+	digest := h.Sum(nil)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		want := map[int]string{32: "ES256", 48: "ES384", 66: "ES512"}[size]
+		if alg != want {
+			return fmt.Errorf("alg %s does not match the key", alg)
+		}
+		if len(sig) != 2*size {
+			return fmt.Errorf("signature is %d bytes, want %d", len(sig), 2*size)
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("alg %s does not match the key", alg)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	}
+	return fmt.Errorf("unsupported key %T", pub)
+}
+
+func keyAuthorization(token string, thumbprint string) string {
+	return token + "." + thumbprint
+}
+
+func dnsValue(keyAuth string) string {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
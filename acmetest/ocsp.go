@@ -0,0 +1,82 @@
+package acmetest
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// SetOCSPUnavailable makes the OCSP responder answer 503.
+func (s *Server) SetOCSPUnavailable(down bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ocspDown = down
+}
+
+// OCSPRequests returns the number of OCSP requests served so far.
+func (s *Server) OCSPRequests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ocspRequests
+}
+
+// handleOCSP answers RFC 6960 requests over POST and GET, signed by the
+// intermediate key.
+func (s *Server) handleOCSP(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	var e error
+	if r.Method == http.MethodGet {
+		var raw string
+		raw, e = url.PathUnescape(r.PathValue("request"))
+		if e == nil {
+			der, e = base64.StdEncoding.DecodeString(raw)
+		}
+	} else {
+		der, e = io.ReadAll(io.LimitReader(r.Body, 4096))
+	}
+	var req *ocsp.Request
+	if e == nil {
+		req, e = ocsp.ParseRequest(der)
+	}
+	if e != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	s.lock.Lock()
+	s.ocspRequests++
+	down := s.ocspDown
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute).Truncate(time.Second),
+	}
+	template.NextUpdate = template.ThisUpdate.Add(s.options.OCSPValidity)
+	for _, c := range s.certs {
+		if c.cert.SerialNumber.Cmp(req.SerialNumber) != 0 {
+			continue
+		}
+		template.Status = ocsp.Good
+		if c.revoked {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = c.revokedAt.Truncate(time.Second)
+			template.RevocationReason = c.reason
+		}
+	}
+	s.lock.Unlock()
+	if down {
+		http.Error(w, "ocsp responder unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	issuer := s.ca.intermediates[0]
+	bs, e := ocsp.CreateResponse(issuer, issuer, template, s.ca.key)
+	if e != nil {
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(bs)
+}
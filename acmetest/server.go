@@ -0,0 +1,1184 @@
+// Package acmetest runs an RFC 8555 ACME server on httptest, backed by an
+// in-memory CA, so the client can be exercised without a real CA:
+//
+//	server, e := acmetest.NewServer(nil)
+//	defer server.Close()
+//	client := acme.NewAcmeClient(dir)
+//	client.DirectoryUrl = server.DirectoryURL()
+//
+// By default every submitted challenge becomes valid. Set
+// Options.ValidateChallenges to really fetch http-01, dns-01 and tls-alpn-01
+// responses.
+package acmetest
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ChallengeHTTP01 = "http-01"
+const ChallengeDNS01 = "dns-01"
+const ChallengeTLSALPN01 = "tls-alpn-01"
+
+const (
+	statusPending     = "pending"
+	statusProcessing  = "processing"
+	statusReady       = "ready"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+)
+
+type Options struct {
+	// ValidateChallenges makes the server validate challenges against
+	// HTTPPort, TLSPort and LookupTXT instead of accepting them.
+	ValidateChallenges bool
+	HTTPPort           int
+	TLSPort            int
+	// Dial and LookupTXT replace the network when validating.
+	Dial      func(ctx context.Context, network string, addr string) (net.Conn, error)
+	LookupTXT func(ctx context.Context, name string) ([]string, error)
+	// ProcessingDelay keeps challenges and finalized orders processing.
+	ProcessingDelay time.Duration
+	// AlternateChains is the number of chains offered with Link
+	// rel="alternate" besides the default one.
+	AlternateChains int
+	// OrderRateLimit allows that many new orders per account in
+	// RateLimitWindow, 0 is unlimited.
+	OrderRateLimit  int
+	RateLimitWindow time.Duration
+	// OrdersPageSize splits the account orders list into pages linked
+	// with rel="next", 0 is one page.
+	OrdersPageSize int
+	CertValidity   time.Duration
+	// OCSPValidity is the time between thisUpdate and nextUpdate of OCSP
+	// responses, one day by default.
+	OCSPValidity time.Duration
+	// CAAIdentities are published in the directory meta.
+	CAAIdentities []string
+	// TLS serves the API over https with a self signed certificate, see
+	// Server.TLSCertificate.
+	TLS bool
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type problem struct {
+	Type       string      `json:"type"`
+	Detail     string      `json:"detail,omitempty"`
+	Status     int         `json:"status,omitempty"`
+	Identifier *identifier `json:"identifier,omitempty"`
+}
+
+type account struct {
+	id         string
+	key        crypto.PublicKey
+	thumbprint string
+	status     string
+	contact    []string
+	createdAt  time.Time
+	orders     []string
+	newOrders  []time.Time
+}
+
+type order struct {
+	id          string
+	account     string
+	status      string
+	expires     time.Time
+	identifiers []identifier
+	authzs      []string
+	notBefore   string
+	notAfter    string
+	replaces    string
+	cert        string
+	err         *problem
+}
+
+type authz struct {
+	id         string
+	account    string
+	identifier identifier
+	wildcard   bool
+	status     string
+	expires    time.Time
+	challenges []string
+}
+
+type challenge struct {
+	id        string
+	authz     string
+	typ       string
+	token     string
+	status    string
+	validated time.Time
+	err       *problem
+}
+
+type issued struct {
+	id        string
+	account   string
+	cert      *x509.Certificate
+	revoked   bool
+	revokedAt time.Time
+	reason    int
+	window    [2]time.Time
+}
+
+// Server is an ACME CA for tests. Its behaviour may be changed while it
+// runs.
+type Server struct {
+	options      Options
+	srv          *httptest.Server
+	ca           *ca
+	lock         sync.Mutex
+	nextId       int
+	nonceLock    sync.Mutex
+	nonces       map[string]bool
+	badNonces    int
+	failures     int
+	failStatus   int
+	failRetry    time.Duration
+	ocspDown     bool
+	ocspRequests int
+	accounts     map[string]*account
+	orders       map[string]*order
+	authzs       map[string]*authz
+	challenges   map[string]*challenge
+	certs        map[string]*issued
+}
+
+// NewServer starts a server. options may be nil.
+func NewServer(options *Options) (*Server, error) {
+	s := &Server{
+		nonces:     make(map[string]bool),
+		accounts:   make(map[string]*account),
+		orders:     make(map[string]*order),
+		authzs:     make(map[string]*authz),
+		challenges: make(map[string]*challenge),
+		certs:      make(map[string]*issued),
+	}
+	if options != nil {
+		s.options = *options
+	}
+	if s.options.HTTPPort == 0 {
+		s.options.HTTPPort = 80
+	}
+	if s.options.TLSPort == 0 {
+		s.options.TLSPort = 443
+	}
+	if s.options.RateLimitWindow == 0 {
+		s.options.RateLimitWindow = time.Hour
+	}
+	if s.options.OCSPValidity == 0 {
+		s.options.OCSPValidity = 24 * time.Hour
+	}
+	if s.options.CertValidity == 0 {
+		s.options.CertValidity = 90 * 24 * time.Hour
+	}
+	var e error
+	s.ca, e = newCA(s.options.AlternateChains)
+	if e != nil {
+		return nil, e
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dir", s.handleDirectory)
+	mux.HandleFunc("HEAD /nonce", s.handleNonce)
+	mux.HandleFunc("GET /nonce", s.handleNonce)
+	mux.HandleFunc("POST /new-acct", s.handleNewAccount)
+	mux.HandleFunc("POST /acct/{id}", s.handleAccount)
+	mux.HandleFunc("POST /acct/{id}/orders", s.handleAccountOrders)
+	mux.HandleFunc("POST /new-order", s.handleNewOrder)
+	mux.HandleFunc("POST /order/{id}", s.handleOrder)
+	mux.HandleFunc("POST /authz/{id}", s.handleAuthz)
+	mux.HandleFunc("POST /chall/{id}", s.handleChallenge)
+	mux.HandleFunc("POST /finalize/{id}", s.handleFinalize)
+	mux.HandleFunc("POST /cert/{id}", s.handleCert)
+	mux.HandleFunc("POST /cert/{id}/{n}", s.handleCert)
+	mux.HandleFunc("POST /revoke-cert", s.handleRevoke)
+	mux.HandleFunc("GET /renewal-info/{id}", s.handleRenewalInfo)
+	mux.HandleFunc("POST /ocsp", s.handleOCSP)
+	mux.HandleFunc("GET /ocsp/{request...}", s.handleOCSP)
+	if s.options.TLS {
+		s.srv = httptest.NewTLSServer(mux)
+	} else {
+		s.srv = httptest.NewServer(mux)
+	}
+	return s, nil
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+func (s *Server) DirectoryURL() string {
+	return s.srv.URL + "/dir"
+}
+
+// TLSCertificate returns the certificate of the API when Options.TLS is
+// set, clients have to trust it.
+func (s *Server) TLSCertificate() *x509.Certificate {
+	return s.srv.Certificate()
+}
+
+// Roots returns the roots of the default and every alternate chain.
+func (s *Server) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, root := range s.ca.roots {
+		pool.AddCert(root)
+	}
+	return pool
+}
+
+// Root returns the root of chain n, 0 being the default chain.
+func (s *Server) Root(n int) *x509.Certificate {
+	return s.ca.roots[n]
+}
+
+// InjectBadNonce rejects the next n signed requests with badNonce.
+func (s *Server) InjectBadNonce(n int) {
+	s.nonceLock.Lock()
+	defer s.nonceLock.Unlock()
+	s.badNonces = n
+}
+
+// FailRequests answers the next n signed requests with status, 429 or 503,
+// and a Retry-After of retryAfter when it is not 0.
+func (s *Server) FailRequests(n int, status int, retryAfter time.Duration) {
+	s.nonceLock.Lock()
+	defer s.nonceLock.Unlock()
+	s.failures = n
+	s.failStatus = status
+	s.failRetry = retryAfter
+}
+
+func (s *Server) SetRateLimit(orders int, window time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.options.OrderRateLimit = orders
+	s.options.RateLimitWindow = window
+}
+
+func (s *Server) SetProcessingDelay(delay time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.options.ProcessingDelay = delay
+}
+
+func (s *Server) SetValidateChallenges(validate bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.options.ValidateChallenges = validate
+}
+
+// SetRenewalWindow changes the ARI suggested window of an issued
+// certificate.
+func (s *Server) SetRenewalWindow(cert *x509.Certificate, start time.Time, end time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.findCert(cert)
+	if c == nil {
+		return fmt.Errorf("certificate %s was not issued by this server", cert.SerialNumber)
+	}
+	c.window = [2]time.Time{start, end}
+	return nil
+}
+
+// Revoked reports whether cert has been revoked.
+func (s *Server) Revoked(cert *x509.Certificate) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.findCert(cert)
+	return c != nil && c.revoked
+}
+
+func (s *Server) findCert(cert *x509.Certificate) *issued {
+	for _, c := range s.certs {
+		if c.cert.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) newId() string {
+	s.nextId++
+	return strconv.Itoa(s.nextId)
+}
+
+func (s *Server) newNonce() string {
+	bs := make([]byte, 16)
+	rand.Read(bs)
+	nonce := base64.RawURLEncoding.EncodeToString(bs)
+	s.nonceLock.Lock()
+	s.nonces[nonce] = true
+	s.nonceLock.Unlock()
+	return nonce
+}
+
+func (s *Server) writeHeader(w http.ResponseWriter) {
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="index"`, s.DirectoryURL()))
+}
+
+func (s *Server) writeJson(w http.ResponseWriter, status int, data any) {
+	s.writeHeader(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (s *Server) writeProblem(w http.ResponseWriter, status int, typ string, format string, args ...any) {
+	s.writeHeader(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&problem{
+		Type:   "urn:ietf:params:acme:error:" + typ,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	})
+}
+
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	base := s.srv.URL
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"newNonce":    base + "/nonce",
+		"newAccount":  base + "/new-acct",
+		"newOrder":    base + "/new-order",
+		"revokeCert":  base + "/revoke-cert",
+		"renewalInfo": base + "/renewal-info",
+		"meta": map[string]any{
+			"termsOfService": base + "/terms",
+			"website":        base,
+			"caaIdentities":  s.options.CAAIdentities,
+		},
+	})
+}
+
+func (s *Server) handleNonce(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodGet {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// signed is a verified JWS request.
+type signed struct {
+	payload []byte
+	account *account
+	key     crypto.PublicKey
+	thumb   string
+}
+
+// postAsGet reports whether the request had an empty payload.
+func (req *signed) postAsGet() bool {
+	return len(req.payload) == 0
+}
+
+// verify checks the JWS of a request. With newAccount the key comes from the
+// jwk header, every other request must use the kid of a valid account.
+// verify writes the problem itself and returns nil on failure.
+func (s *Server) verify(w http.ResponseWriter, r *http.Request, newAccount bool) *signed {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/jose+json") {
+		s.writeProblem(w, http.StatusUnsupportedMediaType, "malformed", "content type must be application/jose+json")
+		return nil
+	}
+	body := &jwsBody{}
+	e := json.NewDecoder(r.Body).Decode(body)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "bad jws: %s", e)
+		return nil
+	}
+	bs, e := base64.RawURLEncoding.DecodeString(body.Protected)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "bad protected header: %s", e)
+		return nil
+	}
+	header := &jwsHeader{}
+	e = json.Unmarshal(bs, header)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "bad protected header: %s", e)
+		return nil
+	}
+	s.nonceLock.Lock()
+	valid := s.nonces[header.Nonce]
+	delete(s.nonces, header.Nonce)
+	inject := s.badNonces > 0
+	if inject {
+		s.badNonces--
+	}
+	fail := valid && !inject && s.failures > 0
+	status, retry := s.failStatus, s.failRetry
+	if fail {
+		s.failures--
+	}
+	s.nonceLock.Unlock()
+	if !valid || inject {
+		s.writeProblem(w, http.StatusBadRequest, "badNonce", "invalid nonce %q", header.Nonce)
+		return nil
+	}
+	if fail {
+		if retry > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry/time.Second)))
+		}
+		typ := "serverInternal"
+		if status == http.StatusTooManyRequests {
+			typ = "rateLimited"
+		}
+		s.writeProblem(w, status, typ, "injected failure")
+		return nil
+	}
+	if header.Url != s.srv.URL+r.URL.RequestURI() {
+		s.writeProblem(w, http.StatusUnauthorized, "unauthorized", "url %q does not match the request", header.Url)
+		return nil
+	}
+	rtn := &signed{}
+	if newAccount || (header.Kid == "" && r.URL.Path == "/revoke-cert") {
+		if header.Kid != "" || len(header.Jwk) == 0 {
+			s.writeProblem(w, http.StatusBadRequest, "malformed", "jwk required")
+			return nil
+		}
+		rtn.key, rtn.thumb, e = parseJWK(header.Jwk)
+		if e != nil {
+			s.writeProblem(w, http.StatusBadRequest, "badPublicKey", "%s", e)
+			return nil
+		}
+		s.lock.Lock()
+		for _, a := range s.accounts {
+			if a.thumbprint == rtn.thumb {
+				rtn.account = a
+			}
+		}
+		s.lock.Unlock()
+	} else {
+		if header.Kid == "" || len(header.Jwk) != 0 {
+			s.writeProblem(w, http.StatusBadRequest, "malformed", "kid required")
+			return nil
+		}
+		id := strings.TrimPrefix(header.Kid, s.srv.URL+"/acct/")
+		s.lock.Lock()
+		a := s.accounts[id]
+		s.lock.Unlock()
+		if a == nil {
+			s.writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "unknown account %s", header.Kid)
+			return nil
+		}
+		if a.status != statusValid {
+			s.writeProblem(w, http.StatusUnauthorized, "unauthorized", "account is %s", a.status)
+			return nil
+		}
+		rtn.account = a
+		rtn.key = a.key
+		rtn.thumb = a.thumbprint
+	}
+	e = verifyJWS(rtn.key, header.Alg, body.Protected+"."+body.Payload, body.Signature)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "signature: %s", e)
+		return nil
+	}
+	rtn.payload, e = base64.RawURLEncoding.DecodeString(body.Payload)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "bad payload: %s", e)
+		return nil
+	}
+	return rtn
+}
+
+func (s *Server) accountUrl(a *account) string {
+	return s.srv.URL + "/acct/" + a.id
+}
+
+func (s *Server) accountJson(a *account) map[string]any {
+	return map[string]any{
+		"status":               a.status,
+		"contact":              a.contact,
+		"termsOfServiceAgreed": true,
+		"orders":               s.accountUrl(a) + "/orders",
+		"createdAt":            a.createdAt.Format(time.RFC3339),
+	}
+}
+
+func (s *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, true)
+	if req == nil {
+		return
+	}
+	payload := struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}{}
+	e := json.Unmarshal(req.payload, &payload)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "%s", e)
+		return
+	}
+	if req.account != nil {
+		w.Header().Set("Location", s.accountUrl(req.account))
+		s.lock.Lock()
+		body := s.accountJson(req.account)
+		s.lock.Unlock()
+		s.writeJson(w, http.StatusOK, body)
+		return
+	}
+	if payload.OnlyReturnExisting {
+		s.writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "no account for this key")
+		return
+	}
+	if !payload.TermsOfServiceAgreed {
+		s.writeProblem(w, http.StatusForbidden, "userActionRequired", "terms of service must be agreed")
+		return
+	}
+	for _, c := range payload.Contact {
+		if !strings.HasPrefix(c, "mailto:") {
+			s.writeProblem(w, http.StatusBadRequest, "unsupportedContact", "unsupported contact %q", c)
+			return
+		}
+	}
+	s.lock.Lock()
+	a := &account{
+		id:         s.newId(),
+		key:        req.key,
+		thumbprint: req.thumb,
+		status:     statusValid,
+		contact:    payload.Contact,
+		createdAt:  time.Now(),
+	}
+	s.accounts[a.id] = a
+	body := s.accountJson(a)
+	s.lock.Unlock()
+	w.Header().Set("Location", s.accountUrl(a))
+	s.writeJson(w, http.StatusCreated, body)
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	if req.account.id != r.PathValue("id") {
+		s.writeProblem(w, http.StatusUnauthorized, "unauthorized", "not your account")
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !req.postAsGet() {
+		payload := struct {
+			Status  string   `json:"status"`
+			Contact []string `json:"contact"`
+		}{}
+		e := json.Unmarshal(req.payload, &payload)
+		if e != nil {
+			s.writeProblem(w, http.StatusBadRequest, "malformed", "%s", e)
+			return
+		}
+		if payload.Status == statusDeactivated {
+			req.account.status = statusDeactivated
+		} else if payload.Status != "" {
+			s.writeProblem(w, http.StatusBadRequest, "malformed", "cannot change status to %q", payload.Status)
+			return
+		}
+		if payload.Contact != nil {
+			req.account.contact = payload.Contact
+		}
+	}
+	s.writeJson(w, http.StatusOK, s.accountJson(req.account))
+}
+
+func (s *Server) handleAccountOrders(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	if req.account.id != r.PathValue("id") {
+		s.writeProblem(w, http.StatusUnauthorized, "unauthorized", "not your account")
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ids := req.account.orders
+	size := s.options.OrdersPageSize
+	if size > 0 {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start := min(page*size, len(ids))
+		end := min(start+size, len(ids))
+		if end < len(ids) {
+			w.Header().Add("Link", fmt.Sprintf(`<%s/orders?page=%d>;rel="next"`, s.accountUrl(req.account), page+1))
+		}
+		ids = ids[start:end]
+	}
+	urls := make([]string, 0)
+	for _, id := range ids {
+		urls = append(urls, s.srv.URL+"/order/"+id)
+	}
+	s.writeJson(w, http.StatusOK, map[string]any{"orders": urls})
+}
+
+func validIdentifier(id identifier) bool {
+	switch id.Type {
+	case "ip":
+		return net.ParseIP(id.Value) != nil
+	case "dns":
+		name := strings.TrimPrefix(id.Value, "*.")
+		if name == "" || strings.Contains(name, "*") || net.ParseIP(name) != nil {
+			return false
+		}
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	payload := struct {
+		Identifiers []identifier `json:"identifiers"`
+		NotBefore   string       `json:"notBefore"`
+		NotAfter    string       `json:"notAfter"`
+		Replaces    string       `json:"replaces"`
+	}{}
+	e := json.Unmarshal(req.payload, &payload)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "%s", e)
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "no identifiers")
+		return
+	}
+	for _, id := range payload.Identifiers {
+		if id.Type != "dns" && id.Type != "ip" {
+			s.writeProblem(w, http.StatusBadRequest, "unsupportedIdentifier", "unsupported identifier type %q", id.Type)
+			return
+		}
+		if !validIdentifier(id) {
+			s.writeProblem(w, http.StatusBadRequest, "rejectedIdentifier", "invalid identifier %q", id.Value)
+			return
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := req.account
+	if limit := s.options.OrderRateLimit; limit > 0 {
+		now := time.Now()
+		recent := make([]time.Time, 0)
+		for _, t := range a.newOrders {
+			if now.Sub(t) < s.options.RateLimitWindow {
+				recent = append(recent, t)
+			}
+		}
+		a.newOrders = recent
+		if len(recent) >= limit {
+			retry := recent[0].Add(s.options.RateLimitWindow).Sub(now)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			s.writeProblem(w, http.StatusTooManyRequests, "rateLimited", "too many new orders: %d per %s", limit, s.options.RateLimitWindow)
+			return
+		}
+		a.newOrders = append(a.newOrders, now)
+	}
+	o := &order{
+		id:          s.newId(),
+		account:     a.id,
+		status:      statusPending,
+		expires:     time.Now().Add(7 * 24 * time.Hour),
+		identifiers: payload.Identifiers,
+		notBefore:   payload.NotBefore,
+		notAfter:    payload.NotAfter,
+		replaces:    payload.Replaces,
+	}
+	for _, id := range payload.Identifiers {
+		az := &authz{
+			id:         s.newId(),
+			account:    a.id,
+			identifier: id,
+			status:     statusPending,
+			expires:    o.expires,
+		}
+		if strings.HasPrefix(id.Value, "*.") {
+			az.wildcard = true
+			az.identifier.Value = strings.TrimPrefix(id.Value, "*.")
+		}
+		types := []string{ChallengeHTTP01, ChallengeDNS01, ChallengeTLSALPN01}
+		if az.wildcard {
+			types = []string{ChallengeDNS01}
+		} else if id.Type == "ip" {
+			types = []string{ChallengeHTTP01, ChallengeTLSALPN01}
+		}
+		token := make([]byte, 32)
+		rand.Read(token)
+		for _, typ := range types {
+			c := &challenge{
+				id:     s.newId(),
+				authz:  az.id,
+				typ:    typ,
+				token:  base64.RawURLEncoding.EncodeToString(token),
+				status: statusPending,
+			}
+			s.challenges[c.id] = c
+			az.challenges = append(az.challenges, c.id)
+		}
+		s.authzs[az.id] = az
+		o.authzs = append(o.authzs, az.id)
+	}
+	s.orders[o.id] = o
+	a.orders = append(a.orders, o.id)
+	w.Header().Set("Location", s.srv.URL+"/order/"+o.id)
+	s.writeJson(w, http.StatusCreated, s.orderJson(o))
+}
+
+// updateOrder moves a pending order on once its authorizations are done.
+func (s *Server) updateOrder(o *order) {
+	if o.status != statusPending {
+		return
+	}
+	ready := true
+	for _, id := range o.authzs {
+		az := s.authzs[id]
+		switch az.status {
+		case statusInvalid, statusDeactivated:
+			o.status = statusInvalid
+			o.err = &problem{Type: "urn:ietf:params:acme:error:unauthorized", Detail: "authorization for " + az.identifier.Value + " failed"}
+			return
+		case statusValid:
+		default:
+			ready = false
+		}
+	}
+	if ready {
+		o.status = statusReady
+	}
+}
+
+func (s *Server) orderJson(o *order) map[string]any {
+	s.updateOrder(o)
+	authzs := make([]string, 0)
+	for _, id := range o.authzs {
+		authzs = append(authzs, s.srv.URL+"/authz/"+id)
+	}
+	rtn := map[string]any{
+		"status":         o.status,
+		"expires":        o.expires.Format(time.RFC3339),
+		"identifiers":    o.identifiers,
+		"authorizations": authzs,
+		"finalize":       s.srv.URL + "/finalize/" + o.id,
+	}
+	if o.notBefore != "" {
+		rtn["notBefore"] = o.notBefore
+	}
+	if o.notAfter != "" {
+		rtn["notAfter"] = o.notAfter
+	}
+	if o.replaces != "" {
+		rtn["replaces"] = o.replaces
+	}
+	if o.cert != "" {
+		rtn["certificate"] = s.srv.URL + "/cert/" + o.cert
+	}
+	if o.err != nil {
+		rtn["error"] = o.err
+	}
+	return rtn
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	o := s.orders[r.PathValue("id")]
+	if o == nil || o.account != req.account.id {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	if o.status == statusProcessing {
+		w.Header().Set("Retry-After", "1")
+	}
+	s.writeJson(w, http.StatusOK, s.orderJson(o))
+}
+
+func (s *Server) challengeJson(c *challenge) map[string]any {
+	rtn := map[string]any{
+		"type":   c.typ,
+		"url":    s.srv.URL + "/chall/" + c.id,
+		"status": c.status,
+		"token":  c.token,
+	}
+	if !c.validated.IsZero() {
+		rtn["validated"] = c.validated.Format(time.RFC3339)
+	}
+	if c.err != nil {
+		rtn["error"] = c.err
+	}
+	return rtn
+}
+
+func (s *Server) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	az := s.authzs[r.PathValue("id")]
+	if az == nil || az.account != req.account.id {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+	if !req.postAsGet() {
+		payload := struct {
+			Status string `json:"status"`
+		}{}
+		json.Unmarshal(req.payload, &payload)
+		if payload.Status != statusDeactivated {
+			s.writeProblem(w, http.StatusBadRequest, "malformed", "only deactivation is supported")
+			return
+		}
+		az.status = statusDeactivated
+	}
+	challenges := make([]map[string]any, 0)
+	for _, id := range az.challenges {
+		challenges = append(challenges, s.challengeJson(s.challenges[id]))
+	}
+	body := map[string]any{
+		"status":     az.status,
+		"expires":    az.expires.Format(time.RFC3339),
+		"identifier": az.identifier,
+		"challenges": challenges,
+	}
+	if az.wildcard {
+		body["wildcard"] = true
+	}
+	s.writeJson(w, http.StatusOK, body)
+}
+
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	s.lock.Lock()
+	c := s.challenges[r.PathValue("id")]
+	var az *authz
+	if c != nil {
+		az = s.authzs[c.authz]
+	}
+	if az == nil || az.account != req.account.id {
+		s.lock.Unlock()
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+	start := !req.postAsGet() && c.status == statusPending && az.status == statusPending
+	if start {
+		c.status = statusProcessing
+	}
+	delay := s.options.ProcessingDelay
+	validate := s.options.ValidateChallenges
+	s.lock.Unlock()
+	if start {
+		keyAuth := keyAuthorization(c.token, req.thumb)
+		if delay == 0 && !validate {
+			s.completeChallenge(c, az, nil)
+		} else {
+			go func() {
+				time.Sleep(delay)
+				var e error
+				if validate {
+					e = s.validate(c.typ, az.identifier, c.token, keyAuth)
+				}
+				s.completeChallenge(c, az, e)
+			}()
+		}
+	}
+	s.lock.Lock()
+	body := s.challengeJson(c)
+	s.lock.Unlock()
+	w.Header().Add("Link", fmt.Sprintf(`<%s/authz/%s>;rel="up"`, s.srv.URL, az.id))
+	s.writeJson(w, http.StatusOK, body)
+}
+
+func (s *Server) completeChallenge(c *challenge, az *authz, e error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e != nil {
+		typ := "connection"
+		if c.typ == ChallengeDNS01 {
+			typ = "dns"
+		} else if c.typ == ChallengeTLSALPN01 {
+			typ = "tls"
+		}
+		c.status = statusInvalid
+		c.err = &problem{Type: "urn:ietf:params:acme:error:" + typ, Detail: e.Error(), Status: http.StatusBadRequest}
+		az.status = statusInvalid
+		return
+	}
+	c.status = statusValid
+	c.validated = time.Now()
+	az.status = statusValid
+}
+
+func sameIdentifiers(a []identifier, b []identifier) bool {
+	key := func(list []identifier) string {
+		values := make([]string, 0)
+		for _, v := range list {
+			value := v.Value
+			if v.Type == "ip" {
+				value = net.ParseIP(value).String()
+			}
+			values = append(values, v.Type+":"+value)
+		}
+		sort.Strings(values)
+		return strings.Join(values, ",")
+	}
+	return key(a) == key(b)
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	payload := struct {
+		Csr string `json:"csr"`
+	}{}
+	e := json.Unmarshal(req.payload, &payload)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "%s", e)
+		return
+	}
+	s.lock.Lock()
+	o := s.orders[r.PathValue("id")]
+	if o == nil || o.account != req.account.id {
+		s.lock.Unlock()
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	s.updateOrder(o)
+	if o.status != statusReady {
+		s.lock.Unlock()
+		s.writeProblem(w, http.StatusForbidden, "orderNotReady", "order is %s", o.status)
+		return
+	}
+	s.lock.Unlock()
+	der, e := base64.RawURLEncoding.DecodeString(payload.Csr)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", "%s", e)
+		return
+	}
+	csr, e := x509.ParseCertificateRequest(der)
+	if e == nil {
+		e = csr.CheckSignature()
+	}
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", "%s", e)
+		return
+	}
+	if !sameIdentifiers(csrIdentifiers(csr), o.identifiers) {
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", "csr names do not match the order")
+		return
+	}
+	s.lock.Lock()
+	if o.status != statusReady {
+		s.lock.Unlock()
+		s.writeProblem(w, http.StatusForbidden, "orderNotReady", "order is %s", o.status)
+		return
+	}
+	o.status = statusProcessing
+	delay := s.options.ProcessingDelay
+	validity := s.options.CertValidity
+	s.lock.Unlock()
+	if delay == 0 {
+		s.issue(o, csr, validity)
+	} else {
+		go func() {
+			time.Sleep(delay)
+			s.issue(o, csr, validity)
+		}()
+	}
+	s.lock.Lock()
+	if o.status == statusProcessing {
+		w.Header().Set("Retry-After", "1")
+	}
+	body := s.orderJson(o)
+	s.lock.Unlock()
+	w.Header().Set("Location", s.srv.URL+"/order/"+o.id)
+	s.writeJson(w, http.StatusOK, body)
+}
+
+func (s *Server) issue(o *order, csr *x509.CertificateRequest, validity time.Duration) {
+	cert, e := s.ca.issue(csr, validity, s.srv.URL+"/ocsp")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e != nil {
+		o.status = statusInvalid
+		o.err = &problem{Type: "urn:ietf:params:acme:error:serverInternal", Detail: e.Error()}
+		return
+	}
+	c := &issued{id: s.newId(), account: o.account, cert: cert}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	start := cert.NotBefore.Add(lifetime * 2 / 3)
+	c.window = [2]time.Time{start, start.Add(lifetime / 9)}
+	s.certs[c.id] = c
+	o.cert = c.id
+	o.status = statusValid
+}
+
+func (s *Server) handleCert(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	s.lock.Lock()
+	c := s.certs[r.PathValue("id")]
+	s.lock.Unlock()
+	if c == nil || c.account != req.account.id {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such certificate")
+		return
+	}
+	n := 0
+	if v := r.PathValue("n"); v != "" {
+		var e error
+		n, e = strconv.Atoi(v)
+		if e != nil || n < 1 || n >= len(s.ca.intermediates) {
+			s.writeProblem(w, http.StatusNotFound, "malformed", "no such chain")
+			return
+		}
+	}
+	s.writeHeader(w)
+	for i := range s.ca.intermediates {
+		if i == n {
+			continue
+		}
+		url := s.srv.URL + "/cert/" + c.id
+		if i > 0 {
+			url += "/" + strconv.Itoa(i)
+		}
+		w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="alternate"`, url))
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(s.ca.chainPEM(c.cert, n))
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	req := s.verify(w, r, false)
+	if req == nil {
+		return
+	}
+	payload := struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}{}
+	e := json.Unmarshal(req.payload, &payload)
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "%s", e)
+		return
+	}
+	der, e := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	var cert *x509.Certificate
+	if e == nil {
+		cert, e = x509.ParseCertificate(der)
+	}
+	if e != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "bad certificate: %s", e)
+		return
+	}
+	if payload.Reason < 0 || payload.Reason > 10 || payload.Reason == 7 {
+		s.writeProblem(w, http.StatusBadRequest, "badRevocationReason", "reason %d", payload.Reason)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.findCert(cert)
+	if c == nil {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "certificate was not issued by this server")
+		return
+	}
+	owner := req.account != nil && req.account.id == c.account
+	if !owner {
+		pub, ok := c.cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		owner = ok && req.account == nil && pub.Equal(req.key)
+	}
+	if !owner {
+		s.writeProblem(w, http.StatusForbidden, "unauthorized", "not allowed to revoke this certificate")
+		return
+	}
+	if c.revoked {
+		s.writeProblem(w, http.StatusBadRequest, "alreadyRevoked", "certificate is already revoked")
+		return
+	}
+	c.revoked = true
+	c.revokedAt = time.Now()
+	c.reason = payload.Reason
+	s.writeHeader(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func certID(cert *x509.Certificate) string {
+	serial := cert.SerialNumber.Bytes()
+	if len(serial) > 0 && serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+	return base64.RawURLEncoding.EncodeToString(cert.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial)
+}
+
+func (s *Server) handleRenewalInfo(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.lock.Lock()
+	var found *issued
+	for _, c := range s.certs {
+		if certID(c.cert) == id {
+			found = c
+		}
+	}
+	var window [2]time.Time
+	if found != nil {
+		window = found.window
+		if found.revoked {
+			window = [2]time.Time{time.Now().Add(-time.Hour), time.Now()}
+		}
+	}
+	s.lock.Unlock()
+	if found == nil {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "unknown certificate %s", id)
+		return
+	}
+	w.Header().Set("Retry-After", "21600")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"suggestedWindow": map[string]string{
+			"start": window[0].Format(time.RFC3339),
+			"end":   window[1].Format(time.RFC3339),
+		},
+	})
+}
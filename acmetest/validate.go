@@ -0,0 +1,140 @@
+package acmetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var oidAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+func (s *Server) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if s.options.Dial != nil {
+		return s.options.Dial(ctx, network, addr)
+	}
+	dialer := &net.Dialer{}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+func (s *Server) lookupTXT(ctx context.Context, name string) ([]string, error) {
+	if s.options.LookupTXT != nil {
+		return s.options.LookupTXT(ctx, name)
+	}
+	return net.DefaultResolver.LookupTXT(ctx, name)
+}
+
+// validate performs a challenge the way a CA would.
+func (s *Server) validate(typ string, id identifier, token string, keyAuth string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	switch typ {
+	case ChallengeHTTP01:
+		return s.validateHTTP(ctx, id, token, keyAuth)
+	case ChallengeDNS01:
+		return s.validateDNS(ctx, id, keyAuth)
+	case ChallengeTLSALPN01:
+		return s.validateTLSALPN(ctx, id, keyAuth)
+	}
+	return fmt.Errorf("unsupported challenge %s", typ)
+}
+
+func (s *Server) validateHTTP(ctx context.Context, id identifier, token string, keyAuth string) error {
+	host := net.JoinHostPort(id.Value, strconv.Itoa(s.options.HTTPPort))
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: s.dial},
+		Timeout:   10 * time.Second,
+	}
+	req, e := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/.well-known/acme-challenge/"+token, nil)
+	if e != nil {
+		return e
+	}
+	res, e := client.Do(req)
+	if e != nil {
+		return e
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL, res.Status)
+	}
+	bs, e := io.ReadAll(io.LimitReader(res.Body, 8192))
+	if e != nil {
+		return e
+	}
+	if strings.TrimSpace(string(bs)) != keyAuth {
+		return fmt.Errorf("%s returned %q, want the key authorization", req.URL, string(bs))
+	}
+	return nil
+}
+
+func (s *Server) validateDNS(ctx context.Context, id identifier, keyAuth string) error {
+	name := "_acme-challenge." + strings.TrimPrefix(id.Value, "*.")
+	values, e := s.lookupTXT(ctx, name)
+	if e != nil {
+		return e
+	}
+	want := dnsValue(keyAuth)
+	for _, v := range values {
+		if v == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("no TXT record %s matches", name)
+}
+
+func (s *Server) validateTLSALPN(ctx context.Context, id identifier, keyAuth string) error {
+	addr := net.JoinHostPort(id.Value, strconv.Itoa(s.options.TLSPort))
+	raw, e := s.dial(ctx, "tcp", addr)
+	if e != nil {
+		return e
+	}
+	conn := tls.Client(raw, &tls.Config{
+		ServerName:         id.Value,
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true,
+	})
+	defer conn.Close()
+	e = conn.HandshakeContext(ctx)
+	if e != nil {
+		return e
+	}
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != "acme-tls/1" {
+		return errors.New("acme-tls/1 was not negotiated")
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no certificate presented")
+	}
+	cert := state.PeerCertificates[0]
+	if e := cert.VerifyHostname(id.Value); e != nil {
+		return e
+	}
+	want := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAcmeIdentifier) {
+			continue
+		}
+		if !ext.Critical {
+			return errors.New("acmeIdentifier extension is not critical")
+		}
+		var value []byte
+		_, e := asn1.Unmarshal(ext.Value, &value)
+		if e != nil {
+			return e
+		}
+		if !bytes.Equal(value, want[:]) {
+			return errors.New("acmeIdentifier does not match the key authorization")
+		}
+		return nil
+	}
+	return errors.New("certificate has no acmeIdentifier extension")
+}
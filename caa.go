@@ -0,0 +1,252 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tonyzzp/acme/utils"
+)
+
+const CAAOff = "off"
+const CAAWarn = "warn"
+const CAARefuse = "refuse"
+
+// caaTags are the property tags this client understands, an unknown one
+// flagged critical forbids issuance (RFC 8659 section 4.1).
+var caaTags = map[string]bool{
+	"issue":        true,
+	"issuewild":    true,
+	"iodef":        true,
+	"contactemail": true,
+	"contactphone": true,
+	"issuemail":    true,
+	"issuevmc":     true,
+}
+
+// CAAOptions configures the CAA check run before every new order.
+type CAAOptions struct {
+	// Mode is CAAWarn to log identifiers the CA may not issue for,
+	// CAARefuse to fail the order before it is created, or CAAOff.
+	Mode string
+	// Resolvers are the host:port of recursive resolvers, those of
+	// /etc/resolv.conf when empty.
+	Resolvers []string
+	Timeout   time.Duration
+}
+
+// CAAResult is the CAA verdict for one identifier.
+type CAAResult struct {
+	Identifier Identifier
+	// Domain is where the relevant record set was found, empty when no
+	// name up to the top level domain has CAA records.
+	Domain  string
+	Records []string
+	Allowed bool
+	Reason  string
+}
+
+// CAAError lists the identifiers CAA does not allow the CA to issue for.
+type CAAError struct {
+	Denied []*CAAResult
+}
+
+func (e *CAAError) Error() string {
+	parts := make([]string, 0)
+	for _, result := range e.Denied {
+		parts = append(parts, fmt.Sprintf("%s: %s", result.Identifier.Value, result.Reason))
+	}
+	return "CAA does not allow this CA to issue for " + strings.Join(parts, "; ")
+}
+
+type caaProperty struct {
+	tag    string
+	issuer string
+	params map[string]string
+}
+
+// parseCAAIssue splits an issue or issuewild value into the issuer domain
+// and its parameters.
+func parseCAAIssue(value string) (string, map[string]string) {
+	issuer, rest, _ := strings.Cut(value, ";")
+	params := make(map[string]string)
+	for _, param := range strings.Split(rest, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok {
+			params[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+		}
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(issuer), ".")), params
+}
+
+// lookupCAA returns the CAA records of name, following CNAMEs the way the
+// resolver does.
+func lookupCAA(name string, resolvers []string, timeout time.Duration) ([]*dns.CAA, error) {
+	res, e := queryDNS(name, dns.TypeCAA, resolvers, timeout)
+	if e != nil {
+		return nil, e
+	}
+	rtn := make([]*dns.CAA, 0)
+	for _, rr := range res.Answer {
+		if caa, ok := rr.(*dns.CAA); ok {
+			rtn = append(rtn, caa)
+		}
+	}
+	return rtn, nil
+}
+
+// relevantCAA climbs from domain towards the top level domain and returns
+// the first non empty CAA record set (RFC 8659 section 3).
+func relevantCAA(domain string, resolvers []string, timeout time.Duration) (string, []*dns.CAA, error) {
+	labels := dns.SplitDomainName(domain)
+	for i := range labels {
+		name := strings.Join(labels[i:], ".")
+		records, e := lookupCAA(name, resolvers, timeout)
+		if e != nil {
+			return name, nil, e
+		}
+		if len(records) > 0 {
+			return name, records, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// evaluateCAA decides whether a CA known as one of identities may issue
+// for a name with records, wildcard for *. names. accountUri and method
+// are checked against the accounturi and validationmethods parameters,
+// an empty method skips the latter.
+func evaluateCAA(records []*dns.CAA, wildcard bool, identities []string, accountUri string, method string) (bool, string) {
+	properties := make([]*caaProperty, 0)
+	for _, rr := range records {
+		tag := strings.ToLower(rr.Tag)
+		if !caaTags[tag] && rr.Flag&128 != 0 {
+			return false, fmt.Sprintf("unknown critical property %q", rr.Tag)
+		}
+		p := &caaProperty{tag: tag}
+		if tag == "issue" || tag == "issuewild" {
+			p.issuer, p.params = parseCAAIssue(rr.Value)
+		}
+		properties = append(properties, p)
+	}
+	tag := "issue"
+	if wildcard && utils.SliceFind(properties, func(p *caaProperty) bool { return p.tag == "issuewild" }) != nil {
+		tag = "issuewild"
+	}
+	relevant := utils.SliceFilter(properties, func(p *caaProperty) bool { return p.tag == tag })
+	if len(relevant) == 0 {
+		return true, "no " + tag + " property"
+	}
+	reason := fmt.Sprintf("%s allows only %s", tag, strings.Join(caaIssuers(relevant), ", "))
+	for _, p := range relevant {
+		if p.issuer == "" || !containsFold(identities, p.issuer) {
+			continue
+		}
+		if uri, ok := p.params["accounturi"]; ok && uri != accountUri {
+			reason = fmt.Sprintf("%s %s is limited to account %s", tag, p.issuer, uri)
+			continue
+		}
+		if methods, ok := p.params["validationmethods"]; ok && method != "" && !containsFold(strings.Split(methods, ","), method) {
+			reason = fmt.Sprintf("%s %s does not allow %s, only %s", tag, p.issuer, method, methods)
+			continue
+		}
+		return true, fmt.Sprintf("%s %s", tag, p.issuer)
+	}
+	return false, reason
+}
+
+func caaIssuers(properties []*caaProperty) []string {
+	rtn := make([]string, 0)
+	for _, p := range properties {
+		if p.issuer == "" {
+			rtn = append(rtn, `";" (no CA)`)
+		} else {
+			rtn = append(rtn, p.issuer)
+		}
+	}
+	return rtn
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCAA looks up the CAA records of the dns identifiers and reports
+// whether the CA of the directory may issue for them with method, which
+// may be empty. IP identifiers have no CAA and are allowed.
+func (client *Client) CheckCAA(identifiers []Identifier, method string) ([]*CAAResult, error) {
+	options := client.CAA
+	if options == nil {
+		options = &CAAOptions{Mode: CAAWarn}
+	}
+	e := client.InitAccount()
+	if e != nil {
+		return nil, e
+	}
+	identities := client.Directory.Meta.CaaIdentities
+	if len(identities) == 0 {
+		return nil, errors.New("the CA does not publish caaIdentities")
+	}
+	resolvers, e := dnsResolvers(options.Resolvers)
+	if e != nil {
+		return nil, e
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = dnsTimeout
+	}
+	rtn := make([]*CAAResult, 0)
+	for _, id := range identifiers {
+		result := &CAAResult{Identifier: id, Allowed: true, Records: []string{}}
+		rtn = append(rtn, result)
+		if id.Type != "dns" {
+			result.Reason = "not a dns identifier"
+			continue
+		}
+		domain := strings.TrimPrefix(id.Value, "*.")
+		name, records, e := relevantCAA(domain, resolvers, timeout)
+		if e != nil {
+			return rtn, fmt.Errorf("lookup CAA of %s: %w", name, e)
+		}
+		result.Domain = strings.TrimSuffix(name, ".")
+		for _, rr := range records {
+			result.Records = append(result.Records, fmt.Sprintf("%d %s %q", rr.Flag, rr.Tag, rr.Value))
+		}
+		if len(records) == 0 {
+			result.Reason = "no CAA records"
+			continue
+		}
+		result.Allowed, result.Reason = evaluateCAA(records, id.Value != domain, identities, client.Account.Uri, method)
+	}
+	return rtn, nil
+}
+
+// preflightCAA runs CheckCAA as configured by client.CAA before an order.
+// Lookup failures are only logged, the CA decides then.
+func (client *Client) preflightCAA(identifiers []Identifier, method string) error {
+	if client.CAA == nil || client.CAA.Mode == CAAOff || client.CAA.Mode == "" {
+		return nil
+	}
+	results, e := client.CheckCAA(identifiers, method)
+	if e != nil {
+		client.logger().Warn("caa check skipped", "error", e)
+		return nil
+	}
+	denied := utils.SliceFilter(results, func(result *CAAResult) bool { return !result.Allowed })
+	if len(denied) == 0 {
+		return nil
+	}
+	caaErr := &CAAError{Denied: denied}
+	if client.CAA.Mode == CAARefuse {
+		return caaErr
+	}
+	client.logger().Warn("the order will probably fail", "error", caaErr)
+	return nil
+}
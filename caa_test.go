@@ -0,0 +1,127 @@
+package acme
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tonyzzp/acme/acmetest"
+)
+
+func caaRecords(t *testing.T, values ...string) []*dns.CAA {
+	t.Helper()
+	rtn := make([]*dns.CAA, 0)
+	for _, value := range values {
+		rr, e := dns.NewRR("example.test. 60 IN CAA " + value)
+		if e != nil {
+			t.Fatal(e)
+		}
+		rtn = append(rtn, rr.(*dns.CAA))
+	}
+	return rtn
+}
+
+func TestEvaluateCAA(t *testing.T) {
+	identities := []string{"ca.test"}
+	account := "https://ca.test/acct/1"
+	tests := []struct {
+		name     string
+		records  []string
+		wildcard bool
+		method   string
+		allowed  bool
+	}{
+		{"issue", []string{`0 issue "ca.test"`}, false, "", true},
+		{"issue case", []string{`0 issue "CA.Test."`}, false, "", true},
+		{"other ca", []string{`0 issue "other.test"`}, false, "", false},
+		{"no ca", []string{`0 issue ";"`}, false, "", false},
+		{"one of", []string{`0 issue "other.test"`, `0 issue "ca.test"`}, false, "", true},
+		{"iodef only", []string{`0 iodef "mailto:caa@example.test"`}, false, "", true},
+		{"issuewild for wildcards", []string{`0 issue "ca.test"`, `0 issuewild "other.test"`}, true, "", false},
+		{"issuewild ignored for names", []string{`0 issue "ca.test"`, `0 issuewild "other.test"`}, false, "", true},
+		{"issue for wildcards", []string{`0 issue "ca.test"`}, true, "", true},
+		{"wildcard forbidden", []string{`0 issue "ca.test"`, `0 issuewild ";"`}, true, "", false},
+		{"accounturi", []string{`0 issue "ca.test; accounturi=https://ca.test/acct/1"`}, false, "", true},
+		{"other account", []string{`0 issue "ca.test; accounturi=https://ca.test/acct/2"`}, false, "", false},
+		{"validationmethods", []string{`0 issue "ca.test; validationmethods=http-01,dns-01"`}, false, "dns-01", true},
+		{"method not allowed", []string{`0 issue "ca.test; validationmethods=http-01"`}, false, "dns-01", false},
+		{"method unknown", []string{`0 issue "ca.test; validationmethods=http-01"`}, false, "", true},
+		{"unknown tag", []string{`0 issue "ca.test"`, `0 future "x"`}, false, "", true},
+		{"unknown critical tag", []string{`0 issue "ca.test"`, `128 future "x"`}, false, "", false},
+		{"known critical tag", []string{`128 issue "ca.test"`}, false, "", true},
+		{"empty", nil, false, "", true},
+	}
+	for _, test := range tests {
+		allowed, reason := evaluateCAA(caaRecords(t, test.records...), test.wildcard, identities, account, test.method)
+		if allowed != test.allowed {
+			t.Errorf("%s: allowed %v, %s", test.name, allowed, reason)
+		}
+	}
+}
+
+func TestRelevantCAA(t *testing.T) {
+	addr := startTestResolver(t,
+		`example.test. 60 IN CAA 0 issue "ca.test"`,
+		`sub.example.test. 60 IN A 127.0.0.1`,
+		`other.example.test. 60 IN CAA 0 issue "other.test"`,
+	)
+	tests := map[string]string{
+		"example.test":           "example.test",
+		"sub.example.test":       "example.test",
+		"a.b.sub.example.test":   "example.test",
+		"other.example.test":     "other.example.test",
+		"www.other.example.test": "other.example.test",
+		"none.test":              "",
+	}
+	for domain, want := range tests {
+		name, records, e := relevantCAA(domain, []string{addr}, time.Second)
+		if e != nil {
+			t.Fatalf("%s: %v", domain, e)
+		}
+		if name != want {
+			t.Errorf("%s: records of %q, want %q", domain, name, want)
+		}
+		if (want == "") != (len(records) == 0) {
+			t.Errorf("%s: %d records", domain, len(records))
+		}
+	}
+	if _, _, e := relevantCAA("example.test", []string{closedPort(t)}, 100*time.Millisecond); e == nil {
+		t.Error("expected the lookup to fail")
+	}
+}
+
+func TestCheckCAA(t *testing.T) {
+	addr := startTestResolver(t,
+		`allowed.test. 60 IN CAA 0 issue "ca.test"`,
+		`denied.test. 60 IN CAA 0 issue "other.test"`,
+		`wild.test. 60 IN CAA 0 issue "ca.test"`,
+		`wild.test. 60 IN CAA 0 issuewild ";"`,
+	)
+	client, _ := newTestClient(t, &acmetest.Options{CAAIdentities: []string{"ca.test"}})
+	client.CAA = &CAAOptions{Mode: CAARefuse, Resolvers: []string{addr}, Timeout: time.Second}
+
+	results, e := client.CheckCAA(dnsIdentifiers("www.allowed.test", "denied.test", "*.wild.test", "none.test"), ChallengeTypeDNS01)
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := []bool{true, false, false, true}
+	for i, result := range results {
+		if result.Allowed != want[i] {
+			t.Errorf("%s: allowed %v, %s", result.Identifier.Value, result.Allowed, result.Reason)
+		}
+	}
+	if results[0].Domain != "allowed.test" || len(results[0].Records) != 1 {
+		t.Errorf("found %v at %q", results[0].Records, results[0].Domain)
+	}
+
+	_, e = client.ObtainCert(dnsIdentifiers("allowed.test", "denied.test"), &acceptSolver{})
+	var caaErr *CAAError
+	if !errors.As(e, &caaErr) || len(caaErr.Denied) != 1 || caaErr.Denied[0].Identifier.Value != "denied.test" {
+		t.Fatalf("expected denied.test to be refused, got %v", e)
+	}
+	_, e = client.ObtainCert(dnsIdentifiers("allowed.test"), &acceptSolver{})
+	if e != nil {
+		t.Fatal(e)
+	}
+}
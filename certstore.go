@@ -0,0 +1,390 @@
+package acme
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tonyzzp/acme/utils"
+)
+
+// Every issuance is stored in certs/<name>/versions/<n>. manifest.json
+// records the versions and which one is current, certs/<name>/current is
+// a symlink to it where the platform allows. Certificates stored before
+// versioning keep their files directly in certs/<name> until the next
+// issuance moves them into versions/1.
+
+const certManifestFile = "manifest.json"
+const certCurrentLink = "current"
+const certVersionsDir = "versions"
+const certNameFile = "certname"
+
+type CertVersion struct {
+	Version     int          `json:"version"`
+	Created     time.Time    `json:"created"`
+	Order       string       `json:"order,omitempty"`
+	Identifiers []Identifier `json:"identifiers"`
+	Serial      string       `json:"serial,omitempty"`
+	NotAfter    time.Time    `json:"notAfter"`
+}
+
+type CertManifest struct {
+	Name     string         `json:"name"`
+	Current  int            `json:"current"`
+	Versions []*CertVersion `json:"versions"`
+}
+
+func (m *CertManifest) Version(v int) *CertVersion {
+	for _, version := range m.Versions {
+		if version.Version == v {
+			return version
+		}
+	}
+	return nil
+}
+
+func (m *CertManifest) latest() int {
+	rtn := 0
+	for _, version := range m.Versions {
+		rtn = max(rtn, version.Version)
+	}
+	return rtn
+}
+
+func validCertName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("bad certificate name %q", name)
+	}
+	return nil
+}
+
+func (client *Client) certDir(name string) string {
+	return filepath.Join(client.storeCerts, name)
+}
+
+func (client *Client) versionDir(name string, version int) string {
+	return filepath.Join(client.storeCerts, name, certVersionsDir, strconv.Itoa(version))
+}
+
+// readManifest returns an error wrapping os.ErrNotExist for certificates
+// stored before versioning.
+func (client *Client) readManifest(name string) (*CertManifest, error) {
+	bs, e := os.ReadFile(filepath.Join(client.certDir(name), certManifestFile))
+	if e != nil {
+		return nil, e
+	}
+	rtn := &CertManifest{}
+	e = json.Unmarshal(bs, rtn)
+	if e != nil {
+		return nil, fmt.Errorf("read %s manifest: %w", name, e)
+	}
+	return rtn, nil
+}
+
+func (client *Client) writeManifest(m *CertManifest) error {
+	file := filepath.Join(client.certDir(m.Name), certManifestFile)
+	e := writeJson(file+".tmp", m)
+	if e != nil {
+		return e
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// linkCurrent points certs/<name>/current at the version. Failing to
+// create a symlink, e.g. on Windows without the privilege, is not fatal:
+// the manifest stays authoritative.
+func (client *Client) linkCurrent(name string, version int) {
+	link := filepath.Join(client.certDir(name), certCurrentLink)
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	e := os.Symlink(filepath.Join(certVersionsDir, strconv.Itoa(version)), tmp)
+	if e == nil {
+		e = os.Rename(tmp, link)
+	}
+	if e != nil {
+		os.Remove(tmp)
+		client.logger().Warn("update current link failed", "name", name, "error", e)
+	}
+}
+
+// currentDir is the current symlink when it points at the current version
+// and the version directory otherwise.
+func (client *Client) currentDir(m *CertManifest) string {
+	link := filepath.Join(client.certDir(m.Name), certCurrentLink)
+	target, e := os.Readlink(link)
+	if e == nil && filepath.Clean(target) == filepath.Join(certVersionsDir, strconv.Itoa(m.Current)) {
+		return link
+	}
+	return client.versionDir(m.Name, m.Current)
+}
+
+func leafVersion(dir string, version *CertVersion) {
+	bs, e := os.ReadFile(filepath.Join(dir, "fullchain.pem"))
+	if e != nil {
+		return
+	}
+	certs, e := parseCertsPEM(bs)
+	if e != nil || len(certs) == 0 {
+		return
+	}
+	leaf := certs[0]
+	version.Serial = leaf.SerialNumber.Text(16)
+	version.NotAfter = leaf.NotAfter
+	if version.Identifiers == nil {
+		version.Identifiers = leafIdentifiers(leaf.DNSNames, leaf.IPAddresses)
+	}
+}
+
+func leafIdentifiers(names []string, ips []net.IP) []Identifier {
+	rtn := make([]Identifier, 0)
+	for _, name := range names {
+		rtn = append(rtn, Identifier{Type: "dns", Value: name})
+	}
+	for _, ip := range ips {
+		rtn = append(rtn, Identifier{Type: "ip", Value: ip.String()})
+	}
+	return rtn
+}
+
+// migrateLegacy moves the files of a certificate stored before versioning
+// into versions/1. It returns an empty manifest when there is nothing to
+// move.
+func (client *Client) migrateLegacy(name string) (*CertManifest, error) {
+	rtn := &CertManifest{Name: name, Versions: make([]*CertVersion, 0)}
+	dir := client.certDir(name)
+	if !utils.FileExists(filepath.Join(dir, "fullchain.pem")) {
+		return rtn, nil
+	}
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	target := client.versionDir(name, 1)
+	e = os.MkdirAll(target, os.ModePerm)
+	if e != nil {
+		return nil, e
+	}
+	version := &CertVersion{Version: 1, Created: time.Now()}
+	for _, entry := range entries {
+		if entry.Name() == certVersionsDir || entry.Name() == certCurrentLink {
+			continue
+		}
+		if entry.Name() == "fullchain.pem" {
+			info, e := entry.Info()
+			if e == nil {
+				version.Created = info.ModTime()
+			}
+		}
+		e = os.Rename(filepath.Join(dir, entry.Name()), filepath.Join(target, entry.Name()))
+		if e != nil {
+			return nil, e
+		}
+	}
+	leafVersion(target, version)
+	rtn.Versions = append(rtn.Versions, version)
+	rtn.Current = 1
+	client.logger().Info("moved certificate into versions/1", "name", name)
+	return rtn, nil
+}
+
+// installVersion moves a completely written bundle into the next version
+// of name and makes it current, so readers never see a new key next to an
+// old chain.
+func (client *Client) installVersion(src string, name string, order *Order) (string, error) {
+	m, e := client.readManifest(name)
+	if errors.Is(e, os.ErrNotExist) {
+		m, e = client.migrateLegacy(name)
+	}
+	if e != nil {
+		return "", e
+	}
+	version := &CertVersion{
+		Version:     m.latest() + 1,
+		Created:     time.Now(),
+		Order:       order.Uri,
+		Identifiers: order.Identifiers,
+	}
+	dir := client.versionDir(name, version.Version)
+	e = os.MkdirAll(filepath.Dir(dir), os.ModePerm)
+	if e != nil {
+		return "", e
+	}
+	os.Remove(filepath.Join(src, certNameFile))
+	e = os.Rename(src, dir)
+	if e != nil {
+		return "", e
+	}
+	leafVersion(dir, version)
+	m.Versions = append(m.Versions, version)
+	m.Current = version.Version
+	e = client.writeManifest(m)
+	if e != nil {
+		return "", e
+	}
+	client.linkCurrent(name, version.Version)
+	return client.currentDir(m), nil
+}
+
+// storedName is the certificate name of a directory returned by
+// DownloadCert.
+func (client *Client) storedName(dir string) string {
+	rel, e := filepath.Rel(client.storeCerts, dir)
+	if e != nil {
+		return ""
+	}
+	name, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return name
+}
+
+// SetCertName stores the certificate of order under name instead of a
+// name derived from its identifiers. Call it before DownloadCert.
+func (client *Client) SetCertName(order *Order, name string) error {
+	e := validCertName(name)
+	if e != nil {
+		return e
+	}
+	dir := client.pendingDir(order)
+	e = os.MkdirAll(dir, os.ModePerm)
+	if e != nil {
+		return e
+	}
+	return os.WriteFile(filepath.Join(dir, certNameFile), []byte(name), 0600)
+}
+
+func identifierKey(ids []Identifier) string {
+	list := utils.SliceMap(ids, func(id Identifier) string { return id.Type + ":" + strings.ToLower(id.Value) })
+	slices.Sort(list)
+	return strings.Join(slices.Compact(list), ",")
+}
+
+// certName picks where the certificate of order is stored: the name given
+// to SetCertName, else the stored certificate with the same identifiers,
+// else the first identifier with a -0001 style suffix when that name is
+// taken by other identifiers.
+func (client *Client) certName(order *Order) (string, error) {
+	bs, e := os.ReadFile(filepath.Join(client.pendingDir(order), certNameFile))
+	if e == nil {
+		return string(bs), nil
+	}
+	if len(order.Identifiers) == 0 {
+		return "", errors.New("order has no identifiers")
+	}
+	key := identifierKey(order.Identifiers)
+	certs, e := client.GetLocalCerts()
+	if e != nil {
+		return "", e
+	}
+	for _, cert := range certs {
+		if len(cert.Certs) == 0 {
+			continue
+		}
+		leaf := cert.Certs[0]
+		if identifierKey(leafIdentifiers(leaf.DNSNames, leaf.IPAddresses)) == key {
+			return cert.Name, nil
+		}
+	}
+	base := order.Identifiers[0].Value
+	name := base
+	for i := 1; utils.FileExists(client.certDir(name)); i++ {
+		name = fmt.Sprintf("%s-%04d", base, i)
+	}
+	return name, nil
+}
+
+// LoadStoredCert loads the current version of a stored certificate.
+func (client *Client) LoadStoredCert(name string) (*Cert, error) {
+	e := validCertName(name)
+	if e != nil {
+		return nil, e
+	}
+	dir := client.certDir(name)
+	version := 0
+	m, e := client.readManifest(name)
+	if e == nil {
+		dir = client.currentDir(m)
+		version = m.Current
+	} else if !errors.Is(e, os.ErrNotExist) {
+		return nil, e
+	}
+	rtn, e := LoadCert(dir, client.KeyStore)
+	if e != nil {
+		return nil, e
+	}
+	rtn.Name = name
+	rtn.Version = version
+	return rtn, nil
+}
+
+// CertHistory returns the versions of a stored certificate. Certificates
+// stored before versioning have none until they are renewed.
+func (client *Client) CertHistory(name string) (*CertManifest, error) {
+	e := validCertName(name)
+	if e != nil {
+		return nil, e
+	}
+	m, e := client.readManifest(name)
+	if errors.Is(e, os.ErrNotExist) {
+		if !utils.FileExists(filepath.Join(client.certDir(name), "fullchain.pem")) {
+			return nil, fmt.Errorf("certificate %s not found", name)
+		}
+		return &CertManifest{Name: name, Versions: make([]*CertVersion, 0)}, nil
+	}
+	return m, e
+}
+
+// RollbackCert makes an earlier version current again and runs the
+// deploy hooks for it. version 0 means the newest version older than the
+// current one.
+func (client *Client) RollbackCert(name string, version int) (*Cert, error) {
+	m, e := client.CertHistory(name)
+	if e != nil {
+		return nil, e
+	}
+	if version == 0 {
+		for _, v := range m.Versions {
+			if v.Version < m.Current {
+				version = max(version, v.Version)
+			}
+		}
+		if version == 0 {
+			return nil, fmt.Errorf("certificate %s has no version before %d", name, m.Current)
+		}
+	}
+	if m.Version(version) == nil {
+		return nil, fmt.Errorf("certificate %s has no version %d", name, version)
+	}
+	dir := client.versionDir(name, version)
+	_, e = LoadCert(dir, client.KeyStore)
+	if e != nil {
+		return nil, fmt.Errorf("version %d: %w", version, e)
+	}
+	m.Current = version
+	e = client.writeManifest(m)
+	if e != nil {
+		return nil, e
+	}
+	client.linkCurrent(name, version)
+	client.logger().Info("certificate rolled back", "name", name, "version", version)
+	dir = client.currentDir(m)
+	e = client.runDeployHooks(&DeployEvent{
+		Name:          name,
+		Dir:           dir,
+		CertPath:      filepath.Join(dir, "fullchain.pem"),
+		KeyPath:       filepath.Join(dir, "privkey.pem"),
+		FullChainPath: filepath.Join(dir, "fullchain.pem"),
+		Domains:       utils.SliceMap(m.Version(version).Identifiers, func(v Identifier) string { return v.Value }),
+		Renewal:       true,
+	})
+	cert, le := client.LoadStoredCert(name)
+	if le != nil {
+		return nil, le
+	}
+	return cert, e
+}
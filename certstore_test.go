@@ -0,0 +1,242 @@
+package acme
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// recordingHook keeps the deploy events it gets.
+type recordingHook struct {
+	lock   sync.Mutex
+	events []*DeployEvent
+}
+
+func (hook *recordingHook) Deploy(event *DeployEvent) error {
+	hook.lock.Lock()
+	defer hook.lock.Unlock()
+	hook.events = append(hook.events, event)
+	return nil
+}
+
+// obtainVersions obtains the certificate name n times and returns the
+// serial of every version.
+func obtainVersions(t *testing.T, client *Client, name string, n int) []string {
+	t.Helper()
+	rtn := make([]string, 0)
+	for i := 0; i < n; i++ {
+		cert := obtainStoredCert(t, client, name)
+		rtn = append(rtn, cert.Certs[0].SerialNumber.Text(16))
+	}
+	return rtn
+}
+
+func currentLink(t *testing.T, client *Client, name string) string {
+	t.Helper()
+	target, e := os.Readlink(filepath.Join(client.certDir(name), certCurrentLink))
+	if e != nil {
+		t.Skipf("no current link: %v", e)
+	}
+	return target
+}
+
+func TestCertHistory(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	serials := obtainVersions(t, client, "example.test", 3)
+	m, e := client.CertHistory("example.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if m.Current != 3 || len(m.Versions) != 3 {
+		t.Fatalf("current %d of %d versions", m.Current, len(m.Versions))
+	}
+	for i, version := range m.Versions {
+		if version.Version != i+1 || version.Serial != serials[i] {
+			t.Errorf("version %d is %d, serial %s want %s", i+1, version.Version, version.Serial, serials[i])
+		}
+		if i > 0 && version.Created.Before(m.Versions[i-1].Created) {
+			t.Errorf("version %d created before version %d", version.Version, i)
+		}
+		if version.Order == "" || len(version.Identifiers) != 1 || version.NotAfter.IsZero() {
+			t.Errorf("version %d: %+v", version.Version, version)
+		}
+	}
+	cert, e := client.LoadStoredCert("example.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if cert.Version != 3 || cert.Certs[0].SerialNumber.Text(16) != serials[2] {
+		t.Fatalf("loaded version %d, serial %s", cert.Version, cert.Certs[0].SerialNumber.Text(16))
+	}
+
+	if _, e := client.CertHistory("missing.test"); e == nil {
+		t.Error("history of a missing certificate")
+	}
+	if _, e := client.CertHistory("../example.test"); e == nil {
+		t.Error("history of a bad name")
+	}
+	if target := currentLink(t, client, "example.test"); target != filepath.Join(certVersionsDir, "3") {
+		t.Fatalf("current links to %s", target)
+	}
+}
+
+func TestRollbackCert(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	serials := obtainVersions(t, client, "example.test", 3)
+	hook := &recordingHook{}
+	client.DeployHooks = []DeployHook{hook}
+
+	for _, want := range []int{2, 1} {
+		cert, e := client.RollbackCert("example.test", 0)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if cert.Version != want || cert.Certs[0].SerialNumber.Text(16) != serials[want-1] {
+			t.Fatalf("rolled back to version %d, want %d", cert.Version, want)
+		}
+		m, _ := client.CertHistory("example.test")
+		if m.Current != want || len(m.Versions) != 3 {
+			t.Fatalf("manifest current %d of %d versions", m.Current, len(m.Versions))
+		}
+		event := hook.events[len(hook.events)-1]
+		if !event.Renewal || event.Name != "example.test" || event.Cert.Certs[0].SerialNumber.Text(16) != serials[want-1] {
+			t.Fatalf("deploy event %+v", event)
+		}
+		if target := currentLink(t, client, "example.test"); target != filepath.Join(certVersionsDir, strconv.Itoa(want)) {
+			t.Fatalf("current links to %s", target)
+		}
+	}
+	if len(hook.events) != 2 {
+		t.Fatalf("hooks ran %d times", len(hook.events))
+	}
+	if _, e := client.RollbackCert("example.test", 0); e == nil {
+		t.Fatal("rolled back past the first version")
+	}
+	if _, e := client.RollbackCert("example.test", 9); e == nil {
+		t.Fatal("rolled back to a missing version")
+	}
+
+	// forward again, then a new issuance follows the latest version
+	cert, e := client.RollbackCert("example.test", 3)
+	if e != nil || cert.Version != 3 {
+		t.Fatalf("rolled forward to %v: %v", cert, e)
+	}
+	client.RollbackCert("example.test", 1)
+	cert = obtainStoredCert(t, client, "example.test")
+	if cert.Version != 4 {
+		t.Fatalf("issued version %d after a rollback", cert.Version)
+	}
+}
+
+func TestLegacyCertMigration(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	old := obtainStoredCert(t, client, "legacy.test")
+	// the layout before versioning: the files in certs/<name>
+	dir := client.certDir("legacy.test")
+	entries, e := os.ReadDir(old.Path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, entry := range entries {
+		e := os.Rename(filepath.Join(old.Path, entry.Name()), filepath.Join(dir, entry.Name()))
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	for _, name := range []string{certVersionsDir, certCurrentLink, certManifestFile} {
+		os.RemoveAll(filepath.Join(dir, name))
+	}
+
+	legacy, e := client.LoadStoredCert("legacy.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if legacy.Version != 0 || legacy.Path != dir {
+		t.Fatalf("legacy certificate loaded as version %d from %s", legacy.Version, legacy.Path)
+	}
+	m, e := client.CertHistory("legacy.test")
+	if e != nil || len(m.Versions) != 0 {
+		t.Fatalf("legacy history %+v: %v", m, e)
+	}
+
+	renewed := obtainStoredCert(t, client, "legacy.test")
+	if renewed.Version != 2 {
+		t.Fatalf("renewed to version %d", renewed.Version)
+	}
+	m, e = client.CertHistory("legacy.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(m.Versions) != 2 || m.Current != 2 {
+		t.Fatalf("current %d of %d versions", m.Current, len(m.Versions))
+	}
+	first := m.Version(1)
+	if first.Serial != old.Certs[0].SerialNumber.Text(16) || len(first.Identifiers) != 1 || first.Identifiers[0].Value != "legacy.test" {
+		t.Fatalf("migrated version %+v", first)
+	}
+	moved, e := LoadCert(client.versionDir("legacy.test", 1), nil)
+	if e != nil || moved.PrivateKeyPEM != old.PrivateKeyPEM {
+		t.Fatalf("versions/1 does not hold the legacy bundle: %v", e)
+	}
+	if _, e := os.Stat(filepath.Join(dir, "fullchain.pem")); !os.IsNotExist(e) {
+		t.Fatal("legacy files left in the certificate directory")
+	}
+	if _, e := client.RollbackCert("legacy.test", 0); e != nil {
+		t.Fatalf("rollback to the migrated version: %v", e)
+	}
+}
+
+func TestPendingRemoved(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	pending := func() []os.DirEntry {
+		t.Helper()
+		entries, e := os.ReadDir(client.storePending)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return entries
+	}
+	newNamedOrder := func(domain string) *Order {
+		t.Helper()
+		order, e := client.NewOrder(dnsIdentifiers(domain))
+		if e != nil {
+			t.Fatal(e)
+		}
+		e = client.SetCertName(order, "named")
+		if e != nil {
+			t.Fatal(e)
+		}
+		return order
+	}
+
+	order := newNamedOrder("example.test")
+	_, e := client.CompleteOrder(order, &acceptSolver{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entries := pending(); len(entries) != 0 {
+		t.Fatalf("pending dir left after the install: %v", entries)
+	}
+
+	// nothing presented, so the authorization and the order turn invalid
+	s.SetValidateChallenges(true)
+	order = newNamedOrder("invalid.test")
+	_, e = client.CompleteOrder(order, &acceptSolver{})
+	if e == nil {
+		t.Fatal("completed an order without presenting the challenge")
+	}
+	if entries := pending(); len(entries) != 0 {
+		t.Fatalf("pending dir left for an invalid order: %v", entries)
+	}
+
+	order = newNamedOrder("deleted.test")
+	e = client.DelOrder(order)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entries := pending(); len(entries) != 0 {
+		t.Fatalf("pending dir left for a deleted order: %v", entries)
+	}
+}
@@ -0,0 +1,204 @@
+package acme
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ChainPreference selects one of the chains a CA offers through
+// Link rel="alternate". Either field may be empty.
+type ChainPreference struct {
+	IssuerCN string
+	// RootFingerprint is the sha256 fingerprint of a certificate of the
+	// chain or of the root it chains to. CAs serve chains without their
+	// root, so the root is looked up in Roots.
+	RootFingerprint string
+	// Roots the served chains are completed with, the Client's RootCAs or
+	// the system roots when nil.
+	Roots *x509.CertPool
+}
+
+type CertChain struct {
+	Url     string
+	PEM     string
+	Default bool
+	Certs   []*x509.Certificate
+}
+
+// ChainInfo is stored as chain.json next to fullchain.pem.
+type ChainInfo struct {
+	Url        string   `json:"url"`
+	Issuer     string   `json:"issuer"`
+	Default    bool     `json:"default"`
+	Preferred  bool     `json:"preferred"`
+	Alternates []string `json:"alternates"`
+}
+
+// Issuer is the common name of the issuer of the topmost certificate.
+func (chain *CertChain) Issuer() string {
+	if len(chain.Certs) == 0 {
+		return ""
+	}
+	return chain.Certs[len(chain.Certs)-1].Issuer.CommonName
+}
+
+func normalizeFingerprint(s string) string {
+	s = strings.ToLower(s)
+	s = strings.ReplaceAll(s, ":", "")
+	return strings.ReplaceAll(s, " ", "")
+}
+
+func (pref *ChainPreference) Match(chain *CertChain) bool {
+	if pref.IssuerCN == "" && pref.RootFingerprint == "" {
+		return false
+	}
+	if pref.IssuerCN != "" && chain.Issuer() != pref.IssuerCN {
+		return false
+	}
+	if pref.RootFingerprint != "" {
+		want := normalizeFingerprint(pref.RootFingerprint)
+		for _, c := range append(chain.Certs, pref.roots(chain)...) {
+			sum := sha256.Sum256(c.Raw)
+			if hex.EncodeToString(sum[:]) == want {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// roots returns the certificates in Roots that issued the topmost
+// certificate of chain, found by authority key id and issuer name and
+// checked by signature.
+func (pref *ChainPreference) roots(chain *CertChain) []*x509.Certificate {
+	if len(chain.Certs) == 0 {
+		return nil
+	}
+	pool := pref.Roots
+	if pool == nil {
+		var e error
+		pool, e = x509.SystemCertPool()
+		if e != nil {
+			return nil
+		}
+	}
+	top := chain.Certs[len(chain.Certs)-1]
+	// at its issuance, expired chains are still told apart by their root
+	chains, _ := top.Verify(x509.VerifyOptions{
+		Roots:       pool,
+		CurrentTime: top.NotBefore,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	rtn := make([]*x509.Certificate, 0)
+	for _, verified := range chains {
+		if len(verified) > 1 {
+			rtn = append(rtn, verified[1])
+		}
+	}
+	return rtn
+}
+
+func (client *Client) fetchChain(url string) (*CertChain, []string, error) {
+	res, e := client.request(HttpRequestParam{
+		Url:    url,
+		Method: http.MethodPost,
+		Kid:    client.Account.Uri,
+	})
+	if e != nil {
+		return nil, nil, e
+	}
+	body := string(res.Body())
+	if !res.IsSuccess() {
+		return nil, nil, errors.New(body)
+	}
+	certs, e := parseCertsPEM(res.Body())
+	if e != nil {
+		return nil, nil, fmt.Errorf("parse certificate chain: %w", e)
+	}
+	rtn := &CertChain{
+		Url:   url,
+		PEM:   body,
+		Certs: certs,
+	}
+	return rtn, parseLinks(res.Header(), url, "alternate"), nil
+}
+
+// FetchCertChains downloads the default chain of a valid order followed by
+// every alternate chain the CA advertises.
+func (client *Client) FetchCertChains(order *Order) ([]*CertChain, error) {
+	chain, alternates, e := client.fetchChain(order.Certificate)
+	if e != nil {
+		return nil, e
+	}
+	chain.Default = true
+	rtn := []*CertChain{chain}
+	for _, url := range alternates {
+		alt, _, e := client.fetchChain(url)
+		if e != nil {
+			client.logger().Warn("download alternate chain failed", "url", url, "error", e)
+			continue
+		}
+		rtn = append(rtn, alt)
+	}
+	return rtn, nil
+}
+
+// selectChain returns the chain to store. Alternates are only downloaded
+// when the client has a preference; the default chain wins when nothing
+// matches.
+func (client *Client) selectChain(order *Order) (*CertChain, *ChainInfo, error) {
+	pref := client.PreferredChain
+	var chains []*CertChain
+	var alternates []string
+	if pref == nil {
+		chain, links, e := client.fetchChain(order.Certificate)
+		if e != nil {
+			return nil, nil, e
+		}
+		chain.Default = true
+		chains = []*CertChain{chain}
+		alternates = links
+	} else {
+		list, e := client.FetchCertChains(order)
+		if e != nil {
+			return nil, nil, e
+		}
+		chains = list
+		for _, c := range list[1:] {
+			alternates = append(alternates, c.Url)
+		}
+	}
+	chosen := chains[0]
+	preferred := false
+	if pref != nil {
+		if pref.Roots == nil && client.RootCAs != nil {
+			with := *pref
+			with.Roots = client.RootCAs
+			pref = &with
+		}
+		for _, c := range chains {
+			if pref.Match(c) {
+				chosen = c
+				preferred = true
+				break
+			}
+		}
+		if !preferred {
+			client.logger().Info("no chain matches the preference, keep default", "issuer", pref.IssuerCN, "root", pref.RootFingerprint)
+		}
+	}
+	info := &ChainInfo{
+		Url:        chosen.Url,
+		Issuer:     chosen.Issuer(),
+		Default:    chosen.Default,
+		Preferred:  preferred,
+		Alternates: alternates,
+	}
+	return chosen, info, nil
+}
@@ -0,0 +1,35 @@
+package acme
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/tonyzzp/acme/acmetest"
+)
+
+func TestPreferredRootNotServed(t *testing.T) {
+	client, s := newTestClient(t, &acmetest.Options{AlternateChains: 1})
+	client.RootCAs = s.Roots()
+	sum := sha256.Sum256(s.Root(1).Raw)
+	client.PreferredChain = &ChainPreference{RootFingerprint: hex.EncodeToString(sum[:])}
+	cert := obtainTestCert(t, client, "example.test")
+	for _, c := range cert.Certs {
+		if c.Equal(s.Root(1)) {
+			t.Fatal("acmetest serves the root, the test proves nothing")
+		}
+	}
+	if cert.Chain.Default || !cert.Chain.Preferred {
+		t.Fatalf("chain to the preferred root not selected: %+v", cert.Chain)
+	}
+	if e := cert.Certs[len(cert.Certs)-1].CheckSignatureFrom(s.Root(1)); e != nil {
+		t.Fatal(e)
+	}
+
+	// a root that is not trusted cannot be found
+	client.RootCAs = nil
+	cert = obtainTestCert(t, client, "example.test")
+	if !cert.Chain.Default || cert.Chain.Preferred {
+		t.Fatalf("untrusted root matched: %+v", cert.Chain)
+	}
+}
@@ -0,0 +1,177 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const CheckOK = "ok"
+const CheckWarning = "warning"
+const CheckCritical = "critical"
+const CheckUnknown = "unknown"
+
+// checkSeverity orders the statuses from best to worst.
+var checkSeverity = map[string]int{CheckOK: 0, CheckUnknown: 1, CheckWarning: 2, CheckCritical: 3}
+
+type CheckOptions struct {
+	WarningDays  int
+	CriticalDays int
+	// Roots verifies the chain, the system roots when nil.
+	Roots     *x509.CertPool
+	SkipTrust bool
+}
+
+func DefaultCheckOptions() *CheckOptions {
+	return &CheckOptions{
+		WarningDays:  30,
+		CriticalDays: 7,
+	}
+}
+
+// CertCheck is the health of one stored certificate.
+type CertCheck struct {
+	Name      string
+	Path      string
+	Version   int
+	Domains   []string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	DaysLeft  int
+	Status    string
+	Problems  []string
+}
+
+func (check *CertCheck) problem(status string, format string, args ...any) {
+	check.Problems = append(check.Problems, fmt.Sprintf(format, args...))
+	if checkSeverity[status] > checkSeverity[check.Status] {
+		check.Status = status
+	}
+}
+
+// WorstStatus returns the most severe status of checks, CheckUnknown when
+// there are none.
+func WorstStatus(checks []*CertCheck) string {
+	if len(checks) == 0 {
+		return CheckUnknown
+	}
+	rtn := CheckOK
+	for _, check := range checks {
+		if checkSeverity[check.Status] > checkSeverity[rtn] {
+			rtn = check.Status
+		}
+	}
+	return rtn
+}
+
+// CheckCert reports expiry, a private key that does not belong to the
+// leaf and chain problems of cert.
+func CheckCert(cert *Cert, options *CheckOptions) *CertCheck {
+	if options == nil {
+		options = DefaultCheckOptions()
+	}
+	rtn := &CertCheck{
+		Name:     cert.Name,
+		Path:     cert.Path,
+		Version:  cert.Version,
+		Domains:  []string{},
+		Status:   CheckOK,
+		Problems: []string{},
+	}
+	if len(cert.Certs) == 0 {
+		rtn.problem(CheckCritical, "no certificate in fullchain.pem")
+		return rtn
+	}
+	leaf := cert.Certs[0]
+	now := time.Now()
+	rtn.Domains = append(rtn.Domains, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		rtn.Domains = append(rtn.Domains, ip.String())
+	}
+	rtn.Serial = leaf.SerialNumber.Text(16)
+	rtn.NotBefore = leaf.NotBefore
+	rtn.NotAfter = leaf.NotAfter
+	rtn.DaysLeft = int(leaf.NotAfter.Sub(now).Hours() / 24)
+
+	expired := now.After(leaf.NotAfter)
+	if expired {
+		rtn.problem(CheckCritical, "expired %d days ago", int(now.Sub(leaf.NotAfter).Hours()/24))
+	} else if now.Before(leaf.NotBefore) {
+		rtn.problem(CheckCritical, "not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+	} else if rtn.DaysLeft < options.CriticalDays {
+		rtn.problem(CheckCritical, "expires in %d days", rtn.DaysLeft)
+	} else if rtn.DaysLeft < options.WarningDays {
+		rtn.problem(CheckWarning, "expires in %d days", rtn.DaysLeft)
+	}
+
+	if cert.PrivateKey == nil {
+		rtn.problem(CheckCritical, "no private key")
+	} else if pub, ok := cert.PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(leaf.PublicKey) {
+		rtn.problem(CheckCritical, "private key does not match the certificate")
+	}
+
+	if len(cert.Certs) == 1 {
+		rtn.problem(CheckWarning, "fullchain.pem has no intermediate certificate")
+	}
+	for i := 1; i < len(cert.Certs); i++ {
+		issuer := cert.Certs[i]
+		if e := cert.Certs[i-1].CheckSignatureFrom(issuer); e != nil {
+			rtn.problem(CheckCritical, "certificate %d is not issued by %q, the chain is out of order", i-1, issuer.Subject.CommonName)
+		}
+		if now.After(issuer.NotAfter) {
+			rtn.problem(CheckCritical, "intermediate %q expired", issuer.Subject.CommonName)
+		}
+	}
+	if resp, e := LoadOCSP(cert); e == nil && resp.Status == ocsp.Revoked {
+		rtn.problem(CheckCritical, "revoked at %s according to OCSP", resp.RevokedAt.Format(time.RFC3339))
+	}
+	if !options.SkipTrust && !expired {
+		intermediates := x509.NewCertPool()
+		for _, c := range cert.Certs[1:] {
+			intermediates.AddCert(c)
+		}
+		_, e := leaf.Verify(x509.VerifyOptions{
+			Roots:         options.Roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if e != nil {
+			rtn.problem(CheckCritical, "chain does not verify: %s", e)
+		}
+	}
+	return rtn
+}
+
+// CheckCerts checks the named stored certificates, or all of them when
+// names is empty. A certificate that cannot be loaded is critical.
+func (client *Client) CheckCerts(options *CheckOptions, names ...string) ([]*CertCheck, error) {
+	if len(names) == 0 {
+		entries, e := os.ReadDir(client.storeCerts)
+		if e != nil {
+			return nil, e
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	rtn := make([]*CertCheck, 0)
+	for _, name := range names {
+		cert, e := client.LoadStoredCert(name)
+		if e != nil {
+			check := &CertCheck{Name: name, Path: client.certDir(name), Domains: []string{}, Status: CheckOK}
+			check.problem(CheckCritical, "load failed: %s", e)
+			rtn = append(rtn, check)
+			continue
+		}
+		rtn = append(rtn, CheckCert(cert, options))
+	}
+	return rtn, nil
+}
@@ -1,6 +1,12 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tonyzzp/acme"
+)
 
 func actionLocalCerts(context *Context) error {
 	certs, e := context.Client.GetLocalCerts()
@@ -9,15 +15,12 @@ func actionLocalCerts(context *Context) error {
 		return nil
 	}
 	fmt.Println("certs: ", len(certs))
-	for _, cert := range certs {
-		fmt.Println("-----")
-		fmt.Println("path: ", cert.Path)
-		fmt.Println("certs:", len(cert.Certs))
-		for _, c := range cert.Certs {
-			fmt.Println("  --")
-			fmt.Println("  Subject: ", c.Subject)
-			fmt.Println("  dns: ", c.DNSNames)
-			fmt.Println("  validity: ", c.NotBefore, " - ", c.NotAfter)
+	for i := range certs {
+		printCertText(os.Stdout, &certs[i])
+		check := acme.CheckCert(&certs[i], nil)
+		fmt.Println("check: ", strings.ToUpper(check.Status), check.DaysLeft, "days left")
+		for _, problem := range check.Problems {
+			fmt.Println("  ", problem)
 		}
 	}
 	return nil
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"os"
@@ -146,6 +147,14 @@ func actionOrderAuth(context *Context) error {
 			fmt.Println("下载证书...")
 			fmt.Println("url", order.Certificate)
 			dir, certs, e := context.Client.DownloadCert(order)
+			var deployErr *acme.DeployError
+			if errors.As(e, &deployErr) {
+				fmt.Println(certs)
+				fmt.Println("证书已保存到", dir)
+				fmt.Println("部署失败")
+				fmt.Println(e)
+				return
+			}
 			if e != nil {
 				fmt.Println("失败")
 				fmt.Println(e)
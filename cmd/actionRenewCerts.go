@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tonyzzp/acme"
+)
+
+func actionRenewCerts(context *Context) error {
+	m := acme.NewRenewalManager(context.Client, &acme.DNS01Solver{Provider: &manualDNSProvider{}, Logger: context.Client.Logger})
+	m.OnRenew = func(result *acme.RenewalResult) {
+		fmt.Println("-----")
+		fmt.Println("name: ", result.Name)
+		fmt.Println("expires: ", result.NotAfter)
+		if !result.Renew {
+			fmt.Println("无需续期")
+		} else if result.Error != nil {
+			fmt.Println("续期失败", result.Reason)
+			fmt.Println(result.Error)
+		} else {
+			fmt.Println("续期成功", result.Reason)
+			fmt.Println("证书已保存到", result.Path)
+		}
+	}
+	_, e := m.RunOnce()
+	if e != nil {
+		fmt.Println(e)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tonyzzp/acme"
+	"github.com/tonyzzp/acme/pkcs11"
+	"github.com/tonyzzp/acme/utils"
+	"github.com/urfave/cli/v2"
+)
+
+const exitFailure = 1
+const exitUsage = 2
+
+func newApp() *cli.App {
+	app := &cli.App{
+		Name:  "acme",
+		Usage: "acme client, runs an interactive menu when no command is given",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "data",
+				Usage:   "data directory",
+				Value:   "data",
+				EnvVars: []string{"ACME_DATA"},
+			},
+			&cli.StringFlag{
+				Name:    "ca",
+				Usage:   "directory url of the CA, or staging / production for Let's Encrypt",
+				Value:   "staging",
+				EnvVars: []string{"ACME_CA"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "ca-bundle",
+				Usage:   "PEM file with extra roots to trust when talking to the CA, e.g. for Pebble or step-ca",
+				EnvVars: []string{"ACME_CA_BUNDLE"},
+			},
+			&cli.StringFlag{
+				Name:    "proxy",
+				Usage:   "proxy url for requests to the CA, HTTPS_PROXY is used when empty",
+				EnvVars: []string{"ACME_PROXY"},
+			},
+			&cli.DurationFlag{
+				Name:  "http-timeout",
+				Usage: "timeout of every request to the CA",
+				Value: 30 * time.Second,
+			},
+			&cli.IntFlag{
+				Name:    "retries",
+				Usage:   "retries of a request the CA answers with badNonce, 429 or 503, honouring Retry-After, 0 to disable",
+				Value:   acme.DefaultRetryPolicy().MaxRetries,
+				EnvVars: []string{"ACME_RETRIES"},
+			},
+			&cli.StringFlag{
+				Name:    "caa",
+				Usage:   "CAA check before new orders: warn, refuse or off",
+				Value:   acme.CAAWarn,
+				EnvVars: []string{"ACME_CAA"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "dns-resolver",
+				Usage:   "host:port of a recursive resolver for DNS lookups, those of /etc/resolv.conf when empty",
+				EnvVars: []string{"ACME_DNS_RESOLVER"},
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "output format of commands: text or json",
+				Value:   outputText,
+				EnvVars: []string{"ACME_OUTPUT"},
+			},
+			&cli.StringFlag{
+				Name:    "passphrase-file",
+				Usage:   "file holding the passphrase that encrypts the private keys in the data directory, ACME_PASSPHRASE may be used instead",
+				EnvVars: []string{"ACME_PASSPHRASE_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "key-file",
+				Usage:   "file holding a 32 byte key that encrypts the private keys in the data directory",
+				EnvVars: []string{"ACME_KEY_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-module",
+				Usage:   "PKCS#11 module holding the account key, e.g. /usr/lib/softhsm/libsofthsm2.so",
+				EnvVars: []string{"ACME_PKCS11_MODULE"},
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-token",
+				Usage:   "label of the token holding the account key, the first token when empty",
+				EnvVars: []string{"ACME_PKCS11_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-pin",
+				Usage:   "user pin of the token, visible to other users in the process list, prefer ACME_PKCS11_PIN or --pkcs11-pin-file",
+				EnvVars: []string{"ACME_PKCS11_PIN"},
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-pin-file",
+				Usage:   "file holding the user pin of the token",
+				EnvVars: []string{"ACME_PKCS11_PIN_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-key-label",
+				Usage:   "label of the account key in the token",
+				EnvVars: []string{"ACME_PKCS11_KEY_LABEL"},
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-key-id",
+				Usage:   "hex id of the account key in the token",
+				EnvVars: []string{"ACME_PKCS11_KEY_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "log-file",
+				Usage:   "file the log is appended to, stderr when empty or -",
+				EnvVars: []string{"ACME_LOG_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "log-level",
+				Usage:   "debug, info, warn or error",
+				Value:   "info",
+				EnvVars: []string{"ACME_LOG_LEVEL"},
+			},
+			&cli.BoolFlag{
+				Name:  "debug-wire",
+				Usage: "log every request and response, secrets redacted, implies --log-level debug",
+			},
+			&cli.StringSliceFlag{
+				Name:    "email",
+				Usage:   "contact email used when creating the account",
+				EnvVars: []string{"ACME_EMAIL"},
+			},
+		},
+		ExitErrHandler: handleExitError,
+		OnUsageError:   onUsageError,
+		Before: func(c *cli.Context) error {
+			output := c.String("output")
+			if output != outputText && output != outputJson {
+				return usageError("unknown output format %q", output)
+			}
+			logger, e := newLogger(c)
+			if e != nil {
+				return e
+			}
+			slog.SetDefault(logger)
+			client := acme.NewAcmeClient(c.String("data"))
+			client.Logger = logger
+			client.DebugWire = c.Bool("debug-wire")
+			client.Proxy = c.String("proxy")
+			client.Timeout = c.Duration("http-timeout")
+			if c.Int("retries") < 0 {
+				return usageError("bad --retries %d", c.Int("retries"))
+			}
+			client.Retry = acme.DefaultRetryPolicy()
+			client.Retry.MaxRetries = c.Int("retries")
+			switch c.String("caa") {
+			case acme.CAAWarn, acme.CAARefuse, acme.CAAOff:
+				client.CAA = &acme.CAAOptions{Mode: c.String("caa"), Resolvers: c.StringSlice("dns-resolver")}
+			default:
+				return usageError("unknown caa mode %q", c.String("caa"))
+			}
+			if files := c.StringSlice("ca-bundle"); len(files) > 0 {
+				pool, e := acme.LoadCABundle(files...)
+				if e != nil {
+					return usageError("%s", e)
+				}
+				client.RootCAs = pool
+			}
+			switch c.String("ca") {
+			case "staging":
+				client.DirectoryUrl = acme.LetsEncryptStaging
+			case "production", "prod":
+				client.DirectoryUrl = acme.LetsEncryptProduction
+			default:
+				client.DirectoryUrl = c.String("ca")
+			}
+			client.Contact = utils.SliceMap(c.StringSlice("email"), func(v string) string {
+				if strings.HasPrefix(v, "mailto:") {
+					return v
+				}
+				return "mailto:" + v
+			})
+			store, e := newKeyStore(c)
+			if e != nil {
+				return e
+			}
+			client.KeyStore = store
+			if c.String("pkcs11-module") != "" {
+				pin, e := pkcs11Pin(c)
+				if e != nil {
+					return e
+				}
+				signer, e := pkcs11.New(&pkcs11.Config{
+					Module:     c.String("pkcs11-module"),
+					TokenLabel: c.String("pkcs11-token"),
+					Pin:        pin,
+					KeyLabel:   c.String("pkcs11-key-label"),
+					KeyID:      c.String("pkcs11-key-id"),
+				})
+				if e != nil {
+					return fail(e)
+				}
+				client.Signer = signer
+			}
+			c.App.Metadata = map[string]any{"context": &Context{Client: client, Output: output}}
+			return nil
+		},
+		After: func(c *cli.Context) error {
+			context, ok := c.App.Metadata["context"].(*Context)
+			if !ok {
+				return nil
+			}
+			if signer, ok := context.Client.Signer.(*pkcs11.Signer); ok {
+				signer.Close()
+			}
+			return nil
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Present() {
+				return usageError("unknown command %q", c.Args().First())
+			}
+			return runMenu(getContext(c))
+		},
+		Commands: []*cli.Command{
+			accountCommand(),
+			orderCommand(),
+			certCommand(),
+			keyCommand(),
+		},
+	}
+	setUsageErrors(app.Commands)
+	return app
+}
+
+// onUsageError makes flag parsing errors exit with exitUsage like the
+// argument checks of the commands do.
+func onUsageError(c *cli.Context, e error, isSubcommand bool) error {
+	return &exitError{err: e, code: exitUsage}
+}
+
+// setUsageErrors sets onUsageError on the commands that have no handler
+// of their own, urfave does not inherit it from the app.
+func setUsageErrors(commands []*cli.Command) {
+	for _, command := range commands {
+		if command.OnUsageError == nil {
+			command.OnUsageError = onUsageError
+		}
+		setUsageErrors(command.Subcommands)
+	}
+}
+
+// requireFlags replaces Required flags, whose errors urfave returns
+// without an exit code.
+func requireFlags(c *cli.Context, names ...string) error {
+	for _, name := range names {
+		if !c.IsSet(name) {
+			return usageError("--%s is required", name)
+		}
+	}
+	return nil
+}
+
+func getContext(c *cli.Context) *Context {
+	return c.App.Metadata["context"].(*Context)
+}
+
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) ExitCode() int {
+	return e.code
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func fail(e error) error {
+	return &exitError{err: e, code: exitFailure}
+}
+
+// exitStatus ends a command that already printed its result with code.
+func exitStatus(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return &exitError{code: code}
+}
+
+func usageError(format string, args ...any) error {
+	return &exitError{err: fmt.Errorf(format, args...), code: exitUsage}
+}
+
+var domainFlag = &cli.StringSliceFlag{
+	Name:    "domain",
+	Aliases: []string{"d"},
+	Usage:   "identifier to include, repeat for more names, required",
+}
+
+func parseIdentifiers(domains []string) []acme.Identifier {
+	rtn := make([]acme.Identifier, 0)
+	for _, domain := range domains {
+		for _, v := range strings.Split(domain, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			if net.ParseIP(v) != nil {
+				rtn = append(rtn, acme.Identifier{Type: "ip", Value: v})
+			} else {
+				rtn = append(rtn, acme.Identifier{Type: "dns", Value: v})
+			}
+		}
+	}
+	return rtn
+}
+
+func newLogger(c *cli.Context) (*slog.Logger, error) {
+	level := &slog.LevelVar{}
+	e := level.UnmarshalText([]byte(c.String("log-level")))
+	if e != nil {
+		return nil, usageError("unknown log level %q", c.String("log-level"))
+	}
+	if c.Bool("debug-wire") {
+		level.Set(slog.LevelDebug)
+	}
+	out := os.Stderr
+	if file := c.String("log-file"); file != "" && file != "-" {
+		out, e = os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if e != nil {
+			return nil, fail(e)
+		}
+	}
+	return slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: level})), nil
+}
+
+// pkcs11Pin returns the pin of --pkcs11-pin-file, or else of --pkcs11-pin
+// or ACME_PKCS11_PIN.
+func pkcs11Pin(c *cli.Context) (string, error) {
+	file := c.String("pkcs11-pin-file")
+	if file == "" {
+		return c.String("pkcs11-pin"), nil
+	}
+	bs, e := os.ReadFile(file)
+	if e != nil {
+		return "", usageError("%s", e)
+	}
+	return strings.TrimRight(string(bs), "\r\n"), nil
+}
+
+func newKeyStore(c *cli.Context) (acme.KeyStore, error) {
+	if file := c.String("key-file"); file != "" {
+		store, e := acme.NewKeyFileKeyStore(file)
+		if e != nil {
+			return nil, usageError("%s", e)
+		}
+		return store, nil
+	}
+	passphrase := os.Getenv("ACME_PASSPHRASE")
+	if file := c.String("passphrase-file"); file != "" {
+		bs, e := os.ReadFile(file)
+		if e != nil {
+			return nil, usageError("%s", e)
+		}
+		passphrase = strings.TrimRight(string(bs), "\r\n")
+	}
+	if passphrase == "" {
+		return nil, nil
+	}
+	return &acme.PassphraseKeyStore{Passphrase: []byte(passphrase)}, nil
+}
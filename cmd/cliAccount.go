@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"io"
+
+	"github.com/tonyzzp/acme/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func accountCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "account",
+		Usage: "manage the ACME account",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "show",
+				Usage:  "print the local account",
+				Action: cliAccountShow,
+			},
+			{
+				Name:   "create",
+				Usage:  "register an account, or load the existing one",
+				Action: cliAccountCreate,
+			},
+			{
+				Name:   "fetch",
+				Usage:  "fetch the account status from the CA",
+				Action: cliAccountFetch,
+			},
+			{
+				Name:  "deactivate",
+				Usage: "deactivate the account on the CA, this can not be undone",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "yes", Usage: "confirm the deactivation"},
+				},
+				Action: cliAccountDeactivate,
+			},
+		},
+	}
+}
+
+func cliAccountShow(c *cli.Context) error {
+	account := getContext(c).Client.GetLocalAccount()
+	if account == nil {
+		return fail(errors.New("no local account"))
+	}
+	printResult(c, account, func(w io.Writer) { utils.DumpJson(account, w) })
+	return nil
+}
+
+func cliAccountCreate(c *cli.Context) error {
+	client := getContext(c).Client
+	e := client.InitAccount()
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, client.Account, func(w io.Writer) { utils.DumpJson(client.Account, w) })
+	return nil
+}
+
+func cliAccountFetch(c *cli.Context) error {
+	client := getContext(c).Client
+	if client.GetLocalAccount() == nil {
+		return fail(errors.New("no local account"))
+	}
+	account, e := client.FetchAccount()
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, account, func(w io.Writer) { utils.DumpJson(account, w) })
+	return nil
+}
+
+func cliAccountDeactivate(c *cli.Context) error {
+	if !c.Bool("yes") {
+		return usageError("pass --yes to deactivate the account")
+	}
+	client := getContext(c).Client
+	if client.GetLocalAccount() == nil {
+		return fail(errors.New("no local account"))
+	}
+	account, e := client.DeactivateAccount()
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, account, func(w io.Writer) { utils.DumpJson(account, w) })
+	return nil
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tonyzzp/acme"
+	"github.com/tonyzzp/acme/utils"
+	"github.com/urfave/cli/v2"
+)
+
+var issueFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "key-type",
+		Usage: "certificate key type: ec256, ec384, rsa2048, rsa3072 or rsa4096",
+		Value: acme.KeyTypeEC256,
+	},
+	&cli.StringFlag{
+		Name:  "preferred-chain",
+		Usage: "common name of the issuer of the topmost certificate of the preferred chain",
+	},
+	&cli.StringFlag{
+		Name:  "preferred-root",
+		Usage: "sha256 fingerprint of a certificate of the preferred chain or of the root it chains to, from the system roots or --ca-bundle",
+	},
+	&cli.StringSliceFlag{
+		Name:  "export-format",
+		Usage: "extra formats to write next to the certificate after issuance: " + strings.Join(acme.ExportFormats, ", "),
+	},
+	&cli.StringFlag{
+		Name:  "export-dir",
+		Usage: "directory of the --export-format files, the certificate directory by default, required with an encrypted store",
+	},
+	&cli.BoolFlag{
+		Name:  "export-pkcs8",
+		Usage: "encode exported private keys as PKCS#8",
+	},
+	&cli.BoolFlag{
+		Name:  "export-encrypt-key",
+		Usage: "encrypt exported private keys as PKCS#8 with --export-password",
+	},
+	&cli.StringFlag{
+		Name:    "export-password",
+		Usage:   "password of the exported PKCS#12 file",
+		EnvVars: []string{"ACME_EXPORT_PASSWORD"},
+	},
+	&cli.StringSliceFlag{
+		Name:  "deploy-hook",
+		Usage: "shell command to run after a certificate is stored, gets ACME_* environment variables",
+	},
+	&cli.DurationFlag{
+		Name:  "deploy-timeout",
+		Usage: "timeout of each deploy hook command",
+		Value: 5 * time.Minute,
+	},
+	&cli.StringFlag{
+		Name:  "deploy-copy",
+		Usage: "directory to copy privkey.pem and fullchain.pem into after issuance",
+	},
+	&cli.StringFlag{
+		Name:  "deploy-owner",
+		Usage: "owner of the copied files, user[:group]",
+	},
+	&cli.StringFlag{
+		Name:  "deploy-mode",
+		Usage: "octal mode of the copied certificate",
+		Value: "0644",
+	},
+	&cli.StringFlag{
+		Name:  "deploy-key-mode",
+		Usage: "octal mode of the copied private key",
+		Value: "0600",
+	},
+	&cli.StringSliceFlag{
+		Name:  "deploy-signal",
+		Usage: "pidfile[:SIGNAL] to notify after issuance, SIGHUP by default",
+	},
+}
+
+func certCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "cert",
+		Usage: "manage certificates",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "obtain",
+				Usage: "order and download a certificate",
+				Flags: append(append([]cli.Flag{
+					domainFlag,
+					&cli.StringFlag{Name: "name", Usage: "store the certificate under this name, by default the first domain or the certificate with the same domains"},
+				}, issueFlags...), solverFlags...),
+				Action: cliCertObtain,
+			},
+			{
+				Name:   "list",
+				Usage:  "list local certificates",
+				Action: cliCertList,
+			},
+			{
+				Name:      "check",
+				Usage:     "check expiry, keys and chains of local certificates, exits 0 ok, 1 warning, 2 critical, 3 unknown",
+				ArgsUsage: "[name...]",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "warning", Usage: "warn when a certificate expires within this many days", Value: 30},
+					&cli.IntFlag{Name: "critical", Usage: "critical when a certificate expires within this many days", Value: 7},
+					&cli.StringSliceFlag{Name: "roots", Usage: "PEM file with the roots to verify chains against instead of the system roots"},
+					&cli.BoolFlag{Name: "skip-trust", Usage: "do not verify chains against trusted roots, e.g. for staging certificates"},
+					&cli.StringFlag{Name: "format", Usage: "text, json or csv, --output by default"},
+				},
+				Action:       cliCertCheck,
+				OnUsageError: checkUsageError,
+			},
+			{
+				Name:      "ocsp",
+				Usage:     "fetch and store the OCSP responses of local certificates for stapling",
+				ArgsUsage: "[name...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "force", Usage: "fetch even when the stored response is still fresh"},
+				},
+				Action: cliCertOCSP,
+			},
+			{
+				Name:      "history",
+				Usage:     "list the stored versions of a certificate",
+				ArgsUsage: "<name>",
+				Action:    cliCertHistory,
+			},
+			{
+				Name:      "rollback",
+				Usage:     "make an earlier version of a certificate current and run the deploy hooks",
+				ArgsUsage: "<name>",
+				Flags: append([]cli.Flag{
+					&cli.IntFlag{Name: "version", Usage: "version to restore, the one before the current by default"},
+				}, issueFlags...),
+				Action: cliCertRollback,
+			},
+			{
+				Name:  "renew",
+				Usage: "renew the certificates that are due",
+				Flags: append(append([]cli.Flag{
+					&cli.IntFlag{Name: "days", Usage: "renew when the certificate expires within this many days", Value: 30},
+					&cli.BoolFlag{Name: "force", Usage: "renew every certificate"},
+					&cli.BoolFlag{Name: "no-ari", Usage: "ignore the renewal info of the CA"},
+					&cli.BoolFlag{Name: "daemon", Usage: "keep running and check periodically"},
+					&cli.DurationFlag{Name: "interval", Usage: "check interval in daemon mode", Value: 12 * time.Hour},
+					&cli.DurationFlag{Name: "jitter", Usage: "random delay added to the interval", Value: time.Hour},
+				}, issueFlags...), solverFlags...),
+				Action: cliCertRenew,
+			},
+			{
+				Name:      "export",
+				Usage:     "render a local certificate into other formats",
+				ArgsUsage: "<name>",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "format",
+						Usage: strings.Join(acme.ExportFormats, ", ") + ", required",
+					},
+					&cli.StringFlag{Name: "out", Usage: "output directory, the certificate directory by default"},
+					&cli.BoolFlag{Name: "pkcs8", Usage: "encode private keys as PKCS#8"},
+					&cli.BoolFlag{Name: "encrypt-key", Usage: "encrypt private keys as PKCS#8 with --password"},
+					&cli.StringFlag{
+						Name:    "password",
+						Usage:   "password of the PKCS#12 file and of encrypted keys",
+						EnvVars: []string{"ACME_EXPORT_PASSWORD"},
+					},
+					&cli.BoolFlag{Name: "legacy", Usage: "use the legacy RC2 PKCS#12 encryption for old Java and Windows"},
+				},
+				Action: cliCertExport,
+			},
+			{
+				Name:      "revoke",
+				Usage:     "revoke a local certificate",
+				ArgsUsage: "<name>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "reason", Usage: "RFC 5280 reason code"},
+				},
+				Action: cliCertRevoke,
+			},
+		},
+	}
+}
+
+func applyIssueFlags(c *cli.Context, client *acme.Client) error {
+	if !utils.Contains(acme.KeyTypes, c.String("key-type")) {
+		return usageError("unknown key type %q", c.String("key-type"))
+	}
+	client.KeyType = c.String("key-type")
+	if formats := c.StringSlice("export-format"); len(formats) > 0 {
+		for _, format := range formats {
+			if !utils.Contains(acme.ExportFormats, format) {
+				return usageError("unknown export format %q", format)
+			}
+		}
+		client.AutoExport = &acme.ExportOptions{
+			Formats:    formats,
+			Dir:        c.String("export-dir"),
+			PKCS8:      c.Bool("export-pkcs8"),
+			EncryptKey: c.Bool("export-encrypt-key"),
+			Password:   c.String("export-password"),
+		}
+	}
+	hooks, e := deployHooks(c)
+	if e != nil {
+		return e
+	}
+	client.DeployHooks = hooks
+	if c.String("preferred-chain") != "" || c.String("preferred-root") != "" {
+		client.PreferredChain = &acme.ChainPreference{
+			IssuerCN:        c.String("preferred-chain"),
+			RootFingerprint: c.String("preferred-root"),
+		}
+	}
+	return nil
+}
+
+func findLocalCert(client *acme.Client, name string) (*acme.Cert, error) {
+	certs, e := client.GetLocalCerts()
+	if e != nil {
+		return nil, e
+	}
+	cert := utils.SliceFind(certs, func(v acme.Cert) bool { return v.Name == name })
+	if cert == nil {
+		return nil, fmt.Errorf("certificate %s not found", name)
+	}
+	return cert, nil
+}
+
+func cliCertObtain(c *cli.Context) error {
+	e := requireFlags(c, "domain")
+	if e != nil {
+		return e
+	}
+	client := getContext(c).Client
+	e = applyIssueFlags(c, client)
+	if e != nil {
+		return e
+	}
+	solver, stop, e := newSolver(c)
+	if e != nil {
+		return e
+	}
+	defer stop()
+	identifiers := parseIdentifiers(c.StringSlice("domain"))
+	var dir string
+	var obtainErr error
+	if name := c.String("name"); name != "" {
+		dir, obtainErr = client.ObtainNamedCert(name, identifiers, solver)
+	} else {
+		dir, obtainErr = client.ObtainCert(identifiers, solver)
+	}
+	if dir == "" {
+		return fail(obtainErr)
+	}
+	certs, e := client.GetLocalCerts()
+	if e != nil {
+		return fail(e)
+	}
+	cert := utils.SliceFind(certs, func(v acme.Cert) bool { return v.Path == dir })
+	if cert == nil {
+		return fail(fmt.Errorf("certificate %s not found", dir))
+	}
+	if obtainErr != nil {
+		// the certificate is stored, only the deploy hooks failed
+		status("%s", obtainErr)
+		return fail(obtainErr)
+	}
+	printResult(c, newCertView(cert), func(w io.Writer) { printCertText(w, cert) })
+	return nil
+}
+
+const exitCheckWarning = 1
+const exitCheckCritical = 2
+const exitCheckUnknown = 3
+
+var checkExitCodes = map[string]int{
+	acme.CheckOK:       0,
+	acme.CheckWarning:  exitCheckWarning,
+	acme.CheckCritical: exitCheckCritical,
+	acme.CheckUnknown:  exitCheckUnknown,
+}
+
+// checkUsageError exits with UNKNOWN on bad arguments, monitoring systems
+// read the usage exit status 2 as CRITICAL.
+func checkUsageError(c *cli.Context, e error, isSubcommand bool) error {
+	return &exitError{err: e, code: exitCheckUnknown}
+}
+
+func cliCertCheck(c *cli.Context) error {
+	format := c.String("format")
+	if format == "" {
+		format = outputFormat(c)
+	}
+	if format != outputText && format != outputJson && format != "csv" {
+		return checkUsageError(c, fmt.Errorf("unknown format %q", format), false)
+	}
+	options := &acme.CheckOptions{
+		WarningDays:  c.Int("warning"),
+		CriticalDays: c.Int("critical"),
+		SkipTrust:    c.Bool("skip-trust"),
+	}
+	if options.CriticalDays > options.WarningDays {
+		return checkUsageError(c, errors.New("--critical must not be more than --warning"), false)
+	}
+	if files := c.StringSlice("roots"); len(files) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range files {
+			bs, e := os.ReadFile(file)
+			if e != nil {
+				return &exitError{err: e, code: exitCheckUnknown}
+			}
+			if !pool.AppendCertsFromPEM(bs) {
+				return &exitError{err: fmt.Errorf("%s contains no certificate", file), code: exitCheckUnknown}
+			}
+		}
+		options.Roots = pool
+	}
+	checks, e := getContext(c).Client.CheckCerts(options, c.Args().Slice()...)
+	if e != nil {
+		return &exitError{err: e, code: exitCheckUnknown}
+	}
+	status := acme.WorstStatus(checks)
+	switch format {
+	case outputJson:
+		utils.DumpJson(map[string]any{
+			"status": status,
+			"checks": utils.SliceMap(checks, newCheckView),
+		}, os.Stdout)
+	case "csv":
+		e = printChecksCSV(os.Stdout, checks)
+		if e != nil {
+			return &exitError{err: e, code: exitCheckUnknown}
+		}
+	default:
+		printChecksText(os.Stdout, checks, options)
+	}
+	return exitStatus(checkExitCodes[status])
+}
+
+func cliCertOCSP(c *cli.Context) error {
+	client := getContext(c).Client
+	names := c.Args().Slice()
+	if len(names) == 0 {
+		certs, e := client.GetLocalCerts()
+		if e != nil {
+			return fail(e)
+		}
+		for _, cert := range certs {
+			names = append(names, cert.Name)
+		}
+	}
+	views := make([]*ocspView, 0)
+	failed := false
+	for _, name := range names {
+		cert, e := client.LoadStoredCert(name)
+		if e != nil {
+			return fail(e)
+		}
+		resp, e := client.RefreshOCSP(cert, c.Bool("force"))
+		if e != nil {
+			failed = true
+		}
+		views = append(views, newOCSPView(cert, resp, e))
+	}
+	printResult(c, views, func(w io.Writer) {
+		for _, v := range views {
+			if v.Status == "" {
+				fmt.Fprintln(w, v.Name, "failed:", v.Error)
+				continue
+			}
+			line := fmt.Sprintf("%s %s, this update %s", v.Name, v.Status, v.ThisUpdate.Format(time.RFC3339))
+			if v.NextUpdate != nil {
+				line += ", next update " + v.NextUpdate.Format(time.RFC3339)
+			}
+			if v.RevokedAt != nil {
+				line += ", revoked at " + v.RevokedAt.Format(time.RFC3339)
+			}
+			if v.Error != "" {
+				line += ", refresh failed: " + v.Error
+			}
+			fmt.Fprintln(w, line)
+		}
+	})
+	if failed {
+		return fail(errors.New("some OCSP responses could not be refreshed"))
+	}
+	return nil
+}
+
+func cliCertHistory(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("cert history needs exactly one certificate name")
+	}
+	m, e := getContext(c).Client.CertHistory(c.Args().First())
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, m, func(w io.Writer) { printHistoryText(w, m) })
+	return nil
+}
+
+func cliCertRollback(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("cert rollback needs exactly one certificate name")
+	}
+	client := getContext(c).Client
+	e := applyIssueFlags(c, client)
+	if e != nil {
+		return e
+	}
+	cert, e := client.RollbackCert(c.Args().First(), c.Int("version"))
+	if cert == nil {
+		return fail(e)
+	}
+	if e != nil {
+		// the version is current, only the deploy hooks failed
+		status("%s", e)
+		return fail(e)
+	}
+	printResult(c, newCertView(cert), func(w io.Writer) { printCertText(w, cert) })
+	return nil
+}
+
+func cliCertList(c *cli.Context) error {
+	certs, e := getContext(c).Client.GetLocalCerts()
+	if e != nil {
+		return fail(e)
+	}
+	views := make([]*certView, 0)
+	for i := range certs {
+		views = append(views, newCertView(&certs[i]))
+	}
+	printResult(c, views, func(w io.Writer) {
+		fmt.Fprintln(w, "certs: ", len(certs))
+		for i := range certs {
+			printCertText(w, &certs[i])
+		}
+	})
+	return nil
+}
+
+func cliCertRenew(c *cli.Context) error {
+	client := getContext(c).Client
+	e := applyIssueFlags(c, client)
+	if e != nil {
+		return e
+	}
+	solver, stop, e := newSolver(c)
+	if e != nil {
+		return e
+	}
+	defer stop()
+	m := acme.NewRenewalManager(client, solver)
+	m.Days = c.Int("days")
+	m.Force = c.Bool("force")
+	m.UseARI = !c.Bool("no-ari")
+	m.Interval = c.Duration("interval")
+	m.Jitter = c.Duration("jitter")
+	m.OnRenew = func(result *acme.RenewalResult) {
+		if !result.Renew {
+			status("%s not due, expires %s", result.Name, result.NotAfter)
+		} else if result.Error != nil {
+			status("%s renew failed: %s", result.Name, result.Error)
+		} else {
+			status("%s renewed: %s", result.Name, result.Reason)
+		}
+	}
+	if c.Bool("daemon") {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		e = m.Run(ctx)
+		if e != nil && !errors.Is(e, context.Canceled) {
+			return fail(e)
+		}
+		return nil
+	}
+	results, e := m.RunOnce()
+	views := utils.SliceMap(results, newRenewView)
+	printResult(c, views, func(w io.Writer) {
+		for _, v := range views {
+			if !v.Renew {
+				fmt.Fprintln(w, v.Name, "ok, expires", v.NotAfter)
+			} else if v.Error != "" {
+				fmt.Fprintln(w, v.Name, "failed:", v.Error)
+			} else {
+				fmt.Fprintln(w, v.Name, "renewed")
+			}
+		}
+	})
+	if e != nil {
+		return fail(e)
+	}
+	return nil
+}
+
+func cliCertRevoke(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("cert revoke needs exactly one certificate name")
+	}
+	client := getContext(c).Client
+	cert, e := findLocalCert(client, c.Args().First())
+	if e != nil {
+		return fail(e)
+	}
+	if len(cert.Certs) == 0 {
+		return fail(errors.New("no certificate in fullchain.pem"))
+	}
+	e = client.RevokeCert(cert.Certs[0], c.Int("reason"))
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, map[string]any{"revoked": newCertView(cert)}, func(w io.Writer) { fmt.Fprintln(w, "revoked", cert.Path) })
+	return nil
+}
+
+func cliCertExport(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("cert export needs exactly one certificate name")
+	}
+	e := requireFlags(c, "format")
+	if e != nil {
+		return e
+	}
+	for _, format := range c.StringSlice("format") {
+		if !utils.Contains(acme.ExportFormats, format) {
+			return usageError("unknown export format %q", format)
+		}
+	}
+	cert, e := findLocalCert(getContext(c).Client, c.Args().First())
+	if e != nil {
+		return fail(e)
+	}
+	files, e := getContext(c).Client.ExportCert(cert, &acme.ExportOptions{
+		Formats:      c.StringSlice("format"),
+		Dir:          c.String("out"),
+		PKCS8:        c.Bool("pkcs8"),
+		EncryptKey:   c.Bool("encrypt-key"),
+		Password:     c.String("password"),
+		PKCS12Legacy: c.Bool("legacy"),
+	})
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, map[string]any{"files": files}, func(w io.Writer) {
+		for _, file := range files {
+			fmt.Fprintln(w, file)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tonyzzp/acme"
+	"github.com/tonyzzp/acme/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func keyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "key",
+		Usage: "manage the account key",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "show",
+				Usage:  "print the account key, it is created when missing",
+				Action: cliKeyShow,
+			},
+			{
+				Name:   "encrypt",
+				Usage:  "encrypt every plaintext private key in the data directory with --passphrase-file or --key-file",
+				Action: cliKeyEncrypt,
+			},
+			{
+				Name:   "decrypt",
+				Usage:  "turn an encrypted data directory back into plaintext",
+				Action: cliKeyDecrypt,
+			},
+			{
+				Name:      "new-keyfile",
+				Usage:     "write a random key for --key-file",
+				ArgsUsage: "<file>",
+				Action:    cliKeyNewKeyFile,
+			},
+		},
+	}
+}
+
+func cliKeyShow(c *cli.Context) error {
+	client := getContext(c).Client
+	e := client.InitKey()
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, client.JWK, func(w io.Writer) { utils.DumpJson(client.JWK, w) })
+	return nil
+}
+
+func cliKeyEncrypt(c *cli.Context) error {
+	files, e := getContext(c).Client.EncryptStore()
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, map[string]any{"encrypted": files}, func(w io.Writer) {
+		for _, file := range files {
+			fmt.Fprintln(w, "encrypted", file)
+		}
+	})
+	return nil
+}
+
+func cliKeyDecrypt(c *cli.Context) error {
+	files, e := getContext(c).Client.DecryptStore()
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, map[string]any{"decrypted": files}, func(w io.Writer) {
+		for _, file := range files {
+			fmt.Fprintln(w, "decrypted", file)
+		}
+	})
+	return nil
+}
+
+func cliKeyNewKeyFile(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("key new-keyfile needs exactly one file")
+	}
+	file := c.Args().First()
+	if _, e := os.Stat(file); e == nil {
+		return fail(fmt.Errorf("%s already exists", file))
+	}
+	e := acme.GenerateKeyFile(file)
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, map[string]any{"keyFile": file}, func(w io.Writer) { fmt.Fprintln(w, file) })
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tonyzzp/acme"
+	"github.com/tonyzzp/acme/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func orderCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "order",
+		Usage: "manage orders",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "new",
+				Usage:  "create a new order",
+				Flags:  []cli.Flag{domainFlag},
+				Action: cliOrderNew,
+			},
+			{
+				Name:   "list",
+				Usage:  "list local orders",
+				Action: cliOrderList,
+			},
+			{
+				Name:      "status",
+				Usage:     "fetch the status of an order from the CA",
+				ArgsUsage: "<order id or url>",
+				Action:    cliOrderStatus,
+			},
+			{
+				Name:  "sync",
+				Usage: "import orders the CA lists that are missing locally",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "refresh", Usage: "fetch the orders that exist locally again too"},
+				},
+				Action: cliOrderSync,
+			},
+			{
+				Name:      "clean",
+				Usage:     "delete local orders",
+				ArgsUsage: "[order id or url...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "status", Usage: "delete every order with this status, or all"},
+				},
+				Action: cliOrderClean,
+			},
+		},
+	}
+}
+
+func findLocalOrder(client *acme.Client, id string) (*acme.Order, error) {
+	orders, e := client.GetLocalOrders()
+	if e != nil {
+		return nil, e
+	}
+	matched := utils.SliceFilter(orders, func(order *acme.Order) bool {
+		return order.Uri == id || strings.HasPrefix(utils.Md5String([]byte(order.Uri)), id)
+	})
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("order %s not found", id)
+	}
+	if len(matched) > 1 {
+		return nil, fmt.Errorf("order id %s is ambiguous", id)
+	}
+	return matched[0], nil
+}
+
+func cliOrderNew(c *cli.Context) error {
+	e := requireFlags(c, "domain")
+	if e != nil {
+		return e
+	}
+	client := getContext(c).Client
+	order, e := client.NewOrder(parseIdentifiers(c.StringSlice("domain")))
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, fetchOrderView(c, order), func(w io.Writer) { printOrderText(w, order) })
+	return nil
+}
+
+func cliOrderList(c *cli.Context) error {
+	orders, e := getContext(c).Client.GetLocalOrders()
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, utils.SliceMap(orders, newOrderView), func(w io.Writer) { printOrdersText(w, orders) })
+	return nil
+}
+
+func cliOrderStatus(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("order status needs exactly one order id")
+	}
+	client := getContext(c).Client
+	uri := c.Args().First()
+	if !strings.Contains(uri, "://") {
+		order, e := findLocalOrder(client, uri)
+		if e != nil {
+			return fail(e)
+		}
+		uri = order.Uri
+	}
+	order, e := client.FetchOrder(uri)
+	if e != nil {
+		return fail(e)
+	}
+	printResult(c, fetchOrderView(c, order), func(w io.Writer) { printOrderText(w, order) })
+	return nil
+}
+
+func cliOrderSync(c *cli.Context) error {
+	result, e := getContext(c).Client.SyncOrders(c.Bool("refresh"))
+	if e != nil {
+		return fail(e)
+	}
+	failed := make(map[string]string)
+	for url, e := range result.Failed {
+		failed[url] = e.Error()
+	}
+	view := map[string]any{
+		"imported":  utils.SliceMap(result.Imported, newOrderView),
+		"refreshed": utils.SliceMap(result.Refreshed, newOrderView),
+		"localOnly": utils.SliceMap(result.LocalOnly, newOrderView),
+		"failed":    failed,
+	}
+	printResult(c, view, func(w io.Writer) {
+		for _, order := range result.Imported {
+			fmt.Fprintln(w, "imported", order.ShortDesc())
+		}
+		for _, order := range result.Refreshed {
+			fmt.Fprintln(w, "refreshed", order.ShortDesc())
+		}
+		for _, order := range result.LocalOnly {
+			fmt.Fprintln(w, "not listed by the CA", order.ShortDesc())
+		}
+		for url, e := range result.Failed {
+			fmt.Fprintln(w, "failed", url, e)
+		}
+	})
+	if len(result.Failed) > 0 {
+		return fail(fmt.Errorf("%d orders could not be fetched", len(result.Failed)))
+	}
+	return nil
+}
+
+func cliOrderClean(c *cli.Context) error {
+	client := getContext(c).Client
+	want := c.String("status")
+	if want == "" && c.NArg() == 0 {
+		return usageError("order clean needs --status or order ids")
+	}
+	list := make([]*acme.Order, 0)
+	if want != "" {
+		orders, e := client.GetLocalOrders()
+		if e != nil {
+			return fail(e)
+		}
+		list = utils.SliceFilter(orders, func(order *acme.Order) bool { return want == "all" || order.Status == want })
+	}
+	for _, id := range c.Args().Slice() {
+		order, e := findLocalOrder(client, id)
+		if e != nil {
+			return fail(e)
+		}
+		list = append(list, order)
+	}
+	var errs []error
+	deleted := make([]*orderView, 0)
+	for _, order := range list {
+		e := client.DelOrder(order)
+		if e != nil {
+			errs = append(errs, e)
+			continue
+		}
+		deleted = append(deleted, newOrderView(order))
+	}
+	if len(errs) > 0 {
+		return fail(errors.Join(errs...))
+	}
+	printResult(c, map[string]any{"deleted": deleted}, func(w io.Writer) {
+		for _, order := range deleted {
+			fmt.Fprintln(w, "deleted", order.Id, order.Uri)
+		}
+	})
+	return nil
+}
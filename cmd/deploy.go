@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/tonyzzp/acme"
+	"github.com/urfave/cli/v2"
+)
+
+func deployHooks(c *cli.Context) ([]acme.DeployHook, error) {
+	rtn := make([]acme.DeployHook, 0)
+	if dest := c.String("deploy-copy"); dest != "" {
+		mode, e := strconv.ParseUint(c.String("deploy-mode"), 8, 32)
+		if e != nil {
+			return nil, usageError("bad --deploy-mode %q", c.String("deploy-mode"))
+		}
+		keyMode, e := strconv.ParseUint(c.String("deploy-key-mode"), 8, 32)
+		if e != nil {
+			return nil, usageError("bad --deploy-key-mode %q", c.String("deploy-key-mode"))
+		}
+		rtn = append(rtn, &acme.CopyHook{
+			Dest:    dest,
+			Owner:   c.String("deploy-owner"),
+			Mode:    os.FileMode(mode),
+			KeyMode: os.FileMode(keyMode),
+		})
+	}
+	for _, command := range c.StringSlice("deploy-hook") {
+		rtn = append(rtn, &acme.CommandHook{
+			Command: command,
+			Timeout: c.Duration("deploy-timeout"),
+		})
+	}
+	for _, v := range c.StringSlice("deploy-signal") {
+		file, name, ok := strings.Cut(v, ":")
+		sig := syscall.SIGHUP
+		if ok {
+			var e error
+			sig, e = acme.ParseSignal(name)
+			if e != nil {
+				return nil, usageError("bad --deploy-signal %q: %s", v, e)
+			}
+		}
+		rtn = append(rtn, &acme.SignalHook{PidFile: file, Signal: sig})
+	}
+	return rtn, nil
+}
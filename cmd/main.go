@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
 
 	"github.com/manifoldco/promptui"
@@ -13,6 +12,7 @@ import (
 
 type Context struct {
 	Client *acme.Client
+	Output string
 }
 
 type MenuItem struct {
@@ -23,13 +23,14 @@ type MenuItem struct {
 var ErrExit = errors.New("exit")
 
 func main() {
-	file, e := os.OpenFile("log.log", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	e := newApp().Run(os.Args)
 	if e != nil {
-		panic(e)
+		fmt.Fprintln(os.Stderr, e)
+		os.Exit(1)
 	}
-	log.SetFlags(0)
-	log.SetOutput(file)
+}
 
+func runMenu(context *Context) error {
 	actions := []MenuItem{
 		{
 			Label:  "exit",
@@ -71,14 +72,13 @@ func main() {
 			Label:  "local certs",
 			Action: actionLocalCerts,
 		},
+		{
+			Label:  "renew certs",
+			Action: actionRenewCerts,
+		},
 	}
 
-	context := &Context{
-		Client: acme.NewAcmeClient("data"),
-	}
-
-	var showMenu func()
-	showMenu = func() {
+	for {
 		menu := promptui.Select{
 			Label: "menu",
 			Items: utils.SliceMap(actions, func(v MenuItem) string { return v.Label }),
@@ -86,18 +86,14 @@ func main() {
 		}
 		index, _, e := menu.Run()
 		if e != nil {
-			os.Exit(1)
+			return e
 		}
 		item := actions[index]
 		e = item.Action(context)
-		if e == nil {
-			showMenu()
-		} else if e == ErrExit {
-			os.Exit(0)
-		} else {
-			fmt.Println(e)
-			os.Exit(1)
+		if e == ErrExit {
+			return nil
+		} else if e != nil {
+			return e
 		}
 	}
-	showMenu()
 }
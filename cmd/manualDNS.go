@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/tonyzzp/acme"
+)
+
+type manualDNSProvider struct{}
+
+func (p *manualDNSProvider) Present(record *acme.DNSRecord) error {
+	status("请添加以下 TXT 记录:")
+	if record.FQDN != record.Challenge {
+		status("(%s 是 CNAME)", record.Challenge)
+	}
+	status("domain: %s", record.FQDN)
+	status("TXT: %s", record.Value)
+	status("添加完成后按回车继续")
+	_, e := bufio.NewReader(os.Stdin).ReadString('\n')
+	return e
+}
+
+func (p *manualDNSProvider) CleanUp(record *acme.DNSRecord) error {
+	status("可以删除 TXT 记录: %s", record.FQDN)
+	return nil
+}
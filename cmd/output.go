@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tonyzzp/acme"
+	"github.com/tonyzzp/acme/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ocsp"
+)
+
+const outputText = "text"
+const outputJson = "json"
+
+type orderView struct {
+	Id             string               `json:"id"`
+	Uri            string               `json:"uri"`
+	Status         string               `json:"status"`
+	Expires        string               `json:"expires,omitempty"`
+	NotBefore      string               `json:"notBefore,omitempty"`
+	NotAfter       string               `json:"notAfter,omitempty"`
+	Identifiers    []acme.Identifier    `json:"identifiers"`
+	Authorizations []*authorizationView `json:"authorizations"`
+	Finalize       string               `json:"finalize"`
+	Certificate    string               `json:"certificate,omitempty"`
+}
+
+// authorizationView has only the url for orders read from the store, the
+// commands that talk to the CA fetch the rest.
+type authorizationView struct {
+	Url        string           `json:"url"`
+	Status     string           `json:"status,omitempty"`
+	Expires    string           `json:"expires,omitempty"`
+	Identifier *acme.Identifier `json:"identifier,omitempty"`
+	Wildcard   bool             `json:"wildcard,omitempty"`
+	Challenges []*challengeView `json:"challenges,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type challengeView struct {
+	Type      string        `json:"type"`
+	Url       string        `json:"url"`
+	Status    string        `json:"status"`
+	Token     string        `json:"token,omitempty"`
+	Validated string        `json:"validated,omitempty"`
+	Error     *acme.Problem `json:"error,omitempty"`
+}
+
+type certView struct {
+	Name        string    `json:"name"`
+	Version     int       `json:"version,omitempty"`
+	Path        string    `json:"path"`
+	Subject     string    `json:"subject"`
+	SANs        []string  `json:"sans"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	DaysLeft    int       `json:"daysLeft"`
+	Fingerprint string    `json:"fingerprintSha256"`
+	ChainLength int       `json:"chainLength"`
+	ChainIssuer string    `json:"chainIssuer,omitempty"`
+}
+
+type renewView struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	NotAfter time.Time `json:"notAfter"`
+	Renew    bool      `json:"renew"`
+	Reason   string    `json:"reason,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type checkView struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Version   int       `json:"version,omitempty"`
+	Status    string    `json:"status"`
+	DaysLeft  int       `json:"daysLeft"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Domains   []string  `json:"domains"`
+	Serial    string    `json:"serial,omitempty"`
+	Problems  []string  `json:"problems"`
+}
+
+type ocspView struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status,omitempty"`
+	ThisUpdate *time.Time `json:"thisUpdate,omitempty"`
+	NextUpdate *time.Time `json:"nextUpdate,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	Stapled    bool       `json:"stapled"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type errorView struct {
+	Message    string        `json:"message"`
+	Code       int           `json:"code"`
+	Problem    *acme.Problem `json:"problem,omitempty"`
+	RetryAfter string        `json:"retryAfter,omitempty"`
+}
+
+func newOrderView(order *acme.Order) *orderView {
+	return &orderView{
+		Id:          utils.Md5String([]byte(order.Uri))[:5],
+		Uri:         order.Uri,
+		Status:      order.Status,
+		Expires:     order.Expires,
+		NotBefore:   order.NotBefore,
+		NotAfter:    order.NotAfter,
+		Identifiers: order.Identifiers,
+		Authorizations: utils.SliceMap(order.Authorizations, func(url string) *authorizationView {
+			return &authorizationView{Url: url}
+		}),
+		Finalize:    order.Finalize,
+		Certificate: order.Certificate,
+	}
+}
+
+// fetchOrderView is newOrderView with the authorizations fetched from the
+// CA for JSON output, text output only lists their urls. One that fails
+// to fetch keeps its url and the error.
+func fetchOrderView(c *cli.Context, order *acme.Order) *orderView {
+	rtn := newOrderView(order)
+	if outputFormat(c) != outputJson {
+		return rtn
+	}
+	for _, view := range rtn.Authorizations {
+		auth, e := getContext(c).Client.GetOrderAuth(view.Url)
+		if e != nil {
+			view.Error = e.Error()
+			continue
+		}
+		view.Status = auth.Status
+		view.Expires = auth.Expires
+		view.Identifier = &auth.Identifier
+		view.Wildcard = auth.Wildcard
+		for _, challenge := range auth.Challenges {
+			view.Challenges = append(view.Challenges, &challengeView{
+				Type:      challenge.Type,
+				Url:       challenge.Url,
+				Status:    challenge.Status,
+				Token:     challenge.Token,
+				Validated: challenge.Validated,
+				Error:     challenge.Error,
+			})
+		}
+	}
+	return rtn
+}
+
+func newCertView(cert *acme.Cert) *certView {
+	rtn := &certView{
+		Name:        cert.Name,
+		Version:     cert.Version,
+		Path:        cert.Path,
+		SANs:        []string{},
+		ChainLength: len(cert.Certs),
+	}
+	if cert.Chain != nil {
+		rtn.ChainIssuer = cert.Chain.Issuer
+	}
+	if len(cert.Certs) == 0 {
+		return rtn
+	}
+	leaf := cert.Certs[0]
+	rtn.Subject = leaf.Subject.String()
+	rtn.SANs = append(rtn.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		rtn.SANs = append(rtn.SANs, ip.String())
+	}
+	rtn.Issuer = leaf.Issuer.String()
+	rtn.Serial = leaf.SerialNumber.Text(16)
+	rtn.NotBefore = leaf.NotBefore
+	rtn.NotAfter = leaf.NotAfter
+	rtn.DaysLeft = int(time.Until(leaf.NotAfter).Hours() / 24)
+	sum := sha256.Sum256(leaf.Raw)
+	rtn.Fingerprint = hex.EncodeToString(sum[:])
+	return rtn
+}
+
+func newCheckView(check *acme.CertCheck) *checkView {
+	return &checkView{
+		Name:      check.Name,
+		Path:      check.Path,
+		Version:   check.Version,
+		Status:    check.Status,
+		DaysLeft:  check.DaysLeft,
+		NotBefore: check.NotBefore,
+		NotAfter:  check.NotAfter,
+		Domains:   check.Domains,
+		Serial:    check.Serial,
+		Problems:  check.Problems,
+	}
+}
+
+func newOCSPView(cert *acme.Cert, resp *ocsp.Response, err error) *ocspView {
+	rtn := &ocspView{Name: cert.Name, Stapled: len(cert.OCSPStaple) > 0}
+	if resp != nil {
+		rtn.Status = acme.OCSPStatusText(resp.Status)
+		rtn.ThisUpdate = &resp.ThisUpdate
+		if !resp.NextUpdate.IsZero() {
+			rtn.NextUpdate = &resp.NextUpdate
+		}
+		if resp.Status == ocsp.Revoked {
+			rtn.RevokedAt = &resp.RevokedAt
+		}
+	}
+	if err != nil {
+		rtn.Error = err.Error()
+	}
+	return rtn
+}
+
+func newRenewView(result *acme.RenewalResult) *renewView {
+	rtn := &renewView{
+		Name:     result.Name,
+		Path:     result.Path,
+		NotAfter: result.NotAfter,
+		Renew:    result.Renew,
+		Reason:   result.Reason,
+	}
+	if result.Error != nil {
+		rtn.Error = result.Error.Error()
+	}
+	return rtn
+}
+
+func outputFormat(c *cli.Context) string {
+	if c.App.Metadata == nil {
+		return outputText
+	}
+	context, ok := c.App.Metadata["context"].(*Context)
+	if !ok {
+		return outputText
+	}
+	return context.Output
+}
+
+// printResult writes the single result document of a command to stdout.
+func printResult(c *cli.Context, data any, text func(w io.Writer)) {
+	if outputFormat(c) == outputJson {
+		utils.DumpJson(data, os.Stdout)
+		return
+	}
+	text(os.Stdout)
+}
+
+// status writes progress messages for humans, they never go to stdout.
+func status(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func handleExitError(c *cli.Context, e error) {
+	if e == nil {
+		return
+	}
+	var status *exitError
+	if errors.As(e, &status) && status.err == nil {
+		os.Exit(status.code)
+	}
+	code := exitFailure
+	var coder cli.ExitCoder
+	if errors.As(e, &coder) {
+		code = coder.ExitCode()
+	}
+	if outputFormat(c) == outputJson {
+		view := &errorView{Message: e.Error(), Code: code}
+		var problem *acme.Problem
+		if errors.As(e, &problem) {
+			view.Problem = problem
+			if !problem.RetryAfter.IsZero() {
+				view.RetryAfter = problem.RetryAfter.Format(time.RFC3339)
+			}
+		}
+		utils.DumpJson(map[string]any{"error": view}, os.Stdout)
+	} else {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	os.Exit(code)
+}
+
+func printHistoryText(w io.Writer, m *acme.CertManifest) {
+	if len(m.Versions) == 0 {
+		fmt.Fprintln(w, m.Name, "was stored before versioning and has no history yet")
+		return
+	}
+	for _, v := range m.Versions {
+		mark := " "
+		if v.Version == m.Current {
+			mark = "*"
+		}
+		domains := utils.SliceMap(v.Identifiers, func(id acme.Identifier) string { return id.Value })
+		fmt.Fprintf(w, "%s %3d  %s  expires %s  serial %s  %s\n", mark, v.Version, v.Created.Format(time.RFC3339), v.NotAfter.Format(time.RFC3339), v.Serial, strings.Join(domains, ","))
+	}
+}
+
+// printChecksText writes a Nagios plugin style summary line with days
+// left as performance data, followed by one line per certificate.
+func printChecksText(w io.Writer, checks []*acme.CertCheck, options *acme.CheckOptions) {
+	status := acme.WorstStatus(checks)
+	counts := map[string]int{}
+	perf := make([]string, 0)
+	for _, check := range checks {
+		counts[check.Status]++
+		if !check.NotAfter.IsZero() {
+			perf = append(perf, fmt.Sprintf("'%s'=%d;%d;%d", check.Name, check.DaysLeft, options.WarningDays, options.CriticalDays))
+		}
+	}
+	summary := fmt.Sprintf("CERT %s - %d certificates", strings.ToUpper(status), len(checks))
+	for _, s := range []string{acme.CheckCritical, acme.CheckWarning, acme.CheckUnknown} {
+		if counts[s] > 0 {
+			summary += fmt.Sprintf(", %d %s", counts[s], s)
+		}
+	}
+	if len(perf) > 0 {
+		summary += " | " + strings.Join(perf, " ")
+	}
+	fmt.Fprintln(w, summary)
+	for _, check := range checks {
+		details := check.Problems
+		if !check.NotAfter.IsZero() {
+			details = append([]string{fmt.Sprintf("%d days left", check.DaysLeft)}, details...)
+		}
+		line := fmt.Sprintf("%s %s: %s", strings.ToUpper(check.Status), check.Name, strings.Join(details, ", "))
+		fmt.Fprintln(w, line)
+	}
+}
+
+func printChecksCSV(w io.Writer, checks []*acme.CertCheck) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"name", "status", "days_left", "not_before", "not_after", "domains", "serial", "version", "path", "problems"})
+	for _, check := range checks {
+		notBefore, notAfter := "", ""
+		if !check.NotAfter.IsZero() {
+			notBefore = check.NotBefore.Format(time.RFC3339)
+			notAfter = check.NotAfter.Format(time.RFC3339)
+		}
+		out.Write([]string{
+			check.Name,
+			check.Status,
+			strconv.Itoa(check.DaysLeft),
+			notBefore,
+			notAfter,
+			strings.Join(check.Domains, " "),
+			check.Serial,
+			strconv.Itoa(check.Version),
+			check.Path,
+			strings.Join(check.Problems, "; "),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func printOrdersText(w io.Writer, orders []*acme.Order) {
+	fmt.Fprintln(w, "local orders: ", len(orders))
+	for _, order := range orders {
+		printOrderText(w, order)
+	}
+}
+
+func printOrderText(w io.Writer, order *acme.Order) {
+	fmt.Fprintln(w, "-----")
+	fmt.Fprintln(w, "  id: ", utils.Md5String([]byte(order.Uri))[:5])
+	fmt.Fprintln(w, "  uri: ", order.Uri)
+	fmt.Fprintln(w, "  status: ", order.Status)
+	fmt.Fprintln(w, "  expires: ", order.Expires)
+	for _, identifier := range order.Identifiers {
+		fmt.Fprintln(w, "  identifier: ", identifier.Type, identifier.Value)
+	}
+	for _, auth := range order.Authorizations {
+		fmt.Fprintln(w, "  auth: ", auth)
+	}
+	fmt.Fprintln(w, "  finalize: ", order.Finalize)
+	fmt.Fprintln(w, "  certificate: ", order.Certificate)
+}
+
+func printCertText(w io.Writer, cert *acme.Cert) {
+	fmt.Fprintln(w, "-----")
+	fmt.Fprintln(w, "name: ", cert.Name)
+	if cert.Version > 0 {
+		fmt.Fprintln(w, "version: ", cert.Version)
+	}
+	fmt.Fprintln(w, "path: ", cert.Path)
+	fmt.Fprintln(w, "certs:", len(cert.Certs))
+	for _, c := range cert.Certs {
+		fmt.Fprintln(w, "  --")
+		fmt.Fprintln(w, "  Subject: ", c.Subject)
+		fmt.Fprintln(w, "  dns: ", c.DNSNames)
+		fmt.Fprintln(w, "  validity: ", c.NotBefore, " - ", c.NotAfter)
+	}
+}
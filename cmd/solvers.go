@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tonyzzp/acme"
+	"github.com/urfave/cli/v2"
+)
+
+var solverFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "solver",
+		Usage: "challenge solver: manual, acme-dns, dns-server, exec or webroot",
+		Value: "manual",
+	},
+	&cli.DurationFlag{
+		Name:  "dns-wait",
+		Usage: "time to wait for dns propagation after presenting a record",
+	},
+	&cli.BoolFlag{
+		Name:  "dns-no-cname",
+		Usage: "publish dns-01 records at _acme-challenge itself instead of following its CNAME",
+	},
+	&cli.StringFlag{
+		Name:    "acme-dns-server",
+		Usage:   "url of the acme-dns server, domains are registered on first use and saved in acme-dns.json",
+		EnvVars: []string{"ACME_DNS_SERVER"},
+	},
+	&cli.StringSliceFlag{
+		Name:  "acme-dns-allow-from",
+		Usage: "CIDR allowed to update the records of new acme-dns registrations",
+	},
+	&cli.StringFlag{
+		Name:  "dns-server-zone",
+		Usage: "zone delegated to the embedded dns server, _acme-challenge names point into it with CNAMEs",
+	},
+	&cli.StringFlag{
+		Name:  "dns-server-listen",
+		Usage: "udp and tcp address of the embedded dns server",
+		Value: ":53",
+	},
+	&cli.StringSliceFlag{
+		Name:  "dns-server-ns",
+		Usage: "name servers of the zone in NS and SOA answers, the zone itself by default",
+	},
+	&cli.StringFlag{
+		Name:  "dns-exec",
+		Usage: "executable run as <dns-exec> present|cleanup <fqdn> <value>, also gets ACME_* variables and JSON on stdin",
+	},
+	&cli.StringSliceFlag{
+		Name:  "dns-exec-arg",
+		Usage: "argument passed to --dns-exec before the action",
+	},
+	&cli.DurationFlag{
+		Name:  "dns-exec-timeout",
+		Usage: "timeout of each --dns-exec run",
+		Value: 2 * time.Minute,
+	},
+	&cli.StringFlag{
+		Name:  "webroot",
+		Usage: "document root the http-01 challenge files are written into",
+	},
+	&cli.StringSliceFlag{
+		Name:  "webroot-map",
+		Usage: "domain=path, the document root of one domain, *.example.com for its subdomains",
+	},
+	&cli.StringFlag{
+		Name:  "webroot-owner",
+		Usage: "owner of the challenge files and directories, user[:group]",
+	},
+	&cli.StringFlag{
+		Name:  "webroot-mode",
+		Usage: "octal mode of the challenge files",
+		Value: "0644",
+	},
+}
+
+func newDNS01Solver(c *cli.Context, provider acme.DNSProvider) *acme.DNS01Solver {
+	return &acme.DNS01Solver{
+		Provider:        provider,
+		PropagationWait: c.Duration("dns-wait"),
+		NoCNAME:         c.Bool("dns-no-cname"),
+		Resolvers:       c.StringSlice("dns-resolver"),
+		Logger:          getContext(c).Client.Logger,
+	}
+}
+
+// newSolver returns the solver of --solver and a function that stops what
+// it started, the embedded dns server runs as long as the command does.
+func newSolver(c *cli.Context) (acme.Solver, func(), error) {
+	switch c.String("solver") {
+	case "manual":
+		return newDNS01Solver(c, &manualDNSProvider{}), func() {}, nil
+	case "acme-dns":
+		if c.String("acme-dns-server") == "" {
+			return nil, nil, usageError("--solver acme-dns needs --acme-dns-server")
+		}
+		provider := getContext(c).Client.NewAcmeDNSProvider(c.String("acme-dns-server"))
+		provider.AllowFrom = c.StringSlice("acme-dns-allow-from")
+		return newDNS01Solver(c, provider), func() {}, nil
+	case "dns-server":
+		if c.String("dns-server-zone") == "" {
+			return nil, nil, usageError("--solver dns-server needs --dns-server-zone")
+		}
+		server := acme.NewDNSServer(c.String("dns-server-zone"), c.String("dns-server-listen"))
+		server.NS = c.StringSlice("dns-server-ns")
+		server.Logger = getContext(c).Client.Logger
+		e := server.Start()
+		if e != nil {
+			return nil, nil, fail(e)
+		}
+		return newDNS01Solver(c, server), func() { server.Close() }, nil
+	case "exec":
+		if c.String("dns-exec") == "" {
+			return nil, nil, usageError("--solver exec needs --dns-exec")
+		}
+		provider := &acme.ExecDNSProvider{
+			Program: c.String("dns-exec"),
+			Args:    c.StringSlice("dns-exec-arg"),
+			Timeout: c.Duration("dns-exec-timeout"),
+			Logger:  getContext(c).Client.Logger,
+		}
+		return newDNS01Solver(c, provider), func() {}, nil
+	case "webroot":
+		solver := &acme.WebrootSolver{
+			Webroot:  c.String("webroot"),
+			Webroots: make(map[string]string),
+			Owner:    c.String("webroot-owner"),
+		}
+		for _, v := range c.StringSlice("webroot-map") {
+			domain, path, ok := strings.Cut(v, "=")
+			if !ok || domain == "" || path == "" {
+				return nil, nil, usageError("bad --webroot-map %q, want domain=path", v)
+			}
+			solver.Webroots[strings.ToLower(domain)] = path
+		}
+		if solver.Webroot == "" && len(solver.Webroots) == 0 {
+			return nil, nil, usageError("--solver webroot needs --webroot or --webroot-map")
+		}
+		mode, e := strconv.ParseUint(c.String("webroot-mode"), 8, 32)
+		if e != nil {
+			return nil, nil, usageError("bad --webroot-mode %q", c.String("webroot-mode"))
+		}
+		solver.Mode = os.FileMode(mode)
+		return solver, func() {}, nil
+	}
+	return nil, nil, usageError("unknown solver %q", c.String("solver"))
+}
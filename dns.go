@@ -0,0 +1,118 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tonyzzp/acme/utils"
+)
+
+const dnsTimeout = 5 * time.Second
+
+// maxCNAMEHops bounds CNAME chains, longer ones are most likely loops.
+const maxCNAMEHops = 10
+
+// resolvConf lists the system resolvers, missing on Windows and in some
+// containers.
+var resolvConf = "/etc/resolv.conf"
+
+// errNoResolver is returned when no resolver is configured or none of them
+// answers.
+var errNoResolver = errors.New("no dns resolver available")
+
+// dnsResolvers returns resolvers with a port, those of resolv.conf when
+// resolvers is empty.
+func dnsResolvers(resolvers []string) ([]string, error) {
+	if len(resolvers) > 0 {
+		return utils.SliceMap(resolvers, func(v string) string { return dnsAddr(v, "53") }), nil
+	}
+	config, e := dns.ClientConfigFromFile(resolvConf)
+	if e != nil {
+		return nil, fmt.Errorf("%w: %w", errNoResolver, e)
+	}
+	rtn := make([]string, 0)
+	for _, server := range config.Servers {
+		rtn = append(rtn, dnsAddr(server, config.Port))
+	}
+	if len(rtn) == 0 {
+		return nil, fmt.Errorf("%w: %s lists none", errNoResolver, resolvConf)
+	}
+	return rtn, nil
+}
+
+// dnsAddr adds port to a resolver given without one.
+func dnsAddr(server string, port string) string {
+	if _, _, e := net.SplitHostPort(server); e == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
+
+// exchangeDNS asks server over UDP and again over TCP when the answer was
+// truncated.
+func exchangeDNS(msg *dns.Msg, server string, timeout time.Duration) (*dns.Msg, error) {
+	c := &dns.Client{Timeout: timeout}
+	res, _, e := c.Exchange(msg, server)
+	if e == nil && res.Truncated {
+		c.Net = "tcp"
+		res, _, e = c.Exchange(msg, server)
+	}
+	return res, e
+}
+
+// queryDNS asks the resolvers in turn until one answers NOERROR or
+// NXDOMAIN.
+func queryDNS(name string, qtype uint16, resolvers []string, timeout time.Duration) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = true
+	msg.SetEdns0(4096, false)
+	var lastErr error
+	for _, resolver := range resolvers {
+		res, e := exchangeDNS(msg, resolver, timeout)
+		if e != nil {
+			lastErr = e
+			continue
+		}
+		if res.Rcode != dns.RcodeSuccess && res.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s answered %s for %s", resolver, dns.RcodeToString[res.Rcode], name)
+			continue
+		}
+		return res, nil
+	}
+	if lastErr == nil {
+		return nil, errNoResolver
+	}
+	return nil, fmt.Errorf("%w: %w", errNoResolver, lastErr)
+}
+
+// followCNAME returns the name fqdn finally points to, fqdn itself when it
+// is not an alias.
+func followCNAME(fqdn string, resolvers []string, timeout time.Duration) (string, error) {
+	name := dns.Fqdn(fqdn)
+	seen := map[string]bool{}
+	for hops := 0; ; hops++ {
+		if seen[strings.ToLower(name)] || hops > maxCNAMEHops {
+			return "", fmt.Errorf("CNAME loop at %s", name)
+		}
+		seen[strings.ToLower(name)] = true
+		res, e := queryDNS(name, dns.TypeCNAME, resolvers, timeout)
+		if e != nil {
+			return "", e
+		}
+		var target string
+		for _, rr := range res.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				target = cname.Target
+			}
+		}
+		if target == "" {
+			return name, nil
+		}
+		name = dns.Fqdn(target)
+	}
+}
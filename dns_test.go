@@ -0,0 +1,155 @@
+package acme
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startTestResolver serves records, given in zone file syntax, over UDP
+// on a local port and returns its address. Names with no record of the
+// asked type answer NODATA, unknown names NXDOMAIN.
+func startTestResolver(t *testing.T, records ...string) string {
+	t.Helper()
+	rrs := make([]dns.RR, 0)
+	for _, record := range records {
+		rr, e := dns.NewRR(record)
+		if e != nil {
+			t.Fatal(e)
+		}
+		rrs = append(rrs, rr)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		known := false
+		for _, rr := range rrs {
+			if !strings.EqualFold(rr.Header().Name, q.Name) {
+				continue
+			}
+			known = true
+			if rr.Header().Rrtype == q.Qtype {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		if !known {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	pc, e := net.ListenPacket("udp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	var started sync.WaitGroup
+	started.Add(1)
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: started.Done}
+	go server.ActivateAndServe()
+	started.Wait()
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// closedPort returns a local UDP address nobody listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	pc, e := net.ListenPacket("udp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	return addr
+}
+
+type recordingProvider struct {
+	presented []*DNSRecord
+	cleaned   []*DNSRecord
+}
+
+func (p *recordingProvider) Present(record *DNSRecord) error {
+	p.presented = append(p.presented, record)
+	return nil
+}
+
+func (p *recordingProvider) CleanUp(record *DNSRecord) error {
+	p.cleaned = append(p.cleaned, record)
+	return nil
+}
+
+func TestDNS01FollowsCNAME(t *testing.T) {
+	addr := startTestResolver(t,
+		"_acme-challenge.example.test. 60 IN CNAME example.test.auth.test.",
+		"example.test.auth.test. 60 IN CNAME d420c923.acme-dns.test.",
+	)
+	provider := &recordingProvider{}
+	solver := &DNS01Solver{Provider: provider, Resolvers: []string{addr}}
+	e := solver.Present("*.example.test", "token", "keyAuth")
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = solver.CleanUp("*.example.test", "token", "keyAuth")
+	if e != nil {
+		t.Fatal(e)
+	}
+	record := provider.presented[0]
+	if record.FQDN != "d420c923.acme-dns.test." || record.Challenge != "_acme-challenge.example.test." {
+		t.Fatalf("presented %s for %s", record.FQDN, record.Challenge)
+	}
+	if provider.cleaned[0].FQDN != record.FQDN {
+		t.Fatalf("cleaned up %s", provider.cleaned[0].FQDN)
+	}
+
+	// not delegated
+	e = solver.Present("other.test", "token", "keyAuth")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if fqdn := provider.presented[1].FQDN; fqdn != "_acme-challenge.other.test." {
+		t.Fatalf("presented %s", fqdn)
+	}
+}
+
+func TestDNS01CNAMELoop(t *testing.T) {
+	addr := startTestResolver(t,
+		"_acme-challenge.example.test. 60 IN CNAME a.test.",
+		"a.test. 60 IN CNAME _acme-challenge.example.test.",
+	)
+	solver := &DNS01Solver{Provider: &recordingProvider{}, Resolvers: []string{addr}}
+	e := solver.Present("example.test", "token", "keyAuth")
+	if e == nil || !strings.Contains(e.Error(), "loop") {
+		t.Fatalf("expected a CNAME loop, got %v", e)
+	}
+}
+
+func TestDNS01WithoutResolver(t *testing.T) {
+	provider := &recordingProvider{}
+	out := &bytes.Buffer{}
+	solver := &DNS01Solver{Provider: provider, Resolvers: []string{closedPort(t)}, Logger: slog.New(slog.NewTextHandler(out, nil))}
+	e := solver.Present("example.test", "token", "keyAuth")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(out.String(), "cannot follow CNAME") {
+		t.Fatalf("fallback not logged to the solver logger: %q", out)
+	}
+
+	old := resolvConf
+	resolvConf = filepath.Join(t.TempDir(), "missing")
+	defer func() { resolvConf = old }()
+	solver.Resolvers = nil
+	e = solver.CleanUp("example.test", "token", "keyAuth")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if provider.presented[0].FQDN != "_acme-challenge.example.test." || provider.cleaned[0].FQDN != "_acme-challenge.example.test." {
+		t.Fatalf("presented %s, cleaned %s", provider.presented[0].FQDN, provider.cleaned[0].FQDN)
+	}
+}
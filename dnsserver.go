@@ -0,0 +1,243 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSServer is an authoritative name server for a zone delegated to this
+// client, e.g. with "acme.example.net NS ns1.example.net". It is a
+// DNSProvider: records presented for names in the zone are served as TXT
+// until they are cleaned up, several values per name so a name and its
+// wildcard validate together. The zone apex answers SOA and NS, other
+// names NXDOMAIN and names outside the zone are refused.
+type DNSServer struct {
+	Zone string
+	// NS are the name servers of the zone, the zone itself when empty.
+	NS []string
+	// Addr is the UDP and TCP address to listen on, ":53" when empty.
+	Addr string
+	TTL  uint32
+	// Logger is slog.Default() when nil.
+	Logger  *slog.Logger
+	lock    sync.RWMutex
+	records map[string][]string
+	serial  uint32
+	servers []*dns.Server
+}
+
+func NewDNSServer(zone string, addr string) *DNSServer {
+	return &DNSServer{
+		Zone:    dns.Fqdn(strings.ToLower(zone)),
+		Addr:    addr,
+		TTL:     60,
+		records: make(map[string][]string),
+		serial:  uint32(time.Now().Unix()),
+	}
+}
+
+func (s *DNSServer) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// Start listens on Addr over UDP and TCP and serves until Close.
+func (s *DNSServer) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.servers) > 0 {
+		return errors.New("dns server already started")
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = ":53"
+	}
+	pc, e := net.ListenPacket("udp", addr)
+	if e != nil {
+		return e
+	}
+	// the same port for tcp when addr asked for any port
+	ln, e := net.Listen("tcp", pc.LocalAddr().String())
+	if e != nil {
+		pc.Close()
+		return e
+	}
+	var started sync.WaitGroup
+	started.Add(2)
+	s.servers = []*dns.Server{
+		{PacketConn: pc, Handler: s, NotifyStartedFunc: started.Done},
+		{Listener: ln, Handler: s, NotifyStartedFunc: started.Done},
+	}
+	for _, server := range s.servers {
+		go func(server *dns.Server) {
+			e := server.ActivateAndServe()
+			if e != nil {
+				s.logger().Warn("dns server stopped", "error", e)
+			}
+		}(server)
+	}
+	started.Wait()
+	s.logger().Info("dns server started", "zone", s.Zone, "addr", pc.LocalAddr().String())
+	return nil
+}
+
+// LocalAddr is the UDP address the server listens on after Start.
+func (s *DNSServer) LocalAddr() net.Addr {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.servers) == 0 {
+		return nil
+	}
+	return s.servers[0].PacketConn.LocalAddr()
+}
+
+func (s *DNSServer) Close() error {
+	s.lock.Lock()
+	servers := s.servers
+	s.servers = nil
+	s.lock.Unlock()
+	var rtn error
+	for _, server := range servers {
+		e := server.Shutdown()
+		if e != nil {
+			rtn = e
+		}
+	}
+	return rtn
+}
+
+// Run serves until ctx is done.
+func (s *DNSServer) Run(ctx context.Context) error {
+	e := s.Start()
+	if e != nil {
+		return e
+	}
+	<-ctx.Done()
+	s.Close()
+	return ctx.Err()
+}
+
+func (s *DNSServer) Present(record *DNSRecord) error {
+	name := strings.ToLower(dns.Fqdn(record.FQDN))
+	if !dns.IsSubDomain(s.Zone, name) {
+		return fmt.Errorf("%s is not in the zone %s of the dns server, delegate it with a CNAME", name, s.Zone)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, v := range s.records[name] {
+		if v == record.Value {
+			return nil
+		}
+	}
+	s.records[name] = append(s.records[name], record.Value)
+	s.serial++
+	return nil
+}
+
+func (s *DNSServer) CleanUp(record *DNSRecord) error {
+	name := strings.ToLower(dns.Fqdn(record.FQDN))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	values := make([]string, 0)
+	for _, v := range s.records[name] {
+		if v != record.Value {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		delete(s.records, name)
+	} else {
+		s.records[name] = values
+	}
+	s.serial++
+	return nil
+}
+
+func (s *DNSServer) header(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: s.TTL}
+}
+
+func (s *DNSServer) soa() dns.RR {
+	ns := s.Zone
+	if len(s.NS) > 0 {
+		ns = dns.Fqdn(s.NS[0])
+	}
+	return &dns.SOA{
+		Hdr:     s.header(s.Zone, dns.TypeSOA),
+		Ns:      ns,
+		Mbox:    "hostmaster." + s.Zone,
+		Serial:  s.serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  s.TTL,
+	}
+}
+
+// ServeDNS implements dns.Handler.
+func (s *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if r.Opcode != dns.OpcodeQuery || len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeNotImplemented)
+		w.WriteMsg(m)
+		return
+	}
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+	if q.Qclass != dns.ClassINET || !dns.IsSubDomain(s.Zone, name) {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+	m.Authoritative = true
+	s.lock.RLock()
+	values, exists := s.records[name]
+	apex := name == s.Zone
+	for other := range s.records {
+		// an empty non terminal exists too
+		exists = exists || strings.HasSuffix(other, "."+name)
+	}
+	switch {
+	case apex && q.Qtype == dns.TypeSOA:
+		m.Answer = append(m.Answer, s.soa())
+	case apex && q.Qtype == dns.TypeNS:
+		ns := s.NS
+		if len(ns) == 0 {
+			ns = []string{s.Zone}
+		}
+		for _, v := range ns {
+			m.Answer = append(m.Answer, &dns.NS{Hdr: s.header(q.Name, dns.TypeNS), Ns: dns.Fqdn(v)})
+		}
+	case len(values) > 0 && (q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeANY):
+		for _, v := range values {
+			m.Answer = append(m.Answer, &dns.TXT{Hdr: s.header(q.Name, dns.TypeTXT), Txt: []string{v}})
+		}
+	case exists || apex:
+		// no data of this type
+		m.Ns = append(m.Ns, s.soa())
+	default:
+		m.Rcode = dns.RcodeNameError
+		m.Ns = append(m.Ns, s.soa())
+	}
+	s.lock.RUnlock()
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = int(opt.UDPSize())
+		m.SetEdns0(opt.UDPSize(), false)
+	}
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		m.Truncate(size)
+	}
+	w.WriteMsg(m)
+}
@@ -0,0 +1,132 @@
+package acme
+
+import (
+	"bytes"
+	"log/slog"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func startTestDNSServer(t *testing.T) (*DNSServer, *bytes.Buffer) {
+	t.Helper()
+	out := &bytes.Buffer{}
+	s := NewDNSServer("ACME.example.test", "127.0.0.1:0")
+	s.NS = []string{"ns1.example.test", "ns2.example.test"}
+	s.Logger = slog.New(slog.NewTextHandler(out, nil))
+	e := s.Start()
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, out
+}
+
+func queryTestDNSServer(t *testing.T, s *DNSServer, network string, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	client := &dns.Client{Net: network}
+	res, _, e := client.Exchange(msg, s.LocalAddr().String())
+	if e != nil {
+		t.Fatal(e)
+	}
+	return res
+}
+
+func txtValues(res *dns.Msg) []string {
+	rtn := make([]string, 0)
+	for _, rr := range res.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			rtn = append(rtn, strings.Join(txt.Txt, ""))
+		}
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+func TestDNSServerTXT(t *testing.T) {
+	s, out := startTestDNSServer(t)
+	if !strings.Contains(out.String(), "dns server started") {
+		t.Fatalf("start not logged to the server logger: %q", out)
+	}
+	fqdn := "_acme-challenge.www.acme.example.test."
+	for _, value := range []string{"name", "wildcard", "name"} {
+		e := s.Present(&DNSRecord{FQDN: fqdn, Value: value})
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	for _, network := range []string{"udp", "tcp"} {
+		res := queryTestDNSServer(t, s, network, "_ACME-challenge.www.acme.example.test.", dns.TypeTXT)
+		if res.Rcode != dns.RcodeSuccess || !res.Authoritative {
+			t.Fatalf("%s: rcode %s, authoritative %v", network, dns.RcodeToString[res.Rcode], res.Authoritative)
+		}
+		if values := txtValues(res); strings.Join(values, ",") != "name,wildcard" {
+			t.Fatalf("%s: values %v", network, values)
+		}
+	}
+
+	e := s.CleanUp(&DNSRecord{FQDN: fqdn, Value: "name"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if values := txtValues(queryTestDNSServer(t, s, "udp", fqdn, dns.TypeTXT)); strings.Join(values, ",") != "wildcard" {
+		t.Fatalf("values after the clean up %v", values)
+	}
+	s.CleanUp(&DNSRecord{FQDN: fqdn, Value: "wildcard"})
+	if res := queryTestDNSServer(t, s, "udp", fqdn, dns.TypeTXT); res.Rcode != dns.RcodeNameError {
+		t.Fatalf("cleaned up name answers %s", dns.RcodeToString[res.Rcode])
+	}
+
+	e = s.Present(&DNSRecord{FQDN: "_acme-challenge.example.test.", Value: "value"})
+	if e == nil {
+		t.Fatal("presented a record outside the zone")
+	}
+}
+
+func TestDNSServerZone(t *testing.T) {
+	s, _ := startTestDNSServer(t)
+	s.Present(&DNSRecord{FQDN: "_acme-challenge.a.b.acme.example.test.", Value: "value"})
+
+	res := queryTestDNSServer(t, s, "udp", "acme.example.test.", dns.TypeSOA)
+	if len(res.Answer) != 1 || res.Answer[0].(*dns.SOA).Ns != "ns1.example.test." {
+		t.Fatalf("SOA %v", res.Answer)
+	}
+	res = queryTestDNSServer(t, s, "tcp", "acme.example.test.", dns.TypeNS)
+	if len(res.Answer) != 2 {
+		t.Fatalf("NS %v", res.Answer)
+	}
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		// NODATA, with the SOA for negative caching
+		{"acme.example.test.", dns.TypeTXT, dns.RcodeSuccess},
+		{"_acme-challenge.a.b.acme.example.test.", dns.TypeA, dns.RcodeSuccess},
+		// empty non terminals
+		{"a.b.acme.example.test.", dns.TypeTXT, dns.RcodeSuccess},
+		{"b.acme.example.test.", dns.TypeTXT, dns.RcodeSuccess},
+		{"c.acme.example.test.", dns.TypeTXT, dns.RcodeNameError},
+		{"x.a.b.acme.example.test.", dns.TypeTXT, dns.RcodeNameError},
+		{"example.test.", dns.TypeSOA, dns.RcodeRefused},
+		{"other.test.", dns.TypeTXT, dns.RcodeRefused},
+	}
+	for _, test := range tests {
+		res := queryTestDNSServer(t, s, "udp", test.name, test.qtype)
+		if res.Rcode != test.rcode {
+			t.Errorf("%s %s: %s, want %s", test.name, dns.TypeToString[test.qtype], dns.RcodeToString[res.Rcode], dns.RcodeToString[test.rcode])
+			continue
+		}
+		if len(res.Answer) != 0 {
+			t.Errorf("%s %s: answered %v", test.name, dns.TypeToString[test.qtype], res.Answer)
+		}
+		if test.rcode != dns.RcodeRefused && (len(res.Ns) != 1 || res.Ns[0].Header().Rrtype != dns.TypeSOA) {
+			t.Errorf("%s %s: no SOA in %v", test.name, dns.TypeToString[test.qtype], res.Ns)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execOutputLimit bounds the stderr kept in an ExecDNSError.
+const execOutputLimit = 4096
+
+// ExecDNSProvider publishes dns-01 records with an executable, for DNS
+// systems without a provider of their own. It is run as
+//
+//	Program [Args...] present|cleanup <fqdn> <value>
+//
+// with ACME_ACTION, ACME_DOMAIN, ACME_FQDN, ACME_CHALLENGE, ACME_VALUE and
+// ACME_TOKEN in the environment and the same fields as a JSON object on
+// stdin. Its output goes to the log, a non zero exit fails the challenge.
+type ExecDNSProvider struct {
+	Program string
+	Args    []string
+	// Timeout kills the program, two minutes when 0.
+	Timeout time.Duration
+	// Logger gets the output of the program, slog.Default() when nil.
+	Logger *slog.Logger
+}
+
+// ExecDNSError is a failed run of an ExecDNSProvider program.
+type ExecDNSError struct {
+	Program string
+	Action  string
+	FQDN    string
+	// ExitCode is -1 when the program did not exit by itself.
+	ExitCode int
+	TimedOut bool
+	Stderr   string
+	Err      error
+}
+
+func (e *ExecDNSError) Error() string {
+	msg := fmt.Sprintf("dns hook %s %s %s: %s", e.Program, e.Action, e.FQDN, e.Err)
+	if e.TimedOut {
+		msg = fmt.Sprintf("dns hook %s %s %s: timed out", e.Program, e.Action, e.FQDN)
+	}
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		lines := strings.Split(stderr, "\n")
+		msg += ": " + lines[len(lines)-1]
+	}
+	return msg
+}
+
+func (e *ExecDNSError) Unwrap() error {
+	return e.Err
+}
+
+type execDNSPayload struct {
+	Action    string `json:"action"`
+	Domain    string `json:"domain"`
+	FQDN      string `json:"fqdn"`
+	Challenge string `json:"challenge"`
+	Value     string `json:"value"`
+	Token     string `json:"token"`
+}
+
+func (p *ExecDNSProvider) logger() *slog.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return slog.Default()
+}
+
+func (p *ExecDNSProvider) Present(record *DNSRecord) error {
+	return p.run("present", record)
+}
+
+func (p *ExecDNSProvider) CleanUp(record *DNSRecord) error {
+	return p.run("cleanup", record)
+}
+
+func (p *ExecDNSProvider) run(action string, record *DNSRecord) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	payload, e := json.Marshal(&execDNSPayload{
+		Action:    action,
+		Domain:    record.Domain,
+		FQDN:      record.FQDN,
+		Challenge: record.Challenge,
+		Value:     record.Value,
+		Token:     record.Token,
+	})
+	if e != nil {
+		return e
+	}
+	args := append(append([]string{}, p.Args...), action, record.FQDN, record.Value)
+	cmd := exec.CommandContext(ctx, p.Program, args...)
+	cmd.Env = append(os.Environ(),
+		"ACME_ACTION="+action,
+		"ACME_DOMAIN="+record.Domain,
+		"ACME_FQDN="+record.FQDN,
+		"ACME_CHALLENGE="+record.Challenge,
+		"ACME_VALUE="+record.Value,
+		"ACME_TOKEN="+record.Token,
+	)
+	cmd.Stdin = bytes.NewReader(payload)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// children that keep the pipes open must not block after a kill
+	cmd.WaitDelay = time.Second
+	p.logger().Debug("run dns hook", "program", p.Program, "action", action, "fqdn", record.FQDN)
+	e = cmd.Run()
+	if stdout.Len() > 0 {
+		p.logger().Info("dns hook output", "program", p.Program, "action", action, "stdout", stdout.String())
+	}
+	if stderr.Len() > 0 {
+		p.logger().Warn("dns hook output", "program", p.Program, "action", action, "stderr", stderr.String())
+	}
+	if e == nil {
+		return nil
+	}
+	rtn := &ExecDNSError{
+		Program:  p.Program,
+		Action:   action,
+		FQDN:     record.FQDN,
+		ExitCode: -1,
+		TimedOut: errors.Is(ctx.Err(), context.DeadlineExceeded),
+		Stderr:   stderr.String(),
+		Err:      e,
+	}
+	if len(rtn.Stderr) > execOutputLimit {
+		rtn.Stderr = rtn.Stderr[len(rtn.Stderr)-execOutputLimit:]
+	}
+	var exitErr *exec.ExitError
+	if errors.As(e, &exitErr) && exitErr.Exited() {
+		rtn.ExitCode = exitErr.ExitCode()
+	}
+	return rtn
+}
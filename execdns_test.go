@@ -0,0 +1,137 @@
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newExecDNSTest returns a provider running testdata/dnshook.sh in mode
+// and the prefix of the files the script records its input in.
+func newExecDNSTest(t *testing.T, mode string) (*ExecDNSProvider, string, *bytes.Buffer) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	program, e := filepath.Abs("testdata/dnshook.sh")
+	if e != nil {
+		t.Fatal(e)
+	}
+	out := filepath.Join(t.TempDir(), "hook")
+	t.Setenv("HOOK_OUT", out)
+	t.Setenv("HOOK_MODE", mode)
+	var buf bytes.Buffer
+	p := &ExecDNSProvider{
+		Program: program,
+		Args:    []string{"--zone", "example.test"},
+		Logger:  slog.New(slog.NewTextHandler(&buf, nil)),
+	}
+	return p, out, &buf
+}
+
+var execTestRecord = &DNSRecord{
+	Domain:    "www.example.test",
+	Challenge: "_acme-challenge.www.example.test.",
+	FQDN:      "www.auth.test.",
+	Value:     "value",
+	Token:     "token",
+}
+
+func TestExecDNSProvider(t *testing.T) {
+	p, out, log := newExecDNSTest(t, "")
+	e := p.Present(execTestRecord)
+	if e != nil {
+		t.Fatal(e)
+	}
+	args, _ := os.ReadFile(out + ".args")
+	if string(args) != "--zone\nexample.test\npresent\nwww.auth.test.\nvalue\n" {
+		t.Fatalf("args %q", args)
+	}
+	env, _ := os.ReadFile(out + ".env")
+	want := strings.Join([]string{
+		"ACME_ACTION=present",
+		"ACME_CHALLENGE=_acme-challenge.www.example.test.",
+		"ACME_DOMAIN=www.example.test",
+		"ACME_FQDN=www.auth.test.",
+		"ACME_TOKEN=token",
+		"ACME_VALUE=value",
+	}, "\n") + "\n"
+	if string(env) != want {
+		t.Fatalf("environment %q", env)
+	}
+	stdin, _ := os.ReadFile(out + ".stdin")
+	payload := map[string]string{}
+	e = json.Unmarshal(stdin, &payload)
+	if e != nil {
+		t.Fatalf("stdin %q: %v", stdin, e)
+	}
+	wantPayload := map[string]string{
+		"action":    "present",
+		"domain":    "www.example.test",
+		"fqdn":      "www.auth.test.",
+		"challenge": "_acme-challenge.www.example.test.",
+		"value":     "value",
+		"token":     "token",
+	}
+	for k, v := range wantPayload {
+		if payload[k] != v {
+			t.Errorf("stdin %s is %q, want %q", k, payload[k], v)
+		}
+	}
+	if !strings.Contains(log.String(), "done present") {
+		t.Fatalf("output not logged: %s", log)
+	}
+
+	e = p.CleanUp(execTestRecord)
+	if e != nil {
+		t.Fatal(e)
+	}
+	args, _ = os.ReadFile(out + ".args")
+	if !strings.Contains(string(args), "\ncleanup\n") {
+		t.Fatalf("args %q", args)
+	}
+}
+
+func TestExecDNSProviderFailure(t *testing.T) {
+	p, _, log := newExecDNSTest(t, "fail")
+	e := p.Present(execTestRecord)
+	var execErr *ExecDNSError
+	if !errors.As(e, &execErr) {
+		t.Fatalf("expected an ExecDNSError, got %v", e)
+	}
+	if execErr.ExitCode != 3 || execErr.TimedOut || execErr.Action != "present" || execErr.FQDN != "www.auth.test." {
+		t.Fatalf("error %+v", execErr)
+	}
+	if strings.TrimSpace(execErr.Stderr) != "zone not found" || !strings.HasSuffix(e.Error(), ": zone not found") {
+		t.Fatalf("stderr %q in %q", execErr.Stderr, e)
+	}
+	if !strings.Contains(log.String(), "looking up the zone") {
+		t.Fatalf("stdout not logged: %s", log)
+	}
+}
+
+func TestExecDNSProviderTimeout(t *testing.T) {
+	p, _, _ := newExecDNSTest(t, "hang")
+	p.Timeout = 200 * time.Millisecond
+	start := time.Now()
+	e := p.Present(execTestRecord)
+	elapsed := time.Since(start)
+	var execErr *ExecDNSError
+	if !errors.As(e, &execErr) || !execErr.TimedOut || execErr.ExitCode != -1 {
+		t.Fatalf("expected a timeout, got %v", e)
+	}
+	if !strings.Contains(e.Error(), "timed out") {
+		t.Fatalf("error %q", e)
+	}
+	// the kill plus WaitDelay, not the 30s of the child holding stdout
+	if elapsed > 5*time.Second {
+		t.Fatalf("returned after %s", elapsed)
+	}
+}
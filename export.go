@@ -0,0 +1,199 @@
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tonyzzp/acme/utils"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const ExportPEM = "pem"
+const ExportSplit = "split"
+const ExportCombined = "combined"
+const ExportDER = "der"
+const ExportPKCS12 = "pkcs12"
+
+var ExportFormats = []string{ExportPEM, ExportSplit, ExportCombined, ExportDER, ExportPKCS12}
+
+// ExportOptions controls which files ExportCert renders from a stored bundle:
+//
+//	pem      privkey.pem, fullchain.pem
+//	split    cert.pem (leaf), chain.pem (intermediates)
+//	combined combined.pem (key followed by the full chain, for HAProxy)
+//	der      cert.der, privkey.der
+//	pkcs12   cert.p12, protected by Password
+//
+// With EncryptKey the key files are encrypted PKCS#8 protected by Password.
+type ExportOptions struct {
+	Formats      []string
+	Dir          string
+	PKCS8        bool
+	EncryptKey   bool
+	Password     string
+	PKCS12Legacy bool
+}
+
+func marshalKey(key crypto.Signer, options *ExportOptions) (*pem.Block, error) {
+	if options.EncryptKey {
+		if options.Password == "" {
+			return nil, errors.New("a password is needed to encrypt the key")
+		}
+		bs, e := x509.MarshalPKCS8PrivateKey(key)
+		if e != nil {
+			return nil, e
+		}
+		bs, e = encryptPKCS8(bs, []byte(options.Password))
+		if e != nil {
+			return nil, e
+		}
+		return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: bs}, nil
+	}
+	if options.PKCS8 {
+		bs, e := x509.MarshalPKCS8PrivateKey(key)
+		if e != nil {
+			return nil, e
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: bs}, nil
+	}
+	bs, e := encodeKeyPEM(key)
+	if e != nil {
+		return nil, e
+	}
+	block, _ := pem.Decode(bs)
+	return block, nil
+}
+
+func encodeCertsPEM(certs []*x509.Certificate) []byte {
+	buf := &bytes.Buffer{}
+	for _, c := range certs {
+		pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return buf.Bytes()
+}
+
+// ExportCert is ExportCert refusing to write plaintext keys into an
+// encrypted store.
+func (client *Client) ExportCert(cert *Cert, options *ExportOptions) ([]string, error) {
+	if client.KeyStore != nil {
+		dir := options.Dir
+		if dir == "" {
+			dir = cert.Path
+		}
+		if inDir(client.storeRoot, dir) {
+			return nil, fmt.Errorf("the store is encrypted, export to a directory outside of %s", client.storeRoot)
+		}
+	}
+	return ExportCert(cert, options)
+}
+
+// inDir reports whether file is dir or below it.
+func inDir(dir string, file string) bool {
+	dir, e1 := filepath.Abs(dir)
+	file, e2 := filepath.Abs(file)
+	if e1 != nil || e2 != nil {
+		return false
+	}
+	rel, e := filepath.Rel(dir, file)
+	return e == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ExportCert writes the requested formats of cert and returns the files it
+// wrote. Files containing the private key are created with mode 0600.
+func ExportCert(cert *Cert, options *ExportOptions) ([]string, error) {
+	if len(cert.Certs) == 0 {
+		return nil, errors.New("no certificate in bundle")
+	}
+	if cert.PrivateKey == nil {
+		return nil, errors.New("no private key in bundle")
+	}
+	dir := options.Dir
+	if dir == "" {
+		dir = cert.Path
+	}
+	if filepath.Clean(dir) == filepath.Clean(cert.Path) && utils.Contains(options.Formats, ExportPEM) {
+		return nil, errors.New("pem is the format of the store itself, export it to another directory")
+	}
+	e := os.MkdirAll(dir, 0755)
+	if e != nil {
+		return nil, e
+	}
+	keyBlock, e := marshalKey(cert.PrivateKey, options)
+	if e != nil {
+		return nil, e
+	}
+	leaf := cert.Certs[0]
+	chain := cert.Certs[1:]
+
+	rtn := make([]string, 0)
+	// a temp file created 0600 and renamed over the target, so an existing
+	// file gets mode too and keys are never readable in between
+	write := func(name string, data []byte, mode os.FileMode) error {
+		file := filepath.Join(dir, name)
+		f, e := os.CreateTemp(dir, "."+name+".*")
+		if e != nil {
+			return e
+		}
+		_, e = f.Write(data)
+		if e == nil {
+			e = f.Chmod(mode)
+		}
+		if e1 := f.Close(); e == nil {
+			e = e1
+		}
+		if e == nil {
+			e = os.Rename(f.Name(), file)
+		}
+		if e != nil {
+			os.Remove(f.Name())
+			return e
+		}
+		rtn = append(rtn, file)
+		return nil
+	}
+	for _, format := range options.Formats {
+		switch format {
+		case ExportPEM:
+			e = write("privkey.pem", pem.EncodeToMemory(keyBlock), 0600)
+			if e == nil {
+				e = write("fullchain.pem", encodeCertsPEM(cert.Certs), 0644)
+			}
+		case ExportSplit:
+			e = write("cert.pem", encodeCertsPEM(cert.Certs[:1]), 0644)
+			if e == nil {
+				e = write("chain.pem", encodeCertsPEM(chain), 0644)
+			}
+		case ExportCombined:
+			data := append(pem.EncodeToMemory(keyBlock), encodeCertsPEM(cert.Certs)...)
+			e = write("combined.pem", data, 0600)
+		case ExportDER:
+			e = write("cert.der", leaf.Raw, 0644)
+			if e == nil {
+				e = write("privkey.der", keyBlock.Bytes, 0600)
+			}
+		case ExportPKCS12:
+			encoder := pkcs12.Modern
+			if options.PKCS12Legacy {
+				encoder = pkcs12.LegacyRC2
+			}
+			var data []byte
+			data, e = encoder.Encode(cert.PrivateKey, leaf, chain, options.Password)
+			if e == nil {
+				e = write("cert.p12", data, 0600)
+			}
+		default:
+			e = fmt.Errorf("unknown export format %q", format)
+		}
+		if e != nil {
+			return rtn, fmt.Errorf("export %s: %w", format, e)
+		}
+	}
+	return rtn, nil
+}
@@ -0,0 +1,40 @@
+package acme
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportModeOfExistingFiles(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	cert := obtainTestCert(t, client, "example.test")
+	dir := t.TempDir()
+	key := filepath.Join(dir, "privkey.pem")
+	e := os.WriteFile(key, []byte("old"), 0644)
+	if e != nil {
+		t.Fatal(e)
+	}
+	files, e := ExportCert(cert, &ExportOptions{Formats: []string{ExportPEM}, Dir: dir})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(files) != 2 {
+		t.Fatalf("exported %v", files)
+	}
+	info, e := os.Stat(key)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("existing private key kept mode %o", info.Mode().Perm())
+	}
+	bs, _ := os.ReadFile(key)
+	if string(bs) == "old" {
+		t.Fatal("private key not replaced")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("temp files left: %d entries", len(entries))
+	}
+}
@@ -2,15 +2,24 @@ module github.com/tonyzzp/acme
 
 go 1.22.4
 
-require github.com/go-resty/resty/v2 v2.13.1
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/go-resty/resty/v2 v2.13.1
+	github.com/manifoldco/promptui v0.9.0
+	github.com/miekg/dns v1.1.59
+	github.com/miekg/pkcs11 v1.1.2
+	github.com/urfave/cli/v2 v2.27.3
+	golang.org/x/crypto v0.23.0
+	software.sslmate.com/src/go-pkcs12 v0.7.3
+)
 
 require (
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.4 // indirect
-	github.com/manifoldco/promptui v0.9.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	github.com/urfave/cli/v2 v2.27.3 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
+	golang.org/x/mod v0.16.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/tools v0.19.0 // indirect
 )
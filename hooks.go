@@ -0,0 +1,313 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DeployEvent describes a certificate that has just been stored.
+type DeployEvent struct {
+	Name     string
+	Dir      string
+	CertPath string
+	// KeyPath is a decrypted copy of the key when the store is encrypted,
+	// readable only by the owner and removed once the hooks have run.
+	// Hooks have to copy it, e.g. with a CopyHook.
+	KeyPath       string
+	FullChainPath string
+	Domains       []string
+	Renewal       bool
+	Cert          *Cert
+	// Logger is the logger of the client running the hooks.
+	Logger *slog.Logger
+}
+
+func (event *DeployEvent) logger() *slog.Logger {
+	if event.Logger != nil {
+		return event.Logger
+	}
+	return slog.Default()
+}
+
+type DeployHook interface {
+	Deploy(event *DeployEvent) error
+}
+
+// DeployError reports failed exports and hooks. The certificate it belongs
+// to has been stored successfully.
+type DeployError struct {
+	Dir    string
+	Errors []error
+}
+
+func (e *DeployError) Error() string {
+	msgs := make([]string, 0)
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("certificate saved to %s, but deploy failed: %s", e.Dir, strings.Join(msgs, "; "))
+}
+
+func (e *DeployError) Unwrap() []error {
+	return e.Errors
+}
+
+func (event *DeployEvent) Env() []string {
+	renewal := "0"
+	if event.Renewal {
+		renewal = "1"
+	}
+	return []string{
+		"ACME_CERT_NAME=" + event.Name,
+		"ACME_CERT_DIR=" + event.Dir,
+		"ACME_CERT_PATH=" + event.CertPath,
+		"ACME_KEY_PATH=" + event.KeyPath,
+		"ACME_FULLCHAIN_PATH=" + event.FullChainPath,
+		"ACME_DOMAINS=" + strings.Join(event.Domains, " "),
+		"ACME_RENEWAL=" + renewal,
+	}
+}
+
+// CommandHook runs a shell command with the event exported as ACME_*
+// environment variables.
+type CommandHook struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (hook *CommandHook) Deploy(event *DeployEvent) error {
+	ctx := context.Background()
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", hook.Command)
+	}
+	cmd.Env = append(os.Environ(), event.Env()...)
+	out, e := cmd.CombinedOutput()
+	if len(out) > 0 {
+		event.logger().Info("deploy hook output", "command", hook.Command, "output", string(out))
+	}
+	if e != nil {
+		return fmt.Errorf("deploy hook %q: %w", hook.Command, e)
+	}
+	return nil
+}
+
+// CopyHook copies privkey.pem and fullchain.pem into Dest. The key is
+// written decrypted when the store is encrypted. Owner may be "user",
+// "user:group" or numeric ids.
+type CopyHook struct {
+	Dest    string
+	Owner   string
+	Mode    os.FileMode
+	KeyMode os.FileMode
+}
+
+func lookupOwner(owner string) (int, int, error) {
+	name, group, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+	if name != "" {
+		id, e := strconv.Atoi(name)
+		if e != nil {
+			u, e := user.Lookup(name)
+			if e != nil {
+				return 0, 0, e
+			}
+			id, _ = strconv.Atoi(u.Uid)
+			if group == "" {
+				gid, _ = strconv.Atoi(u.Gid)
+			}
+		}
+		uid = id
+	}
+	if group != "" {
+		id, e := strconv.Atoi(group)
+		if e != nil {
+			g, e := user.LookupGroup(group)
+			if e != nil {
+				return 0, 0, e
+			}
+			id, _ = strconv.Atoi(g.Gid)
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
+
+func (hook *CopyHook) writeFile(bs []byte, dst string, mode os.FileMode, uid int, gid int) error {
+	tmp := dst + ".tmp"
+	e := os.WriteFile(tmp, bs, mode)
+	if e != nil {
+		return e
+	}
+	e = os.Chmod(tmp, mode)
+	if e == nil && (uid != -1 || gid != -1) {
+		e = os.Chown(tmp, uid, gid)
+	}
+	if e == nil {
+		e = os.Rename(tmp, dst)
+	}
+	if e != nil {
+		os.Remove(tmp)
+	}
+	return e
+}
+
+func (hook *CopyHook) Deploy(event *DeployEvent) error {
+	mode := hook.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	keyMode := hook.KeyMode
+	if keyMode == 0 {
+		keyMode = 0600
+	}
+	uid, gid := -1, -1
+	if hook.Owner != "" {
+		var e error
+		uid, gid, e = lookupOwner(hook.Owner)
+		if e != nil {
+			return fmt.Errorf("copy hook owner %q: %w", hook.Owner, e)
+		}
+	}
+	e := os.MkdirAll(hook.Dest, 0755)
+	if e != nil {
+		return e
+	}
+	cert := event.Cert
+	if cert == nil {
+		return errors.New("copy hook: certificate not loaded")
+	}
+	e = hook.writeFile([]byte(cert.FullChainPEM), filepath.Join(hook.Dest, "fullchain.pem"), mode, uid, gid)
+	if e != nil {
+		return fmt.Errorf("copy hook: %w", e)
+	}
+	e = hook.writeFile([]byte(cert.PrivateKeyPEM), filepath.Join(hook.Dest, "privkey.pem"), keyMode, uid, gid)
+	if e != nil {
+		return fmt.Errorf("copy hook: %w", e)
+	}
+	return nil
+}
+
+// SignalHook sends Signal to the process whose pid is stored in PidFile,
+// e.g. SIGHUP to reload nginx.
+type SignalHook struct {
+	PidFile string
+	Signal  syscall.Signal
+}
+
+func (hook *SignalHook) Deploy(event *DeployEvent) error {
+	bs, e := os.ReadFile(hook.PidFile)
+	if e != nil {
+		return fmt.Errorf("signal hook: %w", e)
+	}
+	pid, e := strconv.Atoi(strings.TrimSpace(string(bs)))
+	if e != nil {
+		return fmt.Errorf("signal hook: bad pid file %s: %w", hook.PidFile, e)
+	}
+	p, e := os.FindProcess(pid)
+	if e != nil {
+		return fmt.Errorf("signal hook: %w", e)
+	}
+	e = p.Signal(hook.Signal)
+	if e != nil {
+		return fmt.Errorf("signal hook: send %s to %d: %w", hook.Signal, pid, e)
+	}
+	return nil
+}
+
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := signals[name]; ok {
+		return sig, nil
+	}
+	n, e := strconv.Atoi(name)
+	if e != nil {
+		return 0, errors.New("unknown signal " + name)
+	}
+	return syscall.Signal(n), nil
+}
+
+// plainKeyFile returns file when it is a plaintext key, or else a new
+// 0600 temp file holding the decrypted key of cert.
+func plainKeyFile(file string, cert *Cert) (string, error) {
+	bs, e := os.ReadFile(file)
+	if e != nil {
+		return "", e
+	}
+	if !isSealed(bs) {
+		return file, nil
+	}
+	f, e := os.CreateTemp("", "acme-*-privkey.pem")
+	if e != nil {
+		return "", e
+	}
+	_, e = f.WriteString(cert.PrivateKeyPEM)
+	if e1 := f.Close(); e == nil {
+		e = e1
+	}
+	if e != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("write decrypted key: %w", e)
+	}
+	return f.Name(), nil
+}
+
+func (client *Client) runDeployHooks(event *DeployEvent) error {
+	var errs []error
+	cert, e := LoadCert(event.Dir, client.KeyStore)
+	if e != nil {
+		return &DeployError{Dir: event.Dir, Errors: []error{e}}
+	}
+	cert.Name = event.Name
+	event.Cert = cert
+	if event.Logger == nil {
+		event.Logger = client.logger()
+	}
+	if client.AutoExport != nil {
+		_, e = client.ExportCert(cert, client.AutoExport)
+		if e != nil {
+			client.logger().Error("export failed", "name", event.Name, "error", e)
+			errs = append(errs, e)
+		}
+	}
+	if len(client.DeployHooks) > 0 {
+		file, e := plainKeyFile(event.KeyPath, cert)
+		if e != nil {
+			errs = append(errs, e)
+			return &DeployError{Dir: event.Dir, Errors: errs}
+		}
+		if file != event.KeyPath {
+			defer os.Remove(file)
+			event.KeyPath = file
+		}
+	}
+	for _, hook := range client.DeployHooks {
+		e := hook.Deploy(event)
+		if e != nil {
+			client.logger().Error("deploy hook failed", "name", event.Name, "error", e)
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return &DeployError{Dir: event.Dir, Errors: errs}
+	}
+	return nil
+}
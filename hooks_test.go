@@ -0,0 +1,23 @@
+package acme
+
+import (
+	"bytes"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCommandHookLogsToClientLogger(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	client, _ := newTestClient(t, nil)
+	var buf bytes.Buffer
+	client.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	client.DeployHooks = []DeployHook{&CommandHook{Command: `echo "deployed $ACME_CERT_NAME"`}}
+	obtainTestCert(t, client, "example.test")
+	if !strings.Contains(buf.String(), "deploy hook output") || !strings.Contains(buf.String(), "deployed example.test") {
+		t.Fatalf("hook output not in the client log:\n%s", buf.String())
+	}
+}
@@ -1,78 +1,186 @@
 package acme
 
 import (
+	"bytes"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"math/big"
 	"os"
 )
 
 type JWK struct {
 	Alg        string `json:"alg,omitempty"`
-	Crv        string `json:"crv"`
+	Crv        string `json:"crv,omitempty"`
 	D          string `json:"d,omitempty"`
+	E          string `json:"e,omitempty"`
 	Kty        string `json:"kty"`
-	X          string `json:"x"`
-	Y          string `json:"y"`
+	N          string `json:"n,omitempty"`
+	X          string `json:"x,omitempty"`
+	Y          string `json:"y,omitempty"`
 	privateKey *ecdsa.PrivateKey
 }
 
-func (jwk *JWK) PrivateKey() *ecdsa.PrivateKey {
+func (jwk *JWK) PrivateKey() (*ecdsa.PrivateKey, error) {
 	if jwk.privateKey != nil {
-		return jwk.privateKey
+		return jwk.privateKey, nil
 	}
-	pk := &ecdsa.PrivateKey{
+	e := jwk.Validate()
+	if e != nil {
+		return nil, e
+	}
+	if jwk.privateKey == nil {
+		return nil, errors.New("jwk has no private key")
+	}
+	return jwk.privateKey, nil
+}
+
+func jwkCurve(crv string) (elliptic.Curve, ecdh.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), ecdh.P256(), nil
+	case "P-384":
+		return elliptic.P384(), ecdh.P384(), nil
+	case "P-521":
+		return elliptic.P521(), ecdh.P521(), nil
+	}
+	return nil, nil, fmt.Errorf("unsupported curve %q", crv)
+}
+
+func decodeOctets(name string, value string, size int) ([]byte, error) {
+	bs, e := base64.RawURLEncoding.Strict().DecodeString(value)
+	if e != nil {
+		return nil, fmt.Errorf("jwk %s: %w", name, e)
+	}
+	if len(bs) != size {
+		return nil, fmt.Errorf("jwk %s: %d bytes, want %d", name, len(bs), size)
+	}
+	return bs, nil
+}
+
+// Validate checks an EC JWK against RFC 7518 section 6.2: coordinates and
+// d are fixed size octets, the point is on the curve and d belongs to it.
+func (jwk *JWK) Validate() error {
+	if jwk.Kty != "EC" {
+		return fmt.Errorf("unsupported jwk kty %q", jwk.Kty)
+	}
+	curve, ecdhCurve, e := jwkCurve(jwk.Crv)
+	if e != nil {
+		return e
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	x, e := decodeOctets("x", jwk.X, size)
+	if e != nil {
+		return e
+	}
+	y, e := decodeOctets("y", jwk.Y, size)
+	if e != nil {
+		return e
+	}
+	point := append(append([]byte{4}, x...), y...)
+	_, e = ecdhCurve.NewPublicKey(point)
+	if e != nil {
+		return fmt.Errorf("jwk point is not on %s", jwk.Crv)
+	}
+	if jwk.D == "" {
+		return nil
+	}
+	d, e := decodeOctets("d", jwk.D, size)
+	if e != nil {
+		return e
+	}
+	key, e := ecdhCurve.NewPrivateKey(d)
+	if e != nil {
+		return fmt.Errorf("jwk d: %w", e)
+	}
+	if !bytes.Equal(key.PublicKey().Bytes(), point) {
+		return errors.New("jwk d does not match x and y")
+	}
+	jwk.privateKey = &ecdsa.PrivateKey{
 		PublicKey: ecdsa.PublicKey{
-			Curve: elliptic.P256(),
-			X:     base64ToBigInt(jwk.X),
-			Y:     base64ToBigInt(jwk.Y),
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
 		},
-		D: base64ToBigInt(jwk.D),
+		D: new(big.Int).SetBytes(d),
 	}
-	jwk.privateKey = pk
-	return pk
+	return nil
+}
+
+// Thumbprint returns the base64url encoded RFC 7638 SHA-256 thumbprint,
+// the second half of every key authorization.
+func (jwk *JWK) Thumbprint() string {
+	sum := sha256.Sum256([]byte(jwk.Encode()))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
 }
 
-func (jwk *JWK) sign(data string) (string, error) {
-	pk := jwk.PrivateKey()
-	sum := sha256.Sum256([]byte(data))
-	r, s, e := ecdsa.Sign(rand.Reader, pk, sum[:])
+// parseJWK reads a stored key. Keys written before coordinates were padded
+// may be a few bytes short, they are padded here before validating.
+func parseJWK(bs []byte) (*JWK, error) {
+	jwk := &JWK{}
+	e := json.Unmarshal(bs, jwk)
 	if e != nil {
-		log.Println("sign")
-		log.Println(e)
-		return "", e
+		return nil, e
+	}
+	if curve, _, e := jwkCurve(jwk.Crv); e == nil {
+		size := (curve.Params().BitSize + 7) / 8
+		jwk.X = padOctets(jwk.X, size)
+		jwk.Y = padOctets(jwk.Y, size)
+		if jwk.D != "" {
+			jwk.D = padOctets(jwk.D, size)
+		}
 	}
-	bs := append(r.Bytes(), s.Bytes()...)
-	return base64.RawURLEncoding.EncodeToString(bs), nil
+	e = jwk.Validate()
+	if e != nil {
+		return nil, e
+	}
+	return jwk, nil
+}
+
+func padOctets(value string, size int) string {
+	bs, e := base64.RawURLEncoding.DecodeString(value)
+	if e != nil || len(bs) >= size {
+		return value
+	}
+	return base64.RawURLEncoding.EncodeToString(new(big.Int).SetBytes(bs).FillBytes(make([]byte, size)))
 }
 
 func (jwk *JWK) Encode() string {
+	if jwk.Kty == "RSA" {
+		return fmt.Sprintf(`{"e":"%s","kty":"%s","n":"%s"}`, jwk.E, jwk.Kty, jwk.N)
+	}
 	return fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, jwk.Crv, jwk.Kty, jwk.X, jwk.Y)
 }
 
-func NewECDSA() *JWK {
+func NewECDSA() (*JWK, error) {
 	pk, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if e != nil {
-		panic(e)
+		return nil, fmt.Errorf("generate account key: %w", e)
 	}
+	return jwkFromECDSA(pk), nil
+}
 
-	x := pk.PublicKey.X.Bytes()
-	y := pk.PublicKey.Y.Bytes()
-	d := pk.D.Bytes()
+func jwkFromECDSA(pk *ecdsa.PrivateKey) *JWK {
+	size := (pk.Curve.Params().BitSize + 7) / 8
+	x := pk.PublicKey.X.FillBytes(make([]byte, size))
+	y := pk.PublicKey.Y.FillBytes(make([]byte, size))
+	d := pk.D.FillBytes(make([]byte, size))
 
 	jwk := &JWK{
 		Kty: "EC",
 		D:   base64.RawURLEncoding.EncodeToString(d),
-		Crv: "P-256",
+		Crv: pk.Curve.Params().Name,
 		X:   base64.RawURLEncoding.EncodeToString(x),
 		Y:   base64.RawURLEncoding.EncodeToString(y),
-		Alg: "ES256",
 	}
+	jwk.Alg, _, _ = jwsAlg(&pk.PublicKey)
+	jwk.privateKey = pk
 	return jwk
 }
 
@@ -81,10 +189,5 @@ func ReadJWKFromFile(file string) (*JWK, error) {
 	if e != nil {
 		return nil, e
 	}
-	rtn := &JWK{}
-	e = json.Unmarshal(bs, rtn)
-	if e != nil {
-		return nil, e
-	}
-	return rtn, e
+	return parseJWK(bs)
 }
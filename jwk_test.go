@@ -0,0 +1,150 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+// keyWithLeadingZero generates keys until x, y or d starts with a zero
+// byte, the case big.Int.Bytes() used to get wrong.
+func keyWithLeadingZero(t *testing.T, curve elliptic.Curve) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	for i := 0; i < 10000; i++ {
+		pk, e := ecdsa.GenerateKey(curve, rand.Reader)
+		if e != nil {
+			t.Fatal(e)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		switch size {
+		case len(pk.X.Bytes()) + 1:
+			return pk, "x"
+		case len(pk.Y.Bytes()) + 1:
+			return pk, "y"
+		case len(pk.D.Bytes()) + 1:
+			return pk, "d"
+		}
+	}
+	t.Fatal("no key with a leading zero byte")
+	return nil, ""
+}
+
+func TestJWKFixedWidth(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		size := (curve.Params().BitSize + 7) / 8
+		pk, short := keyWithLeadingZero(t, curve)
+		jwk := jwkFromECDSA(pk)
+		for name, value := range map[string]string{"x": jwk.X, "y": jwk.Y, "d": jwk.D} {
+			bs, _ := base64.RawURLEncoding.DecodeString(value)
+			if len(bs) != size {
+				t.Errorf("%s: %s is %d bytes, want %d", curve.Params().Name, name, len(bs), size)
+			}
+		}
+		bs, _ := json.Marshal(jwk)
+		parsed, e := parseJWK(bs)
+		if e != nil {
+			t.Fatalf("%s with a short %s: %v", curve.Params().Name, short, e)
+		}
+		key, e := parsed.PrivateKey()
+		if e != nil || !key.Equal(pk) {
+			t.Fatalf("%s: key changed by the round trip: %v", curve.Params().Name, e)
+		}
+	}
+}
+
+func TestParseJWKPadsLegacyKeys(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		pk, short := keyWithLeadingZero(t, curve)
+		padded := jwkFromECDSA(pk)
+		// written with big.Int.Bytes() by older versions
+		legacy := &JWK{
+			Kty: "EC",
+			Crv: padded.Crv,
+			X:   base64.RawURLEncoding.EncodeToString(pk.X.Bytes()),
+			Y:   base64.RawURLEncoding.EncodeToString(pk.Y.Bytes()),
+			D:   base64.RawURLEncoding.EncodeToString(pk.D.Bytes()),
+		}
+		if e := legacy.Validate(); e == nil {
+			t.Fatalf("%s: short %s validated", curve.Params().Name, short)
+		}
+		bs, _ := json.Marshal(legacy)
+		parsed, e := parseJWK(bs)
+		if e != nil {
+			t.Fatalf("%s: legacy key with a short %s: %v", curve.Params().Name, short, e)
+		}
+		if parsed.X != padded.X || parsed.Y != padded.Y || parsed.D != padded.D {
+			t.Fatalf("%s: not padded", curve.Params().Name)
+		}
+		if parsed.Thumbprint() != padded.Thumbprint() {
+			t.Fatalf("%s: thumbprint of the padded key differs", curve.Params().Name)
+		}
+	}
+}
+
+func TestJWKValidate(t *testing.T) {
+	var jwk *JWK
+	var x []byte
+	// a short x padded on load must not give x back
+	for x == nil || x[0] == 0 {
+		var e error
+		jwk, e = NewECDSA()
+		if e != nil {
+			t.Fatal(e)
+		}
+		x, _ = base64.RawURLEncoding.DecodeString(jwk.X)
+	}
+	other, _ := NewECDSA()
+	if e := jwk.Validate(); e != nil {
+		t.Fatal(e)
+	}
+	public := *jwk
+	public.D = ""
+	public.privateKey = nil
+	if e := public.Validate(); e != nil {
+		t.Fatalf("public key: %v", e)
+	}
+	if _, e := public.PrivateKey(); e == nil {
+		t.Fatal("private key of a public jwk")
+	}
+
+	tests := map[string]func(k *JWK){
+		"kty":          func(k *JWK) { k.Kty = "RSA" },
+		"curve":        func(k *JWK) { k.Crv = "P-192" },
+		"short x":      func(k *JWK) { k.X = base64.RawURLEncoding.EncodeToString(x[1:]) },
+		"long x":       func(k *JWK) { k.X = base64.RawURLEncoding.EncodeToString(append([]byte{0}, x...)) },
+		"padded x":     func(k *JWK) { k.X += "=" },
+		"bad y":        func(k *JWK) { k.Y = "!" + k.Y[1:] },
+		"off curve":    func(k *JWK) { k.Y = other.Y },
+		"other d":      func(k *JWK) { k.D = other.D },
+		"short d":      func(k *JWK) { k.D = k.D[:len(k.D)-4] },
+		"missing x":    func(k *JWK) { k.X = "" },
+		"crv of other": func(k *JWK) { k.Crv = "P-384" },
+	}
+	for name, change := range tests {
+		k := &JWK{Kty: jwk.Kty, Crv: jwk.Crv, X: jwk.X, Y: jwk.Y, D: jwk.D}
+		change(k)
+		if e := k.Validate(); e == nil {
+			t.Errorf("%s: validated", name)
+		}
+		bs, _ := json.Marshal(k)
+		if _, e := parseJWK(bs); e == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestThumbprintRFC7638(t *testing.T) {
+	// RFC 7638 section 3.1
+	jwk := &JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+	}
+	if tp := jwk.Thumbprint(); tp != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("thumbprint %s", tp)
+	}
+}
@@ -0,0 +1,89 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jwsAlg returns the JWS algorithm for a public key: ES256/ES384/ES512 by
+// curve for ECDSA and RS256 for RSA.
+func jwsAlg(pub crypto.PublicKey) (string, crypto.Hash, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return "ES256", crypto.SHA256, nil
+		case elliptic.P384():
+			return "ES384", crypto.SHA384, nil
+		case elliptic.P521():
+			return "ES512", crypto.SHA512, nil
+		}
+		return "", 0, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+	case *rsa.PublicKey:
+		return "RS256", crypto.SHA256, nil
+	}
+	return "", 0, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// signJWS signs data with signer and returns the base64url encoded JWS
+// signature. ECDSA signers return ASN.1, JWS wants r||s of the curve size.
+func signJWS(signer crypto.Signer, data string) (string, error) {
+	_, hash, e := jwsAlg(signer.Public())
+	if e != nil {
+		return "", e
+	}
+	h := hash.New()
+	h.Write([]byte(data))
+	sig, e := signer.Sign(rand.Reader, h.Sum(nil), hash)
+	if e != nil {
+		return "", e
+	}
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		var v struct{ R, S *big.Int }
+		_, e = asn1.Unmarshal(sig, &v)
+		if e != nil {
+			return "", fmt.Errorf("bad ecdsa signature: %w", e)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		bs := make([]byte, 2*size)
+		v.R.FillBytes(bs[:size])
+		v.S.FillBytes(bs[size:])
+		sig = bs
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// publicJWK returns the public JWK of an account key held by a signer.
+func publicJWK(pub crypto.PublicKey) (*JWK, error) {
+	alg, _, e := jwsAlg(pub)
+	if e != nil {
+		return nil, e
+	}
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return &JWK{
+			Alg: alg,
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case *rsa.PublicKey:
+		return &JWK{
+			Alg: alg,
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	}
+	return nil, errors.New("unsupported public key")
+}
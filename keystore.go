@@ -0,0 +1,332 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const sealedFormat = "acme-sealed-v1"
+
+var ErrKeyEncrypted = errors.New("key file is encrypted, no passphrase or key file configured")
+
+// KeyStore encrypts private key material before it is written to the store.
+// Client.KeyStore is nil for a plaintext store.
+type KeyStore interface {
+	Seal(plaintext []byte) ([]byte, error)
+	Open(sealed []byte) ([]byte, error)
+}
+
+type sealedFile struct {
+	Format string `json:"encrypted"`
+	Kdf    string `json:"kdf"`
+	N      int    `json:"n,omitempty"`
+	R      int    `json:"r,omitempty"`
+	P      int    `json:"p,omitempty"`
+	Salt   string `json:"salt,omitempty"`
+	Nonce  string `json:"nonce"`
+	Data   string `json:"data"`
+}
+
+func isSealed(bs []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(bs), []byte("{")) {
+		return false
+	}
+	v := &sealedFile{}
+	return json.Unmarshal(bs, v) == nil && v.Format == sealedFormat
+}
+
+func gcmSeal(key []byte, plaintext []byte) (nonce []byte, data []byte, e error) {
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, nil, e
+	}
+	aead, e := cipher.NewGCM(block)
+	if e != nil {
+		return nil, nil, e
+	}
+	nonce = make([]byte, aead.NonceSize())
+	_, e = rand.Read(nonce)
+	if e != nil {
+		return nil, nil, e
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, []byte(sealedFormat)), nil
+}
+
+func gcmOpen(key []byte, v *sealedFile) ([]byte, error) {
+	nonce, e := base64.RawURLEncoding.DecodeString(v.Nonce)
+	if e != nil {
+		return nil, e
+	}
+	data, e := base64.RawURLEncoding.DecodeString(v.Data)
+	if e != nil {
+		return nil, e
+	}
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	aead, e := cipher.NewGCM(block)
+	if e != nil {
+		return nil, e
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("bad nonce size")
+	}
+	rtn, e := aead.Open(nil, nonce, data, []byte(sealedFormat))
+	if e != nil {
+		return nil, errors.New("decrypt key file failed, wrong passphrase or key file?")
+	}
+	return rtn, nil
+}
+
+func parseSealed(sealed []byte, kdf string) (*sealedFile, error) {
+	v := &sealedFile{}
+	e := json.Unmarshal(sealed, v)
+	if e != nil {
+		return nil, e
+	}
+	if v.Format != sealedFormat {
+		return nil, errors.New("not an encrypted key file")
+	}
+	if v.Kdf != kdf {
+		return nil, fmt.Errorf("key file is encrypted with %s, not %s", v.Kdf, kdf)
+	}
+	return v, nil
+}
+
+// PassphraseKeyStore derives a fresh AES-256-GCM key with scrypt for every
+// file it seals.
+type PassphraseKeyStore struct {
+	Passphrase []byte
+}
+
+func (store *PassphraseKeyStore) Seal(plaintext []byte) ([]byte, error) {
+	v := &sealedFile{Format: sealedFormat, Kdf: "scrypt", N: 1 << 15, R: 8, P: 1}
+	salt := make([]byte, 16)
+	_, e := rand.Read(salt)
+	if e != nil {
+		return nil, e
+	}
+	key, e := scrypt.Key(store.Passphrase, salt, v.N, v.R, v.P, 32)
+	if e != nil {
+		return nil, e
+	}
+	nonce, data, e := gcmSeal(key, plaintext)
+	if e != nil {
+		return nil, e
+	}
+	v.Salt = base64.RawURLEncoding.EncodeToString(salt)
+	v.Nonce = base64.RawURLEncoding.EncodeToString(nonce)
+	v.Data = base64.RawURLEncoding.EncodeToString(data)
+	return json.MarshalIndent(v, "", "    ")
+}
+
+// maxScryptMemory bounds the 128*N*r bytes scrypt allocates for the
+// parameters of a sealed file, Seal uses 32 MiB.
+const maxScryptMemory = 256 << 20
+
+func (store *PassphraseKeyStore) Open(sealed []byte) ([]byte, error) {
+	v, e := parseSealed(sealed, "scrypt")
+	if e != nil {
+		return nil, e
+	}
+	if v.N < 2 || v.N&(v.N-1) != 0 || v.R < 1 || v.R > 64 || v.P < 1 || v.P > 16 || 128*int64(v.N)*int64(v.R) > maxScryptMemory {
+		return nil, fmt.Errorf("unsupported scrypt parameters n=%d r=%d p=%d", v.N, v.R, v.P)
+	}
+	salt, e := base64.RawURLEncoding.DecodeString(v.Salt)
+	if e != nil {
+		return nil, e
+	}
+	key, e := scrypt.Key(store.Passphrase, salt, v.N, v.R, v.P, 32)
+	if e != nil {
+		return nil, e
+	}
+	return gcmOpen(key, v)
+}
+
+// KeyFileKeyStore uses a 32 byte key read from a file, stored raw, hex or
+// base64 encoded.
+type KeyFileKeyStore struct {
+	key []byte
+}
+
+func NewKeyFileKeyStore(file string) (*KeyFileKeyStore, error) {
+	bs, e := os.ReadFile(file)
+	if e != nil {
+		return nil, e
+	}
+	if len(bs) == 32 {
+		return &KeyFileKeyStore{key: bs}, nil
+	}
+	s := strings.TrimSpace(string(bs))
+	if key, e := hex.DecodeString(s); e == nil && len(key) == 32 {
+		return &KeyFileKeyStore{key: key}, nil
+	}
+	if key, e := base64.StdEncoding.DecodeString(s); e == nil && len(key) == 32 {
+		return &KeyFileKeyStore{key: key}, nil
+	}
+	return nil, fmt.Errorf("%s does not contain a 32 byte key", file)
+}
+
+// GenerateKeyFile writes a new random hex encoded key file.
+func GenerateKeyFile(file string) error {
+	key := make([]byte, 32)
+	_, e := rand.Read(key)
+	if e != nil {
+		return e
+	}
+	return os.WriteFile(file, []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
+
+func (store *KeyFileKeyStore) Seal(plaintext []byte) ([]byte, error) {
+	nonce, data, e := gcmSeal(store.key, plaintext)
+	if e != nil {
+		return nil, e
+	}
+	v := &sealedFile{
+		Format: sealedFormat,
+		Kdf:    "keyfile",
+		Nonce:  base64.RawURLEncoding.EncodeToString(nonce),
+		Data:   base64.RawURLEncoding.EncodeToString(data),
+	}
+	return json.MarshalIndent(v, "", "    ")
+}
+
+func (store *KeyFileKeyStore) Open(sealed []byte) ([]byte, error) {
+	v, e := parseSealed(sealed, "keyfile")
+	if e != nil {
+		return nil, e
+	}
+	return gcmOpen(store.key, v)
+}
+
+// readSecret reads a key file and decrypts it when it is sealed.
+func readSecret(file string, store KeyStore) ([]byte, error) {
+	bs, e := os.ReadFile(file)
+	if e != nil {
+		return nil, e
+	}
+	if !isSealed(bs) {
+		return bs, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("%s: %w", file, ErrKeyEncrypted)
+	}
+	bs, e = store.Open(bs)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %w", file, e)
+	}
+	return bs, nil
+}
+
+func writeSecret(file string, data []byte, store KeyStore) error {
+	if store != nil {
+		var e error
+		data, e = store.Seal(data)
+		if e != nil {
+			return e
+		}
+	}
+	return os.WriteFile(file, data, 0600)
+}
+
+func writeSecretJson(file string, data any, store KeyStore) error {
+	bs, e := json.MarshalIndent(data, "", "    ")
+	if e != nil {
+		return e
+	}
+	return writeSecret(file, bs, store)
+}
+
+// isSecretFile reports whether a file of the store holds private keys or
+// credentials, including the key bearing export formats.
+func isSecretFile(name string) bool {
+	switch name {
+	case "account.jwk.json", "pk.json", "privkey.pem", acmeDNSFile, "combined.pem", "privkey.der", "cert.p12":
+		return true
+	}
+	return false
+}
+
+// EncryptStore seals every plaintext private key in the store with
+// client.KeyStore and returns the files it rewrote.
+func (client *Client) EncryptStore() ([]string, error) {
+	if client.KeyStore == nil {
+		return nil, errors.New("no passphrase or key file configured")
+	}
+	rtn := make([]string, 0)
+	e := filepath.WalkDir(client.storeRoot, func(file string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if d.IsDir() || !isSecretFile(d.Name()) {
+			return nil
+		}
+		bs, e := os.ReadFile(file)
+		if e != nil {
+			return e
+		}
+		if isSealed(bs) {
+			return nil
+		}
+		e = writeSecret(file+".tmp", bs, client.KeyStore)
+		if e != nil {
+			return e
+		}
+		e = os.Rename(file+".tmp", file)
+		if e != nil {
+			return e
+		}
+		rtn = append(rtn, file)
+		return nil
+	})
+	return rtn, e
+}
+
+// DecryptStore is the reverse of EncryptStore.
+func (client *Client) DecryptStore() ([]string, error) {
+	rtn := make([]string, 0)
+	e := filepath.WalkDir(client.storeRoot, func(file string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if d.IsDir() || !isSecretFile(d.Name()) {
+			return nil
+		}
+		bs, e := os.ReadFile(file)
+		if e != nil {
+			return e
+		}
+		if !isSealed(bs) {
+			return nil
+		}
+		bs, e = readSecret(file, client.KeyStore)
+		if e != nil {
+			return e
+		}
+		e = writeSecret(file+".tmp", bs, nil)
+		if e != nil {
+			return e
+		}
+		e = os.Rename(file+".tmp", file)
+		if e != nil {
+			return e
+		}
+		rtn = append(rtn, file)
+		return nil
+	})
+	return rtn, e
+}
@@ -0,0 +1,202 @@
+package acme
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyStoreRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key")
+	e := GenerateKeyFile(file)
+	if e != nil {
+		t.Fatal(e)
+	}
+	keyFile, e := NewKeyFileKeyStore(file)
+	if e != nil {
+		t.Fatal(e)
+	}
+	stores := map[string]KeyStore{
+		"passphrase": &PassphraseKeyStore{Passphrase: []byte("secret")},
+		"keyfile":    keyFile,
+	}
+	for name, store := range stores {
+		sealed, e := store.Seal([]byte("private key"))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !isSealed(sealed) || strings.Contains(string(sealed), "private key") {
+			t.Fatalf("%s: not sealed: %s", name, sealed)
+		}
+		plain, e := store.Open(sealed)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if string(plain) != "private key" {
+			t.Fatalf("%s: opened %q", name, plain)
+		}
+	}
+}
+
+func TestKeyStoreWrongSecret(t *testing.T) {
+	sealed, e := (&PassphraseKeyStore{Passphrase: []byte("secret")}).Seal([]byte("private key"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, e = (&PassphraseKeyStore{Passphrase: []byte("wrong")}).Open(sealed)
+	if e == nil || !strings.Contains(e.Error(), "wrong passphrase") {
+		t.Fatalf("expected a wrong passphrase, got %v", e)
+	}
+
+	dir := t.TempDir()
+	GenerateKeyFile(filepath.Join(dir, "a"))
+	GenerateKeyFile(filepath.Join(dir, "b"))
+	a, _ := NewKeyFileKeyStore(filepath.Join(dir, "a"))
+	b, _ := NewKeyFileKeyStore(filepath.Join(dir, "b"))
+	sealed, e = a.Seal([]byte("private key"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e = b.Open(sealed); e == nil {
+		t.Fatal("opened with another key file")
+	}
+	if _, e = (&PassphraseKeyStore{Passphrase: []byte("secret")}).Open(sealed); e == nil {
+		t.Fatal("opened a key file seal with a passphrase")
+	}
+
+	file := filepath.Join(dir, "privkey.pem")
+	os.WriteFile(file, sealed, 0600)
+	if _, e = readSecret(file, nil); !errors.Is(e, ErrKeyEncrypted) {
+		t.Fatalf("expected ErrKeyEncrypted, got %v", e)
+	}
+}
+
+func TestKeyStoreScryptLimits(t *testing.T) {
+	store := &PassphraseKeyStore{Passphrase: []byte("secret")}
+	sealed, e := store.Seal([]byte("private key"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	tests := []struct{ n, r, p int }{
+		{1 << 30, 8, 1},
+		{1 << 20, 8, 1},
+		{1000, 8, 1},
+		{1 << 15, 0, 1},
+		{1 << 15, 8, 1 << 20},
+	}
+	for _, test := range tests {
+		v := &sealedFile{}
+		json.Unmarshal(sealed, v)
+		v.N, v.R, v.P = test.n, test.r, test.p
+		crafted, _ := json.Marshal(v)
+		_, e := store.Open(crafted)
+		if e == nil || !strings.Contains(e.Error(), "unsupported scrypt parameters") {
+			t.Errorf("n=%d r=%d p=%d: got %v", test.n, test.r, test.p, e)
+		}
+	}
+}
+
+func TestEncryptStore(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	obtainTestCert(t, client, "example.test")
+	cert, e := client.LoadStoredCert("example.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	// an export written before the store was encrypted
+	_, e = ExportCert(cert, &ExportOptions{Formats: []string{ExportCombined}})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	client.KeyStore = &PassphraseKeyStore{Passphrase: []byte("secret")}
+	files, e := client.EncryptStore()
+	if e != nil {
+		t.Fatal(e)
+	}
+	names := make(map[string]bool)
+	for _, file := range files {
+		names[filepath.Base(file)] = true
+		bs, _ := os.ReadFile(file)
+		if !isSealed(bs) {
+			t.Fatalf("%s not sealed", file)
+		}
+	}
+	for _, name := range []string{"account.jwk.json", "privkey.pem", "combined.pem"} {
+		if !names[name] {
+			t.Errorf("%s not encrypted, got %v", name, files)
+		}
+	}
+	if names["fullchain.pem"] {
+		t.Error("fullchain.pem encrypted")
+	}
+	again, e := client.EncryptStore()
+	if e != nil || len(again) != 0 {
+		t.Fatalf("encrypted again: %v %v", again, e)
+	}
+
+	loaded, e := client.LoadStoredCert("example.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if loaded.PrivateKeyPEM != cert.PrivateKeyPEM {
+		t.Fatal("key changed by the encryption")
+	}
+	_, e = LoadCert(cert.Path, nil)
+	if !errors.Is(e, ErrKeyEncrypted) {
+		t.Fatalf("loaded without the passphrase: %v", e)
+	}
+
+	files, e = client.DecryptStore()
+	if e != nil || len(files) < 3 {
+		t.Fatalf("decrypted %v: %v", files, e)
+	}
+	loaded, e = LoadCert(cert.Path, nil)
+	if e != nil || loaded.PrivateKeyPEM != cert.PrivateKeyPEM {
+		t.Fatalf("decrypted store unreadable: %v", e)
+	}
+}
+
+func TestEncryptedStoreExportAndHooks(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	client.KeyStore = &PassphraseKeyStore{Passphrase: []byte("secret")}
+	out := filepath.Join(t.TempDir(), "hook.out")
+	client.DeployHooks = []DeployHook{&CommandHook{Command: `cp "$ACME_KEY_PATH" ` + out + ` && echo "$ACME_KEY_PATH" >> ` + out + `.path`}}
+	client.AutoExport = &ExportOptions{Formats: []string{ExportCombined}}
+
+	_, e := client.ObtainCert(dnsIdentifiers("example.test"), &acceptSolver{})
+	var deployErr *DeployError
+	if !errors.As(e, &deployErr) || !strings.Contains(e.Error(), "export to a directory outside") {
+		t.Fatalf("expected the export into the store to be refused, got %v", e)
+	}
+	cert, e := client.LoadStoredCert("example.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := os.Stat(filepath.Join(cert.Path, "combined.pem")); !os.IsNotExist(e) {
+		t.Fatal("plaintext export written into the store")
+	}
+	bs, e := os.ReadFile(out)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(bs) != cert.PrivateKeyPEM {
+		t.Fatal("hook did not get the decrypted key")
+	}
+	path, _ := os.ReadFile(out + ".path")
+	if _, e := os.Stat(strings.TrimSpace(string(path))); !os.IsNotExist(e) {
+		t.Fatal("decrypted key left behind")
+	}
+
+	client.AutoExport.Dir = t.TempDir()
+	_, e = client.ObtainCert(dnsIdentifiers("example.test"), &acceptSolver{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := os.Stat(filepath.Join(client.AutoExport.Dir, "combined.pem")); e != nil {
+		t.Fatal(e)
+	}
+}
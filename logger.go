@@ -0,0 +1,102 @@
+package acme
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// secretFields are JSON members that never reach the log: private key
+// parameters, EAB MAC keys and key authorizations.
+var secretFields = map[string]bool{
+	"d":                true,
+	"p":                true,
+	"q":                true,
+	"dp":               true,
+	"dq":               true,
+	"qi":               true,
+	"k":                true,
+	"hmac":             true,
+	"hmacKey":          true,
+	"macKey":           true,
+	"keyAuthorization": true,
+	"keyAuth":          true,
+}
+
+// logger returns Client.Logger, or slog.Default() when it is nil. Set it to
+// a logger with a discarding handler to silence the client. Solvers, DNS
+// providers and reloaders made without a client have a Logger field of
+// their own.
+func (client *Client) logger() *slog.Logger {
+	if client.Logger != nil {
+		return client.Logger
+	}
+	return slog.Default()
+}
+
+// wireEnabled reports whether request and response dumps are logged. They
+// are opt-in with Client.DebugWire and need the debug level.
+func (client *Client) wireEnabled() bool {
+	return client.DebugWire && client.logger().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// redact returns data as generic JSON with secret members replaced.
+func redact(data any) any {
+	bs, e := json.Marshal(data)
+	if e != nil {
+		return fmt.Sprintf("%T", data)
+	}
+	var v any
+	e = json.Unmarshal(bs, &v)
+	if e != nil {
+		return string(bs)
+	}
+	return redactValue(v)
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, value := range v {
+			if secretFields[k] {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(value)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return v
+}
+
+// redactBody makes a response body safe to dump: JSON is redacted, anything
+// else, like certificates, is only described.
+func redactBody(header http.Header, body []byte) any {
+	if len(body) == 0 {
+		return ""
+	}
+	contentType := header.Get("Content-Type")
+	if strings.Contains(contentType, "json") {
+		var v any
+		if json.Unmarshal(body, &v) == nil {
+			return redactValue(v)
+		}
+	}
+	return fmt.Sprintf("<%d bytes %s>", len(body), contentType)
+}
+
+func flattenHeader(header http.Header) map[string]string {
+	rtn := make(map[string]string)
+	for k, v := range header {
+		rtn[k] = strings.Join(v, ", ")
+	}
+	return rtn
+}
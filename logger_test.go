@@ -0,0 +1,92 @@
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	payload := map[string]any{
+		"jwk": &JWK{Kty: "EC", Crv: "P-256", X: "public-x", Y: "public-y", D: "secret-d"},
+		"externalAccountBinding": map[string]any{
+			"hmacKey": "secret-hmac",
+			"kid":     "public-kid",
+		},
+		"challenges": []any{
+			map[string]any{"type": "http-01", "keyAuthorization": "secret-keyauth"},
+		},
+	}
+	bs, _ := json.Marshal(redact(payload))
+	dump := string(bs)
+	for _, secret := range []string{"secret-d", "secret-hmac", "secret-keyauth"} {
+		if strings.Contains(dump, secret) {
+			t.Errorf("%s in %s", secret, dump)
+		}
+	}
+	for _, public := range []string{"public-x", "public-kid", "http-01"} {
+		if !strings.Contains(dump, public) {
+			t.Errorf("%s redacted from %s", public, dump)
+		}
+	}
+
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	bs, _ = json.Marshal(redactBody(header, []byte(`{"status":"valid","keyAuthorization":"secret-keyauth"}`)))
+	if strings.Contains(string(bs), "secret-keyauth") || !strings.Contains(string(bs), "valid") {
+		t.Errorf("body dumped as %s", bs)
+	}
+	header.Set("Content-Type", "application/pem-certificate-chain")
+	if body := redactBody(header, []byte("-----BEGIN CERTIFICATE-----")); body != "<27 bytes application/pem-certificate-chain>" {
+		t.Errorf("certificate dumped as %v", body)
+	}
+}
+
+// keyAuthSolver accepts every challenge and keeps the key authorizations.
+type keyAuthSolver struct {
+	lock     sync.Mutex
+	keyAuths []string
+}
+
+func (solver *keyAuthSolver) Type() string {
+	return ChallengeTypeDNS01
+}
+
+func (solver *keyAuthSolver) Present(domain string, token string, keyAuth string) error {
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	solver.keyAuths = append(solver.keyAuths, keyAuth)
+	return nil
+}
+
+func (solver *keyAuthSolver) CleanUp(domain string, token string, keyAuth string) error {
+	return nil
+}
+
+func TestDebugWireRedacted(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	out := &bytes.Buffer{}
+	client.Logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client.DebugWire = true
+	solver := &keyAuthSolver{}
+	_, e := client.ObtainCert(dnsIdentifiers("example.test"), solver)
+	if e != nil {
+		t.Fatal(e)
+	}
+	dump := out.String()
+	if !strings.Contains(dump, `"msg":"jws"`) || !strings.Contains(dump, `"msg":"response"`) {
+		t.Fatal("no wire dump")
+	}
+	if len(solver.keyAuths) == 0 {
+		t.Fatal("no challenge presented")
+	}
+	secrets := append([]string{client.JWK.D}, solver.keyAuths...)
+	for _, secret := range secrets {
+		if secret == "" || strings.Contains(dump, secret) {
+			t.Fatalf("%q in the wire dump", secret)
+		}
+	}
+}
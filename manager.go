@@ -0,0 +1,316 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostPolicy decides whether Manager may obtain a certificate for host on
+// the first handshake that asks for it.
+type HostPolicy func(ctx context.Context, host string) error
+
+// HostAllowlist allows exactly the given host names.
+func HostAllowlist(hosts ...string) HostPolicy {
+	allowed := make(map[string]bool)
+	for _, host := range hosts {
+		allowed[strings.ToLower(strings.TrimSuffix(host, "."))] = true
+	}
+	return func(ctx context.Context, host string) error {
+		if !allowed[host] {
+			return fmt.Errorf("host %q is not allowed", host)
+		}
+		return nil
+	}
+}
+
+type managedCert struct {
+	name string
+	cert *tls.Certificate
+}
+
+type issueCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// Manager serves the certificates of the store through
+// tls.Config.GetCertificate, the way autocert does. Certificates are picked
+// by SNI, exact names before wildcards, and kept parsed in memory. Hosts
+// without a certificate are obtained on their first handshake when
+// HostPolicy allows them, and tls-alpn-01 challenges are answered on the
+// same listener. Run renews in the background and keeps the OCSP
+// responses stapled to the certificates fresh.
+type Manager struct {
+	Client *Client
+	// HostPolicy nil serves stored certificates only.
+	HostPolicy HostPolicy
+	Renewal    *RenewalManager
+	// OCSPInterval is how often the OCSP responses are checked, 0 disables
+	// stapling refreshes.
+	OCSPInterval time.Duration
+	solver       *TLSALPN01Solver
+	lock         sync.RWMutex
+	loadOnce     sync.Once
+	hosts        map[string]*managedCert
+	issuing      map[string]*issueCall
+	// clientLock serializes issuance, Client is not safe for concurrent
+	// orders.
+	clientLock sync.Mutex
+}
+
+// NewManager returns a Manager that obtains and renews certificates with
+// tls-alpn-01 answered by its own GetCertificate.
+func NewManager(client *Client, policy HostPolicy) *Manager {
+	solver := &TLSALPN01Solver{}
+	rtn := &Manager{
+		Client:       client,
+		HostPolicy:   policy,
+		Renewal:      NewRenewalManager(client, solver),
+		OCSPInterval: time.Hour,
+		solver:       solver,
+		hosts:        make(map[string]*managedCert),
+		issuing:      make(map[string]*issueCall),
+	}
+	rtn.Renewal.lock = &rtn.clientLock
+	rtn.Renewal.renewed = func(result *RenewalResult) {
+		_, e := rtn.reload(result.Name)
+		if e != nil {
+			client.logger().Warn("reload renewed certificate failed", "name", result.Name, "error", e)
+		}
+	}
+	return rtn
+}
+
+// TLSConfig returns a tls.Config serving h2, http/1.1 and acme-tls/1.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", ACMETLS1Protocol},
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+func tlsCertificate(cert *Cert) (*tls.Certificate, error) {
+	if len(cert.Certs) == 0 {
+		return nil, errors.New("no certificate in fullchain.pem")
+	}
+	if cert.PrivateKey == nil {
+		return nil, errors.New("no private key")
+	}
+	rtn := &tls.Certificate{PrivateKey: cert.PrivateKey, Leaf: cert.Certs[0], OCSPStaple: cert.OCSPStaple}
+	for _, c := range cert.Certs {
+		rtn.Certificate = append(rtn.Certificate, c.Raw)
+	}
+	return rtn, nil
+}
+
+// Load reads every certificate of the store into memory, replacing what
+// was loaded before.
+func (m *Manager) Load() error {
+	certs, e := m.Client.GetLocalCerts()
+	if e != nil {
+		return e
+	}
+	hosts := make(map[string]*managedCert)
+	for i := range certs {
+		_, e := m.add(hosts, &certs[i])
+		if e != nil {
+			m.Client.logger().Warn("skip certificate", "name", certs[i].Name, "error", e)
+		}
+	}
+	m.lock.Lock()
+	m.hosts = hosts
+	m.lock.Unlock()
+	return nil
+}
+
+// reload reads one certificate of the store into memory.
+func (m *Manager) reload(name string) (*tls.Certificate, error) {
+	cert, e := m.Client.LoadStoredCert(name)
+	if e != nil {
+		return nil, e
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for host, managed := range m.hosts {
+		if managed.name == name {
+			delete(m.hosts, host)
+		}
+	}
+	return m.add(m.hosts, cert)
+}
+
+// add indexes cert by its names. When two certificates cover a name the
+// one valid longer wins.
+func (m *Manager) add(hosts map[string]*managedCert, cert *Cert) (*tls.Certificate, error) {
+	tc, e := tlsCertificate(cert)
+	if e != nil {
+		return nil, e
+	}
+	managed := &managedCert{name: cert.Name, cert: tc}
+	for _, host := range tc.Leaf.DNSNames {
+		host = strings.ToLower(host)
+		old := hosts[host]
+		if old == nil || old.cert.Leaf.NotAfter.Before(tc.Leaf.NotAfter) {
+			hosts[host] = managed
+		}
+	}
+	for _, ip := range tc.Leaf.IPAddresses {
+		hosts[ip.String()] = managed
+	}
+	return tc, nil
+}
+
+func (m *Manager) lookup(host string) *tls.Certificate {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if managed := m.hosts[host]; managed != nil {
+		return managed.cert
+	}
+	if _, parent, ok := strings.Cut(host, "."); ok {
+		if managed := m.hosts["*."+parent]; managed != nil {
+			return managed.cert
+		}
+	}
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if isACMETLS1(hello) {
+		return m.solver.GetCertificate(hello)
+	}
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if host == "" {
+		return nil, errors.New("missing server name")
+	}
+	m.loadOnce.Do(func() {
+		e := m.Load()
+		if e != nil {
+			m.Client.logger().Warn("load certificates failed", "error", e)
+		}
+	})
+	if cert := m.lookup(host); cert != nil {
+		return cert, nil
+	}
+	if m.HostPolicy == nil {
+		return nil, fmt.Errorf("no certificate for %q", host)
+	}
+	ctx := hello.Context()
+	e := m.HostPolicy(ctx, host)
+	if e != nil {
+		return nil, e
+	}
+	return m.issue(ctx, host)
+}
+
+// issue obtains a certificate for host, concurrent handshakes for the
+// same host wait for one order.
+func (m *Manager) issue(ctx context.Context, host string) (*tls.Certificate, error) {
+	m.lock.Lock()
+	call := m.issuing[host]
+	if call == nil {
+		call = &issueCall{done: make(chan struct{})}
+		m.issuing[host] = call
+		go func() {
+			call.cert, call.err = m.obtain(host)
+			m.lock.Lock()
+			delete(m.issuing, host)
+			m.lock.Unlock()
+			close(call.done)
+		}()
+	}
+	m.lock.Unlock()
+	select {
+	case <-call.done:
+		return call.cert, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *Manager) obtain(host string) (*tls.Certificate, error) {
+	m.clientLock.Lock()
+	defer m.clientLock.Unlock()
+	if cert := m.lookup(host); cert != nil {
+		return cert, nil
+	}
+	m.Client.logger().Info("obtaining certificate on demand", "host", host)
+	identifier := Identifier{Type: "dns", Value: host}
+	dir, e := m.Client.ObtainCert([]Identifier{identifier}, m.solver)
+	if dir == "" {
+		return nil, e
+	}
+	if e != nil {
+		// stored, only the deploy hooks failed
+		m.Client.logger().Warn("deploy after on demand issuance failed", "host", host, "error", e)
+	}
+	return m.reload(m.Client.storedName(dir))
+}
+
+// Run loads the store and renews its certificates every
+// Renewal.Interval until ctx is done. Renewed certificates are served
+// from the next handshake on.
+func (m *Manager) Run(ctx context.Context) error {
+	// the first handshake does not have to load the store again
+	m.loadOnce.Do(func() {})
+	e := m.Load()
+	if e != nil {
+		return e
+	}
+	if m.OCSPInterval > 0 {
+		go m.runOCSP(ctx)
+	}
+	return m.Renewal.Run(ctx)
+}
+
+func (m *Manager) runOCSP(ctx context.Context) {
+	ticker := time.NewTicker(m.OCSPInterval)
+	defer ticker.Stop()
+	for {
+		m.RefreshOCSP()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshOCSP refreshes the OCSP responses of the loaded certificates that
+// are missing or stale and staples the new ones.
+func (m *Manager) RefreshOCSP() {
+	m.lock.RLock()
+	served := make(map[string]*tls.Certificate)
+	for _, managed := range m.hosts {
+		served[managed.name] = managed.cert
+	}
+	m.lock.RUnlock()
+	for name, tc := range served {
+		cert, e := m.Client.LoadStoredCert(name)
+		if e != nil {
+			m.Client.logger().Warn("load certificate failed", "name", name, "error", e)
+			continue
+		}
+		_, e = m.Client.RefreshOCSP(cert, false)
+		if errors.Is(e, ErrNoOCSPServer) {
+			continue
+		}
+		if e != nil {
+			m.Client.logger().Warn("refresh ocsp failed", "name", name, "error", e)
+		}
+		if !bytes.Equal(cert.OCSPStaple, tc.OCSPStaple) {
+			_, e = m.reload(name)
+			if e != nil {
+				m.Client.logger().Warn("reload certificate failed", "name", name, "error", e)
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/tonyzzp/acme/acmetest"
+)
+
+// serveManager serves m on a local TLS listener, completing every
+// handshake, and returns its port.
+func serveManager(t *testing.T, m **Manager) int {
+	t.Helper()
+	config := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return (*m).GetCertificate(hello)
+		},
+		NextProtos: []string{"h2", "http/1.1", ACMETLS1Protocol},
+	}
+	ln, e := tls.Listen("tcp", "127.0.0.1:0", config)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, e := ln.Accept()
+			if e != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// newManagerTest returns a Manager whose tls-alpn-01 challenges acmetest
+// validates against a local listener, every name resolving to it.
+func newManagerTest(t *testing.T) (*Manager, *acmetest.Server, string) {
+	t.Helper()
+	var m *Manager
+	port := serveManager(t, &m)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	client, s := newTestClient(t, &acmetest.Options{
+		TLSPort: port,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	})
+	m = NewManager(client, nil)
+	return m, s, addr
+}
+
+func TestManagerLookup(t *testing.T) {
+	m, _, _ := newManagerTest(t)
+	exact := obtainTestCert(t, m.Client, "a.wild.test")
+	wildcard := obtainTestCert(t, m.Client, "*.wild.test")
+	tests := map[string]*Cert{
+		"a.wild.test":   exact,
+		"A.Wild.Test.":  exact,
+		"b.wild.test":   wildcard,
+		"a.b.wild.test": nil,
+		"wild.test":     nil,
+	}
+	for host, want := range tests {
+		tc, e := m.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+		if want == nil {
+			if e == nil {
+				t.Errorf("%s: served %s", host, tc.Leaf.DNSNames)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("%s: %v", host, e)
+			continue
+		}
+		if !bytes.Equal(tc.Certificate[0], want.Certs[0].Raw) {
+			t.Errorf("%s: served %v", host, tc.Leaf.DNSNames)
+		}
+	}
+	if _, e := m.GetCertificate(&tls.ClientHelloInfo{}); e == nil {
+		t.Error("served a handshake without SNI")
+	}
+}
+
+func TestManagerOnDemand(t *testing.T) {
+	m, s, addr := newManagerTest(t)
+	s.SetValidateChallenges(true)
+	m.HostPolicy = HostAllowlist("new.test")
+
+	dial := func(host string) (*x509.Certificate, error) {
+		conn, e := tls.Dial("tcp", addr, &tls.Config{ServerName: host, RootCAs: s.Roots()})
+		if e != nil {
+			return nil, e
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0], nil
+	}
+
+	_, e := dial("evil.test")
+	if e == nil {
+		t.Fatal("handshake for a host the policy refuses succeeded")
+	}
+	if _, e := m.Client.LoadStoredCert("evil.test"); e == nil {
+		t.Fatal("certificate obtained for a refused host")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			leaf, e := dial("new.test")
+			if e == nil {
+				e = leaf.VerifyHostname("new.test")
+			}
+			errs <- e
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	history, e := m.Client.CertHistory("new.test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(history.Versions) != 1 {
+		t.Fatalf("concurrent handshakes obtained %d certificates", len(history.Versions))
+	}
+}
+
+func TestTLSALPNChallengeCertificate(t *testing.T) {
+	m, _, addr := newManagerTest(t)
+	keyAuth := "token.thumbprint"
+	e := m.solver.Present("x.test", "token", keyAuth)
+	if e != nil {
+		t.Fatal(e)
+	}
+	conn, e := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         "x.test",
+		NextProtos:         []string{ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+	if state.NegotiatedProtocol != ACMETLS1Protocol {
+		t.Fatalf("negotiated %q", state.NegotiatedProtocol)
+	}
+	cert := state.PeerCertificates[0]
+	if e := cert.VerifyHostname("x.test"); e != nil {
+		t.Fatal(e)
+	}
+	want := sha256.Sum256([]byte(keyAuth))
+	found := false
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAcmeIdentifier) {
+			continue
+		}
+		found = true
+		var value []byte
+		_, e := asn1.Unmarshal(ext.Value, &value)
+		if e != nil || !ext.Critical || !bytes.Equal(value, want[:]) {
+			t.Fatalf("acmeIdentifier critical %v, value %x: %v", ext.Critical, value, e)
+		}
+	}
+	if !found {
+		t.Fatal("no acmeIdentifier extension")
+	}
+
+	e = m.solver.CleanUp("x.test", "token", keyAuth)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, e = tls.Dial("tcp", addr, &tls.Config{ServerName: "x.test", NextProtos: []string{ACMETLS1Protocol}, InsecureSkipVerify: true})
+	if e == nil {
+		t.Fatal("challenge answered after CleanUp")
+	}
+	if e := m.solver.Present("*.x.test", "token", keyAuth); e == nil {
+		t.Fatal("tls-alpn-01 presented for a wildcard")
+	}
+}
@@ -0,0 +1,162 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tonyzzp/acme/utils"
+)
+
+var ErrPollTimeout = errors.New("timed out waiting for the CA")
+
+func (client *Client) ObtainCert(identifiers []Identifier, solver Solver) (string, error) {
+	return client.obtain("", NewOrderPayload{Identifiers: identifiers}, solver)
+}
+
+// ObtainNamedCert is ObtainCert storing the certificate under name, see
+// SetCertName.
+func (client *Client) ObtainNamedCert(name string, identifiers []Identifier, solver Solver) (string, error) {
+	e := validCertName(name)
+	if e != nil {
+		return "", e
+	}
+	return client.obtain(name, NewOrderPayload{Identifiers: identifiers}, solver)
+}
+
+func (client *Client) obtain(name string, payload NewOrderPayload, solver Solver) (string, error) {
+	order, e := client.newOrder(payload, solver.Type())
+	if e != nil {
+		return "", e
+	}
+	if name != "" {
+		e = client.SetCertName(order, name)
+		if e != nil {
+			return "", e
+		}
+	}
+	return client.CompleteOrder(order, solver)
+}
+
+// CompleteOrder drives an existing order through authorization, finalization
+// and download using solver for every pending authorization.
+func (client *Client) CompleteOrder(order *Order, solver Solver) (string, error) {
+	e := client.InitAccount()
+	if e != nil {
+		return "", e
+	}
+	if order.Status == OrderStatusPending {
+		for _, authUrl := range order.Authorizations {
+			e = client.authorize(authUrl, solver)
+			if e != nil {
+				// a failed authorization invalidates the order
+				if current, _ := client.FetchOrder(order.Uri); current != nil && current.Status == OrderStatusInvalid {
+					client.removePending(current)
+				}
+				return "", e
+			}
+		}
+	}
+	order, e = client.waitOrder(order, OrderStatusPending)
+	if e != nil {
+		return "", e
+	}
+	if order.Status == OrderStatusReady {
+		_, e = client.Finalize(order)
+		if e != nil {
+			return "", e
+		}
+		order, e = client.waitOrder(order, OrderStatusReady, OrderStatusProcessing)
+		if e != nil {
+			return "", e
+		}
+	}
+	if order.Status == OrderStatusInvalid {
+		client.removePending(order)
+	}
+	if order.Status != OrderStatusValid {
+		return "", fmt.Errorf("order %s is %s", order.Uri, order.Status)
+	}
+	dir, _, e := client.DownloadCert(order)
+	return dir, e
+}
+
+func (client *Client) authorize(authUrl string, solver Solver) error {
+	auth, e := client.GetOrderAuth(authUrl)
+	if e != nil {
+		return e
+	}
+	if auth.Status == OrderStatusValid {
+		return nil
+	}
+	if auth.Status != OrderStatusPending {
+		return fmt.Errorf("authorization for %s is %s", auth.Identifier.Value, auth.Status)
+	}
+	challenge := utils.SliceFind(auth.Challenges, func(v Challenge) bool { return v.Type == solver.Type() })
+	if challenge == nil {
+		return fmt.Errorf("no %s challenge offered for %s", solver.Type(), auth.Identifier.Value)
+	}
+	domain := auth.Identifier.Value
+	if auth.Wildcard {
+		domain = "*." + domain
+	}
+	keyAuth := client.KeyAuthorization(challenge.Token)
+	e = solver.Present(domain, challenge.Token, keyAuth)
+	if e != nil {
+		return fmt.Errorf("present %s for %s: %w", solver.Type(), domain, e)
+	}
+	defer func() {
+		e := solver.CleanUp(domain, challenge.Token, keyAuth)
+		if e != nil {
+			client.logger().Warn("clean up challenge failed", "domain", domain, "error", e)
+		}
+	}()
+	_, e = client.SubmitChallenge(challenge.Url)
+	if e != nil {
+		return e
+	}
+	deadline := time.Now().Add(client.PollTimeout)
+	for {
+		time.Sleep(client.PollInterval)
+		auth, e = client.GetOrderAuth(authUrl)
+		if e != nil {
+			return e
+		}
+		switch auth.Status {
+		case OrderStatusValid:
+			return nil
+		case OrderStatusPending, OrderStatusProcessing:
+		default:
+			c := utils.SliceFind(auth.Challenges, func(v Challenge) bool { return v.Type == solver.Type() })
+			if c != nil && c.Error != nil {
+				return fmt.Errorf("authorization for %s is %s: %w", domain, auth.Status, c.Error)
+			}
+			return fmt.Errorf("authorization for %s is %s", domain, auth.Status)
+		}
+		if time.Now().After(deadline) {
+			return ErrPollTimeout
+		}
+	}
+}
+
+// waitOrder refetches the order until its status leaves the given ones.
+func (client *Client) waitOrder(order *Order, statuses ...string) (*Order, error) {
+	deadline := time.Now().Add(client.PollTimeout)
+	for {
+		rtn, e := client.FetchOrder(order.Uri)
+		if e != nil {
+			return nil, e
+		}
+		if !utils.Contains(statuses, rtn.Status) {
+			return rtn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrPollTimeout
+		}
+		delay := client.PollInterval
+		if rtn.RetryAfter > 0 && time.Duration(rtn.RetryAfter)*time.Second > delay {
+			delay = time.Duration(rtn.RetryAfter) * time.Second
+		}
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,141 @@
+package acme
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspFile holds the last OCSP response next to privkey.pem and
+// fullchain.pem.
+const ocspFile = "ocsp.der"
+
+var ErrNoOCSPServer = errors.New("certificate has no OCSP server")
+
+// parseOCSP checks that der answers for the leaf of cert, is signed by
+// its issuer or a responder the issuer delegated to, and is current.
+func parseOCSP(der []byte, cert *Cert, now time.Time) (*ocsp.Response, error) {
+	if len(cert.Certs) < 2 {
+		return nil, errors.New("the chain has no issuer to verify OCSP against")
+	}
+	rtn, e := ocsp.ParseResponseForCert(der, cert.Certs[0], cert.Certs[1])
+	if e != nil {
+		return nil, e
+	}
+	if now.Before(rtn.ThisUpdate) {
+		return nil, fmt.Errorf("OCSP response is not valid before %s", rtn.ThisUpdate.Format(time.RFC3339))
+	}
+	if !rtn.NextUpdate.IsZero() && !now.Before(rtn.NextUpdate) {
+		return nil, fmt.Errorf("OCSP response expired at %s", rtn.NextUpdate.Format(time.RFC3339))
+	}
+	return rtn, nil
+}
+
+// ocspStale reports whether a response should be refreshed: past the
+// middle of its validity, or older than a day when it has no nextUpdate.
+func ocspStale(resp *ocsp.Response, now time.Time) bool {
+	if resp.NextUpdate.IsZero() {
+		return now.Sub(resp.ThisUpdate) > 24*time.Hour
+	}
+	return now.After(resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2))
+}
+
+// LoadOCSP returns the stored OCSP response of cert if it is still valid.
+func LoadOCSP(cert *Cert) (*ocsp.Response, error) {
+	bs, e := os.ReadFile(filepath.Join(cert.Path, ocspFile))
+	if e != nil {
+		return nil, e
+	}
+	return parseOCSP(bs, cert, time.Now())
+}
+
+// FetchOCSP asks the OCSP server of the leaf for its status.
+func (client *Client) FetchOCSP(cert *Cert) (*ocsp.Response, error) {
+	if len(cert.Certs) == 0 {
+		return nil, errors.New("no certificate in fullchain.pem")
+	}
+	leaf := cert.Certs[0]
+	if len(leaf.OCSPServer) == 0 {
+		return nil, ErrNoOCSPServer
+	}
+	if len(cert.Certs) < 2 {
+		return nil, errors.New("the chain has no issuer to ask OCSP about")
+	}
+	req, e := ocsp.CreateRequest(leaf, cert.Certs[1], &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if e != nil {
+		return nil, e
+	}
+	rc, e := client.http()
+	if e != nil {
+		return nil, e
+	}
+	url := leaf.OCSPServer[0]
+	client.logger().Debug("fetch ocsp", "url", url, "name", cert.Name)
+	res, e := rc.R().
+		SetHeader("Content-Type", "application/ocsp-request").
+		SetHeader("Accept", "application/ocsp-response").
+		SetBody(req).
+		Post(url)
+	if e != nil {
+		return nil, e
+	}
+	if !res.IsSuccess() {
+		return nil, fmt.Errorf("OCSP server %s returned %s", url, res.Status())
+	}
+	return parseOCSP(res.Body(), cert, time.Now())
+}
+
+// RefreshOCSP returns the stored OCSP response of cert, fetching and
+// storing a new one when there is none or it is past the middle of its
+// validity. When the server cannot be reached a stored response that is
+// still valid is returned with the error.
+func (client *Client) RefreshOCSP(cert *Cert, force bool) (*ocsp.Response, error) {
+	cached, e := LoadOCSP(cert)
+	if e == nil && !force && !ocspStale(cached, time.Now()) {
+		return cached, nil
+	}
+	fresh, e := client.FetchOCSP(cert)
+	if e != nil {
+		if cached != nil {
+			client.logger().Warn("refresh ocsp failed, keeping the stored response", "name", cert.Name, "error", e)
+		}
+		return cached, e
+	}
+	file := filepath.Join(cert.Path, ocspFile)
+	e = os.WriteFile(file+".tmp", fresh.Raw, 0644)
+	if e == nil {
+		e = os.Rename(file+".tmp", file)
+	}
+	if e != nil {
+		return fresh, fmt.Errorf("save %s: %w", ocspFile, e)
+	}
+	if fresh.Status != ocsp.Good {
+		client.logger().Warn("ocsp status is not good", "name", cert.Name, "status", OCSPStatusText(fresh.Status))
+	}
+	cert.OCSPStaple = stapleOf(fresh)
+	return fresh, nil
+}
+
+// stapleOf is the response to staple, only good ones are stapled.
+func stapleOf(resp *ocsp.Response) []byte {
+	if resp == nil || resp.Status != ocsp.Good {
+		return nil
+	}
+	return resp.Raw
+}
+
+// OCSPStatusText names an ocsp.Response status.
+func OCSPStatusText(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
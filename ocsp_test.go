@@ -0,0 +1,169 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tonyzzp/acme/acmetest"
+	"golang.org/x/crypto/ocsp"
+)
+
+// obtainStoredCert obtains a certificate for name and loads it from the
+// store, with its name and version.
+func obtainStoredCert(t *testing.T, client *Client, name string) *Cert {
+	t.Helper()
+	obtainTestCert(t, client, name)
+	cert, e := client.LoadStoredCert(name)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return cert
+}
+
+func TestRefreshOCSP(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	cert := obtainStoredCert(t, client, "example.test")
+	resp, e := client.RefreshOCSP(cert, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if resp.Status != ocsp.Good || !bytes.Equal(cert.OCSPStaple, resp.Raw) {
+		t.Fatalf("status %s, staple set %v", OCSPStatusText(resp.Status), cert.OCSPStaple != nil)
+	}
+	bs, e := os.ReadFile(filepath.Join(cert.Path, ocspFile))
+	if e != nil || !bytes.Equal(bs, resp.Raw) {
+		t.Fatalf("response not persisted: %v", e)
+	}
+	loaded, e := client.LoadStoredCert("example.test")
+	if e != nil || !bytes.Equal(loaded.OCSPStaple, resp.Raw) {
+		t.Fatalf("staple not loaded with the certificate: %v", e)
+	}
+
+	// fresh responses are not fetched again unless forced
+	requests := s.OCSPRequests()
+	_, e = client.RefreshOCSP(cert, false)
+	if e != nil || s.OCSPRequests() != requests {
+		t.Fatalf("fresh response fetched again: %v", e)
+	}
+	_, e = client.RefreshOCSP(cert, true)
+	if e != nil || s.OCSPRequests() != requests+1 {
+		t.Fatalf("forced refresh not fetched: %v", e)
+	}
+
+	// a valid response is kept while the responder is down
+	s.SetOCSPUnavailable(true)
+	kept, e := client.RefreshOCSP(cert, true)
+	if e == nil {
+		t.Fatal("expected the 503 to be reported")
+	}
+	if kept == nil || kept.Status != ocsp.Good {
+		t.Fatal("stored response not returned on a 503")
+	}
+	if _, e := LoadOCSP(cert); e != nil {
+		t.Fatalf("stored response lost on a 503: %v", e)
+	}
+}
+
+func TestRefreshOCSPPastHalf(t *testing.T) {
+	// acmetest dates responses a minute back, with a validity of 100s they
+	// are valid but past the middle of it
+	client, s := newTestClient(t, &acmetest.Options{OCSPValidity: 100 * time.Second})
+	cert := obtainStoredCert(t, client, "example.test")
+	first, e := client.RefreshOCSP(cert, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !ocspStale(first, time.Now()) {
+		t.Fatal("response is not past the middle of its validity")
+	}
+	requests := s.OCSPRequests()
+	_, e = client.RefreshOCSP(cert, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.OCSPRequests() != requests+1 {
+		t.Fatal("stale response not refreshed")
+	}
+	if ocspStale(first, first.ThisUpdate.Add(49*time.Second)) {
+		t.Fatal("response stale before the middle of its validity")
+	}
+}
+
+func TestManagerOCSPStaple(t *testing.T) {
+	client, s := newTestClient(t, &acmetest.Options{OCSPValidity: 100 * time.Second})
+	cert := obtainStoredCert(t, client, "example.test")
+	m := NewManager(client, HostAllowlist("example.test"))
+	e := m.Load()
+	if e != nil {
+		t.Fatal(e)
+	}
+	hello := &tls.ClientHelloInfo{ServerName: "example.test"}
+	served, e := m.GetCertificate(hello)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if served.OCSPStaple != nil {
+		t.Fatal("staple served before one was fetched")
+	}
+
+	m.RefreshOCSP()
+	served, e = m.GetCertificate(hello)
+	if e != nil {
+		t.Fatal(e)
+	}
+	resp, e := ocsp.ParseResponseForCert(served.OCSPStaple, cert.Certs[0], cert.Certs[1])
+	if e != nil || resp.Status != ocsp.Good {
+		t.Fatalf("staple not swapped in: %v", e)
+	}
+
+	// a revoked certificate is no longer stapled
+	e = client.RevokeCert(cert.Certs[0], 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !s.Revoked(cert.Certs[0]) {
+		t.Fatal("not revoked")
+	}
+	m.RefreshOCSP()
+	served, e = m.GetCertificate(hello)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if served.OCSPStaple != nil {
+		t.Fatal("revoked response stapled")
+	}
+}
+
+func TestCheckCertRevoked(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	cert := obtainStoredCert(t, client, "example.test")
+	options := &CheckOptions{WarningDays: 30, CriticalDays: 7, Roots: s.Roots()}
+	_, e := client.RefreshOCSP(cert, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	check := CheckCert(cert, options)
+	if check.Status != CheckOK {
+		t.Fatalf("status %s: %v", check.Status, check.Problems)
+	}
+
+	e = client.RevokeCert(cert.Certs[0], 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	resp, e := client.RefreshOCSP(cert, true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if resp.Status != ocsp.Revoked || cert.OCSPStaple != nil {
+		t.Fatalf("status %s after revocation", OCSPStatusText(resp.Status))
+	}
+	check = CheckCert(cert, options)
+	if check.Status != CheckCritical {
+		t.Fatalf("revoked certificate is %s: %v", check.Status, check.Problems)
+	}
+}
@@ -0,0 +1,110 @@
+package acme
+
+import (
+	"errors"
+	"net/http"
+)
+
+// FetchOrderList fetches one page of the account orders list.
+func (client *Client) FetchOrderList(url string) (*OrderList, error) {
+	e := client.InitAccount()
+	if e != nil {
+		return nil, e
+	}
+	rtn := &OrderList{}
+	res, e := client.request(HttpRequestParam{
+		Url:    url,
+		Method: http.MethodPost,
+		Kid:    client.Account.Uri,
+		Result: rtn,
+	})
+	if e != nil {
+		return nil, e
+	}
+	next := parseLinks(res.Header(), url, "next")
+	if len(next) > 0 {
+		rtn.Next = next[0]
+	}
+	return rtn, nil
+}
+
+// FetchOrders returns the urls of every order the CA lists for the
+// account, following rel="next" across pages. CAs are only required to
+// list pending orders.
+func (client *Client) FetchOrders() ([]string, error) {
+	e := client.InitAccount()
+	if e != nil {
+		return nil, e
+	}
+	if client.Account.Orders == "" {
+		// accounts stored before the orders url was kept
+		_, e = client.FetchAccount()
+		if e != nil {
+			return nil, e
+		}
+	}
+	if client.Account.Orders == "" {
+		return nil, errors.New("the CA does not list account orders")
+	}
+	rtn := make([]string, 0)
+	seen := map[string]bool{}
+	url := client.Account.Orders
+	for url != "" && !seen[url] {
+		seen[url] = true
+		page, e := client.FetchOrderList(url)
+		if e != nil {
+			return nil, e
+		}
+		rtn = append(rtn, page.Orders...)
+		url = page.Next
+	}
+	return rtn, nil
+}
+
+// SyncOrders imports the orders the CA lists that are missing from the
+// local store. With refresh the local copies of listed orders are fetched
+// again too. Orders that fail to fetch are reported in Failed and do not
+// stop the others.
+func (client *Client) SyncOrders(refresh bool) (*OrderSync, error) {
+	urls, e := client.FetchOrders()
+	if e != nil {
+		return nil, e
+	}
+	local, e := client.GetLocalOrders()
+	if e != nil {
+		return nil, e
+	}
+	known := map[string]bool{}
+	for _, order := range local {
+		known[order.Uri] = true
+	}
+	listed := map[string]bool{}
+	rtn := &OrderSync{Failed: map[string]error{}}
+	for _, url := range urls {
+		if listed[url] {
+			continue
+		}
+		listed[url] = true
+		if known[url] && !refresh {
+			continue
+		}
+		order, e := client.FetchOrder(url)
+		if e != nil {
+			client.logger().Warn("fetch order failed", "url", url, "error", e)
+			rtn.Failed[url] = e
+			continue
+		}
+		if known[url] {
+			rtn.Refreshed = append(rtn.Refreshed, order)
+		} else {
+			client.logger().Info("order imported", "uri", url, "status", order.Status)
+			rtn.Imported = append(rtn.Imported, order)
+		}
+	}
+	for _, order := range local {
+		if !listed[order.Uri] {
+			rtn.LocalOnly = append(rtn.LocalOnly, order)
+		}
+	}
+	return rtn, nil
+}
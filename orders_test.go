@@ -0,0 +1,116 @@
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tonyzzp/acme/acmetest"
+	"github.com/tonyzzp/acme/utils"
+)
+
+// newTestOrders creates an order for every name and returns their urls.
+func newTestOrders(t *testing.T, client *Client, names ...string) []string {
+	t.Helper()
+	rtn := make([]string, 0)
+	for _, name := range names {
+		order, e := client.NewOrder(dnsIdentifiers(name))
+		if e != nil {
+			t.Fatal(e)
+		}
+		rtn = append(rtn, order.Uri)
+	}
+	return rtn
+}
+
+func TestFetchOrdersPages(t *testing.T) {
+	client, _ := newTestClient(t, &acmetest.Options{OrdersPageSize: 2})
+	want := newTestOrders(t, client, "a.test", "b.test", "c.test", "d.test", "e.test")
+	page, e := client.FetchOrderList(client.Account.Orders)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(page.Orders) != 2 || page.Next == "" {
+		t.Fatalf("first page %+v", page)
+	}
+	urls, e := client.FetchOrders()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(urls, want) {
+		t.Fatalf("listed %v, want %v", urls, want)
+	}
+}
+
+func TestFetchOrdersLoop(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	e := client.InitAccount()
+	if e != nil {
+		t.Fatal(e)
+	}
+	// page 2 links back to page 1
+	requests := 0
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		page := r.URL.Query().Get("page")
+		next := map[string]string{"1": "2", "2": "1"}[page]
+		w.Header().Set("Link", fmt.Sprintf(`</orders?page=%s>;rel="next"`, next))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"orders": []string{"https://ca.test/order/" + page}})
+	}))
+	defer list.Close()
+	client.Account.Orders = list.URL + "/orders?page=1"
+	urls, e := client.FetchOrders()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(urls, []string{"https://ca.test/order/1", "https://ca.test/order/2"}) || requests != 2 {
+		t.Fatalf("listed %v in %d requests", urls, requests)
+	}
+}
+
+func TestSyncOrders(t *testing.T) {
+	client, s := newTestClient(t, &acmetest.Options{OrdersPageSize: 2})
+	urls := newTestOrders(t, client, "a.test", "b.test", "c.test")
+	// lost locally, and one the CA no longer lists
+	e := os.Remove(filepath.Join(client.storeOrders, "order-"+utils.Md5String([]byte(urls[1]))+".json"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	gone := &Order{Uri: s.DirectoryURL() + "/order/gone", Status: "invalid"}
+	e = client.saveOrder(gone)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	sync, e := client.SyncOrders(false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(sync.Imported) != 1 || sync.Imported[0].Uri != urls[1] || sync.Imported[0].Status == "" {
+		t.Fatalf("imported %+v", sync.Imported)
+	}
+	if len(sync.Refreshed) != 0 || len(sync.Failed) != 0 {
+		t.Fatalf("refreshed %d, failed %v", len(sync.Refreshed), sync.Failed)
+	}
+	if len(sync.LocalOnly) != 1 || sync.LocalOnly[0].Uri != gone.Uri {
+		t.Fatalf("local only %+v", sync.LocalOnly)
+	}
+	local, _ := client.GetLocalOrders()
+	if len(local) != 4 {
+		t.Fatalf("%d local orders after the import", len(local))
+	}
+
+	sync, e = client.SyncOrders(true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(sync.Imported) != 0 || len(sync.Refreshed) != 3 || len(sync.LocalOnly) != 1 {
+		t.Fatalf("imported %d, refreshed %d, local only %d", len(sync.Imported), len(sync.Refreshed), len(sync.LocalOnly))
+	}
+}
@@ -0,0 +1,313 @@
+//go:build cgo
+
+// Package pkcs11 provides a crypto.Signer backed by a private key held in a
+// PKCS#11 token, so an ACME account key never has to leave the HSM.
+//
+// It can be tried out with SoftHSM:
+//
+//	softhsm2-util --init-token --free --label acme --pin 1234 --so-pin 1234
+//	pkcs11-tool --module /usr/lib/softhsm/libsofthsm2.so --token-label acme \
+//	    --login --pin 1234 --keypairgen --key-type EC:prime256v1 --label account
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	p11 "github.com/miekg/pkcs11"
+)
+
+// Config selects a key in a token. The key is looked up by KeyLabel and/or
+// KeyID (hex); the token by TokenLabel, or the first token when empty.
+type Config struct {
+	Module     string
+	TokenLabel string
+	Pin        string
+	KeyLabel   string
+	KeyID      string
+}
+
+// Signer signs with a private key that stays inside the token. It is safe
+// for concurrent use, calls are serialized on one session.
+type Signer struct {
+	ctx     *p11.Ctx
+	session p11.SessionHandle
+	key     p11.ObjectHandle
+	public  crypto.PublicKey
+	lock    sync.Mutex
+}
+
+var oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+// DigestInfo prefixes of PKCS#1 v1.5 signatures, CKM_RSA_PKCS signs the
+// DigestInfo as is.
+var digestInfoPrefix = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// New loads the module, logs into the token and finds the key. Call Close
+// when the signer is no longer needed.
+func New(config *Config) (*Signer, error) {
+	if config.Module == "" {
+		return nil, errors.New("no pkcs11 module configured")
+	}
+	if config.KeyLabel == "" && config.KeyID == "" {
+		return nil, errors.New("no pkcs11 key label or id configured")
+	}
+	ctx := p11.New(config.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("load pkcs11 module %s failed", config.Module)
+	}
+	e := ctx.Initialize()
+	if e != nil && !errors.Is(e, p11.Error(p11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("initialize pkcs11 module: %w", e)
+	}
+	signer := &Signer{ctx: ctx}
+	e = signer.open(config)
+	if e != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, e
+	}
+	return signer, nil
+}
+
+func (signer *Signer) open(config *Config) error {
+	ctx := signer.ctx
+	slot, e := findSlot(ctx, config.TokenLabel)
+	if e != nil {
+		return e
+	}
+	signer.session, e = ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION)
+	if e != nil {
+		return fmt.Errorf("open pkcs11 session: %w", e)
+	}
+	if config.Pin != "" {
+		e = ctx.Login(signer.session, p11.CKU_USER, config.Pin)
+		if e != nil && !errors.Is(e, p11.Error(p11.CKR_USER_ALREADY_LOGGED_IN)) {
+			return fmt.Errorf("pkcs11 login: %w", e)
+		}
+	}
+	template, e := keyTemplate(config)
+	if e != nil {
+		return e
+	}
+	signer.key, e = findObject(ctx, signer.session, append(template, p11.NewAttribute(p11.CKA_CLASS, p11.CKO_PRIVATE_KEY)))
+	if e != nil {
+		return fmt.Errorf("private key: %w", e)
+	}
+	pub, e := findObject(ctx, signer.session, append(template, p11.NewAttribute(p11.CKA_CLASS, p11.CKO_PUBLIC_KEY)))
+	if e != nil {
+		return fmt.Errorf("public key: %w", e)
+	}
+	signer.public, e = readPublicKey(ctx, signer.session, pub)
+	return e
+}
+
+func findSlot(ctx *p11.Ctx, label string) (uint, error) {
+	slots, e := ctx.GetSlotList(true)
+	if e != nil {
+		return 0, fmt.Errorf("list pkcs11 slots: %w", e)
+	}
+	for _, slot := range slots {
+		if label == "" {
+			return slot, nil
+		}
+		info, e := ctx.GetTokenInfo(slot)
+		if e != nil {
+			continue
+		}
+		if strings.TrimRight(info.Label, " \x00") == label {
+			return slot, nil
+		}
+	}
+	if label == "" {
+		return 0, errors.New("no pkcs11 token present")
+	}
+	return 0, fmt.Errorf("pkcs11 token %q not found", label)
+}
+
+func keyTemplate(config *Config) ([]*p11.Attribute, error) {
+	rtn := make([]*p11.Attribute, 0)
+	if config.KeyLabel != "" {
+		rtn = append(rtn, p11.NewAttribute(p11.CKA_LABEL, config.KeyLabel))
+	}
+	if config.KeyID != "" {
+		id, e := hex.DecodeString(config.KeyID)
+		if e != nil {
+			return nil, fmt.Errorf("bad pkcs11 key id %q: %w", config.KeyID, e)
+		}
+		rtn = append(rtn, p11.NewAttribute(p11.CKA_ID, id))
+	}
+	return rtn, nil
+}
+
+func findObject(ctx *p11.Ctx, session p11.SessionHandle, template []*p11.Attribute) (p11.ObjectHandle, error) {
+	e := ctx.FindObjectsInit(session, template)
+	if e != nil {
+		return 0, e
+	}
+	objects, _, e := ctx.FindObjects(session, 2)
+	ctx.FindObjectsFinal(session)
+	if e != nil {
+		return 0, e
+	}
+	switch len(objects) {
+	case 0:
+		return 0, errors.New("not found in token")
+	case 1:
+		return objects[0], nil
+	}
+	return 0, errors.New("more than one key matches, set the key id")
+}
+
+func readPublicKey(ctx *p11.Ctx, session p11.SessionHandle, object p11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, e := ctx.GetAttributeValue(session, object, []*p11.Attribute{p11.NewAttribute(p11.CKA_KEY_TYPE, nil)})
+	if e != nil {
+		return nil, fmt.Errorf("read public key type: %w", e)
+	}
+	keyType := readUlong(attrs[0].Value)
+	switch keyType {
+	case p11.CKK_EC:
+		attrs, e = ctx.GetAttributeValue(session, object, []*p11.Attribute{
+			p11.NewAttribute(p11.CKA_EC_PARAMS, nil),
+			p11.NewAttribute(p11.CKA_EC_POINT, nil),
+		})
+		if e != nil {
+			return nil, fmt.Errorf("read ec public key: %w", e)
+		}
+		return parseECPoint(attrs[0].Value, attrs[1].Value)
+	case p11.CKK_RSA:
+		attrs, e = ctx.GetAttributeValue(session, object, []*p11.Attribute{
+			p11.NewAttribute(p11.CKA_MODULUS, nil),
+			p11.NewAttribute(p11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if e != nil {
+			return nil, fmt.Errorf("read rsa public key: %w", e)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported pkcs11 key type %d", keyType)
+}
+
+// CK_ULONG attributes come back in host byte order and size.
+func readUlong(bs []byte) uint {
+	switch len(bs) {
+	case 4:
+		return uint(binary.NativeEndian.Uint32(bs))
+	case 8:
+		return uint(binary.NativeEndian.Uint64(bs))
+	}
+	return 0
+}
+
+// parseECPoint turns CKA_EC_PARAMS and CKA_EC_POINT into a public key by
+// wrapping them into a SubjectPublicKeyInfo, which also checks the point is
+// on the curve.
+func parseECPoint(params []byte, point []byte) (*ecdsa.PublicKey, error) {
+	var raw []byte
+	rest, e := asn1.Unmarshal(point, &raw)
+	if e != nil || len(rest) > 0 {
+		// some tokens return the bare point instead of an OCTET STRING
+		raw = point
+	}
+	spki := struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: raw, BitLength: 8 * len(raw)},
+	}
+	der, e := asn1.Marshal(spki)
+	if e != nil {
+		return nil, e
+	}
+	pub, e := x509.ParsePKIXPublicKey(der)
+	if e != nil {
+		return nil, fmt.Errorf("parse ec public key: %w", e)
+	}
+	rtn, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ec public key")
+	}
+	return rtn, nil
+}
+
+func (signer *Signer) Public() crypto.PublicKey {
+	return signer.public
+}
+
+// Sign implements crypto.Signer. ECDSA signatures are returned ASN.1 encoded
+// like ecdsa.PrivateKey.Sign does, RSA signatures are PKCS#1 v1.5.
+func (signer *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism uint
+	data := digest
+	switch signer.public.(type) {
+	case *ecdsa.PublicKey:
+		mechanism = p11.CKM_ECDSA
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("pkcs11: rsa pss is not supported")
+		}
+		prefix, ok := digestInfoPrefix[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("pkcs11: unsupported hash %s", opts.HashFunc())
+		}
+		mechanism = p11.CKM_RSA_PKCS
+		data = append(append([]byte{}, prefix...), digest...)
+	}
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+	e := signer.ctx.SignInit(signer.session, []*p11.Mechanism{p11.NewMechanism(mechanism, nil)}, signer.key)
+	if e != nil {
+		return nil, fmt.Errorf("pkcs11 sign: %w", e)
+	}
+	sig, e := signer.ctx.Sign(signer.session, data)
+	if e != nil {
+		return nil, fmt.Errorf("pkcs11 sign: %w", e)
+	}
+	if mechanism == p11.CKM_ECDSA {
+		if len(sig)%2 != 0 {
+			return nil, errors.New("pkcs11 sign: bad ecdsa signature length")
+		}
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(sig[:half]),
+			S: new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+	return sig, nil
+}
+
+// Close logs out and unloads the module.
+func (signer *Signer) Close() error {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+	if signer.ctx == nil {
+		return nil
+	}
+	signer.ctx.Logout(signer.session)
+	signer.ctx.CloseSession(signer.session)
+	e := signer.ctx.Finalize()
+	signer.ctx.Destroy()
+	signer.ctx = nil
+	return e
+}
@@ -0,0 +1,37 @@
+//go:build !cgo
+
+package pkcs11
+
+import (
+	"crypto"
+	"errors"
+	"io"
+)
+
+type Config struct {
+	Module     string
+	TokenLabel string
+	Pin        string
+	KeyLabel   string
+	KeyID      string
+}
+
+type Signer struct{}
+
+var errNoCgo = errors.New("pkcs11 support needs a build with cgo enabled")
+
+func New(config *Config) (*Signer, error) {
+	return nil, errNoCgo
+}
+
+func (signer *Signer) Public() crypto.PublicKey {
+	return nil
+}
+
+func (signer *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, errNoCgo
+}
+
+func (signer *Signer) Close() error {
+	return nil
+}
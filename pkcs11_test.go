@@ -0,0 +1,208 @@
+//go:build cgo
+
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	p11 "github.com/miekg/pkcs11"
+	"github.com/tonyzzp/acme/pkcs11"
+)
+
+const (
+	testTokenLabel = "acme-test"
+	testTokenPin   = "5678"
+)
+
+// p256Params is the DER encoded OID of prime256v1 for CKA_EC_PARAMS.
+var p256Params = []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+
+// newSoftHSMToken initializes a token in a SoftHSM store in a temp dir and
+// generates an EC key labeled "ec" and an RSA key labeled "rsa" in it. It
+// skips the test when SOFTHSM2_MODULE is not set.
+func newSoftHSMToken(t *testing.T) string {
+	t.Helper()
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		t.Skip("SOFTHSM2_MODULE not set")
+	}
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	e := os.Mkdir(tokens, 0700)
+	if e != nil {
+		t.Fatal(e)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	e = os.WriteFile(conf, []byte("directories.tokendir = "+tokens+"\nobjectstore.backend = file\n"), 0600)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := p11.New(module)
+	if ctx == nil {
+		t.Fatalf("load %s failed", module)
+	}
+	defer ctx.Destroy()
+	e = ctx.Initialize()
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer ctx.Finalize()
+	slots, e := ctx.GetSlotList(false)
+	if e != nil || len(slots) == 0 {
+		t.Fatalf("no free slot: %v", e)
+	}
+	e = ctx.InitToken(slots[0], "1234", testTokenLabel)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// SoftHSM moves the new token to a slot of its own
+	slots, e = ctx.GetSlotList(true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	slot := slots[0]
+	for _, s := range slots {
+		info, e := ctx.GetTokenInfo(s)
+		if e == nil && strings.TrimRight(info.Label, " \x00") == testTokenLabel {
+			slot = s
+		}
+	}
+	session, e := ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION|p11.CKF_RW_SESSION)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer ctx.CloseSession(session)
+	e = ctx.Login(session, p11.CKU_SO, "1234")
+	if e == nil {
+		e = ctx.InitPIN(session, testTokenPin)
+	}
+	if e == nil {
+		e = ctx.Logout(session)
+	}
+	if e == nil {
+		e = ctx.Login(session, p11.CKU_USER, testTokenPin)
+	}
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer ctx.Logout(session)
+
+	private := func(label string) []*p11.Attribute {
+		return []*p11.Attribute{
+			p11.NewAttribute(p11.CKA_TOKEN, true),
+			p11.NewAttribute(p11.CKA_PRIVATE, true),
+			p11.NewAttribute(p11.CKA_SENSITIVE, true),
+			p11.NewAttribute(p11.CKA_SIGN, true),
+			p11.NewAttribute(p11.CKA_LABEL, label),
+		}
+	}
+	public := func(label string, attrs ...*p11.Attribute) []*p11.Attribute {
+		return append([]*p11.Attribute{
+			p11.NewAttribute(p11.CKA_TOKEN, true),
+			p11.NewAttribute(p11.CKA_VERIFY, true),
+			p11.NewAttribute(p11.CKA_LABEL, label),
+		}, attrs...)
+	}
+	_, _, e = ctx.GenerateKeyPair(session,
+		[]*p11.Mechanism{p11.NewMechanism(p11.CKM_EC_KEY_PAIR_GEN, nil)},
+		public("ec", p11.NewAttribute(p11.CKA_EC_PARAMS, p256Params)),
+		private("ec"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, _, e = ctx.GenerateKeyPair(session,
+		[]*p11.Mechanism{p11.NewMechanism(p11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+		public("rsa",
+			p11.NewAttribute(p11.CKA_MODULUS_BITS, 2048),
+			p11.NewAttribute(p11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1})),
+		private("rsa"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	return module
+}
+
+// verifyJWS checks a JWS signature of data with the key of a public JWK
+// only, the way a CA does.
+func verifyJWS(t *testing.T, jwk *JWK, data string, signature string) {
+	t.Helper()
+	sig, e := base64.RawURLEncoding.DecodeString(signature)
+	if e != nil {
+		t.Fatal(e)
+	}
+	decode := func(v string) *big.Int {
+		bs, e := base64.RawURLEncoding.DecodeString(v)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return new(big.Int).SetBytes(bs)
+	}
+	switch jwk.Kty {
+	case "EC":
+		curve, _, e := jwkCurve(jwk.Crv)
+		if e != nil {
+			t.Fatal(e)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: decode(jwk.X), Y: decode(jwk.Y)}
+		_, hash, _ := jwsAlg(pub)
+		h := hash.New()
+		h.Write([]byte(data))
+		half := len(sig) / 2
+		if !ecdsa.Verify(pub, h.Sum(nil), new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:])) {
+			t.Fatal("ecdsa signature does not verify")
+		}
+	case "RSA":
+		pub := &rsa.PublicKey{N: decode(jwk.N), E: int(decode(jwk.E).Int64())}
+		_, hash, _ := jwsAlg(pub)
+		h := hash.New()
+		h.Write([]byte(data))
+		e = rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig)
+		if e != nil {
+			t.Fatal(e)
+		}
+	default:
+		t.Fatalf("unexpected kty %q", jwk.Kty)
+	}
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	module := newSoftHSMToken(t)
+	for _, label := range []string{"ec", "rsa"} {
+		signer, e := pkcs11.New(&pkcs11.Config{
+			Module:     module,
+			TokenLabel: testTokenLabel,
+			Pin:        testTokenPin,
+			KeyLabel:   label,
+		})
+		if e != nil {
+			t.Fatal(e)
+		}
+		jwk, e := publicJWK(signer.Public())
+		if e != nil {
+			t.Fatal(e)
+		}
+		data := "eyJhbGciOiJFUzI1NiJ9.eyJ0ZXN0Ijp0cnVlfQ"
+		sig, e := signJWS(signer, data)
+		if e != nil {
+			t.Fatal(e)
+		}
+		verifyJWS(t, jwk, data, sig)
+
+		client, _ := newTestClient(t, nil)
+		client.Signer = signer
+		e = client.InitAccount()
+		if e != nil {
+			t.Fatalf("%s: %s", label, e)
+		}
+		signer.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package acme
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var oidPBES2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+var oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+var oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+var oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+
+const pkcs8Iterations = 600000
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        pkix.AlgorithmIdentifier
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// encryptPKCS8 wraps a PKCS#8 private key into an EncryptedPrivateKeyInfo
+// using PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC, the scheme OpenSSL
+// uses by default.
+func encryptPKCS8(der []byte, password []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	_, e := rand.Read(salt)
+	if e != nil {
+		return nil, e
+	}
+	iv := make([]byte, aes.BlockSize)
+	_, e = rand.Read(iv)
+	if e != nil {
+		return nil, e
+	}
+	key := pbkdf2.Key(password, salt, pkcs8Iterations, 32, sha256.New)
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	data := make([]byte, len(der)+padding)
+	copy(data, der)
+	for i := len(der); i < len(data); i++ {
+		data[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdfParams, e := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pkcs8Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if e != nil {
+		return nil, e
+	}
+	ivParams, e := asn1.Marshal(iv)
+	if e != nil {
+		return nil, e
+	}
+	params, e := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if e != nil {
+		return nil, e
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+}
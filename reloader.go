@@ -0,0 +1,285 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce lets a burst of writes settle before the files are read.
+const reloadDebounce = 250 * time.Millisecond
+
+// CertReloader keeps a certificate loaded for a running server and swaps
+// it when the files change, so renewals need no restart. Changes are
+// watched with inotify and friends, or polled every PollInterval when the
+// platform has no watcher. A change is only taken when the new files
+// parse completely and the key matches the certificate, a half written
+// file never replaces a good certificate.
+type CertReloader struct {
+	PollInterval time.Duration
+	// Logger reports reloads and failed ones, slog.Default() when nil.
+	Logger *slog.Logger
+	load   func() (*Cert, error)
+	// watch returns the paths to watch, they are added again after every
+	// reload as a symlink may point elsewhere now.
+	watch   func(cert *Cert) []string
+	cert    atomic.Pointer[tls.Certificate]
+	last    atomic.Pointer[Cert]
+	changes chan *tls.Certificate
+}
+
+func newCertReloader(load func() (*Cert, error), watch func(cert *Cert) []string, logger *slog.Logger) (*CertReloader, error) {
+	rtn := &CertReloader{
+		PollInterval: 30 * time.Second,
+		load:         load,
+		watch:        watch,
+		Logger:       logger,
+		changes:      make(chan *tls.Certificate, 1),
+	}
+	_, e := rtn.Reload()
+	if e != nil {
+		return nil, e
+	}
+	return rtn, nil
+}
+
+// NewCertReloader watches the privkey.pem and fullchain.pem in dir. store
+// may be nil when the key is not encrypted. Set Logger before Run.
+func NewCertReloader(dir string, store KeyStore) (*CertReloader, error) {
+	return newCertReloader(
+		func() (*Cert, error) { return LoadCert(dir, store) },
+		func(cert *Cert) []string { return []string{dir, filepath.Dir(dir)} },
+		nil,
+	)
+}
+
+// NewCertReloader watches the current version of a stored certificate.
+func (client *Client) NewCertReloader(name string) (*CertReloader, error) {
+	e := validCertName(name)
+	if e != nil {
+		return nil, e
+	}
+	return newCertReloader(
+		func() (*Cert, error) { return client.LoadStoredCert(name) },
+		func(cert *Cert) []string { return []string{client.certDir(name), cert.Path} },
+		client.logger(),
+	)
+}
+
+func (r *CertReloader) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.Default()
+}
+
+// Certificate returns the certificate currently served.
+func (r *CertReloader) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (r *CertReloader) GetClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Changes receives the new certificate after every swap. Only the latest
+// one is kept when nobody reads.
+func (r *CertReloader) Changes() <-chan *tls.Certificate {
+	return r.changes
+}
+
+// validateReload rejects a bundle caught in the middle of being written.
+func validateReload(cert *Cert) error {
+	rest := []byte(cert.FullChainPEM)
+	blocks := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			blocks++
+		}
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return errors.New("fullchain.pem has trailing data")
+	}
+	if blocks == 0 || blocks != len(cert.Certs) {
+		return errors.New("fullchain.pem does not parse completely")
+	}
+	for i := 1; i < len(cert.Certs); i++ {
+		e := cert.Certs[i-1].CheckSignatureFrom(cert.Certs[i])
+		if e != nil {
+			return fmt.Errorf("certificate %d is not issued by the next one: %w", i-1, e)
+		}
+	}
+	if cert.PrivateKey == nil {
+		return errors.New("no private key")
+	}
+	pub, ok := cert.PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.Certs[0].PublicKey) {
+		return errors.New("private key does not match the certificate")
+	}
+	return nil
+}
+
+func sameCertificate(a *tls.Certificate, b *tls.Certificate) bool {
+	if a == nil || b == nil || len(a.Certificate) != len(b.Certificate) || !bytes.Equal(a.OCSPStaple, b.OCSPStaple) {
+		return false
+	}
+	for i := range a.Certificate {
+		if !bytes.Equal(a.Certificate[i], b.Certificate[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reload reads the files now and swaps the certificate when they hold a
+// valid, different one. It reports whether it swapped.
+func (r *CertReloader) Reload() (bool, error) {
+	cert, e := r.load()
+	if e == nil {
+		e = validateReload(cert)
+	}
+	var tc *tls.Certificate
+	if e == nil {
+		tc, e = tlsCertificate(cert)
+	}
+	if e != nil {
+		return false, e
+	}
+	r.last.Store(cert)
+	old := r.cert.Load()
+	if sameCertificate(old, tc) {
+		return false, nil
+	}
+	r.cert.Store(tc)
+	if old != nil {
+		r.logger().Info("certificate reloaded", "name", cert.Name, "path", cert.Path, "notAfter", tc.Leaf.NotAfter)
+		select {
+		case <-r.changes:
+		default:
+		}
+		// a concurrent Reload may have filled the slot again
+		select {
+		case r.changes <- tc:
+		default:
+		}
+	}
+	return true, nil
+}
+
+func (r *CertReloader) reload() {
+	_, e := r.Reload()
+	if e != nil {
+		r.logger().Warn("certificate not reloaded, keeping the current one", "error", e)
+	}
+}
+
+// Run watches the files until ctx is done.
+func (r *CertReloader) Run(ctx context.Context) error {
+	watcher, e := fsnotify.NewWatcher()
+	if e == nil {
+		e = r.addWatches(watcher)
+		if e != nil {
+			watcher.Close()
+		}
+	}
+	if e != nil {
+		r.logger().Info("file watching unavailable, polling", "interval", r.PollInterval, "error", e)
+		return r.poll(ctx)
+	}
+	defer watcher.Close()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			if strings.HasSuffix(event.Name, ".tmp") {
+				continue
+			}
+			timer.Reset(reloadDebounce)
+		case e, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			r.logger().Warn("watch failed", "error", e)
+		case <-timer.C:
+			r.reload()
+			e := r.addWatches(watcher)
+			if e != nil {
+				r.logger().Warn("watch failed", "error", e)
+			}
+		}
+	}
+}
+
+func (r *CertReloader) addWatches(watcher *fsnotify.Watcher) error {
+	for _, path := range r.watch(r.last.Load()) {
+		e := watcher.Add(path)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// stamp changes whenever one of the watched files does.
+func (r *CertReloader) stamp() string {
+	cert := r.last.Load()
+	files := []string{filepath.Join(cert.Path, "fullchain.pem"), filepath.Join(cert.Path, "privkey.pem"), filepath.Join(cert.Path, ocspFile)}
+	for _, path := range r.watch(cert) {
+		files = append(files, filepath.Join(path, certManifestFile))
+	}
+	var b strings.Builder
+	for _, file := range files {
+		info, e := os.Stat(file)
+		if e == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", file, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return b.String()
+}
+
+func (r *CertReloader) poll(ctx context.Context) error {
+	last := r.stamp()
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			stamp := r.stamp()
+			if stamp != last {
+				r.reload()
+				last = r.stamp()
+			}
+		}
+	}
+}
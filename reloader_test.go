@@ -0,0 +1,151 @@
+package acme
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// copyBundle writes the fullchain.pem and privkey.pem of cert to dir.
+func copyBundle(t *testing.T, dir string, fullchain string, key string) {
+	t.Helper()
+	for name, data := range map[string]string{"fullchain.pem": fullchain, "privkey.pem": key} {
+		e := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+}
+
+func servedLeaf(t *testing.T, r *CertReloader) []byte {
+	t.Helper()
+	tc, e := r.GetCertificate(nil)
+	if e != nil || tc == nil {
+		t.Fatalf("nothing served: %v", e)
+	}
+	return tc.Certificate[0]
+}
+
+func TestCertReloaderKeepsGoodCertificate(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	a := obtainTestCert(t, client, "a.test")
+	b := obtainTestCert(t, client, "b.test")
+	dir := t.TempDir()
+	copyBundle(t, dir, a.FullChainPEM, a.PrivateKeyPEM)
+	r, e := NewCertReloader(dir, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(servedLeaf(t, r)) != string(a.Certs[0].Raw) {
+		t.Fatal("a not served")
+	}
+
+	bad := map[string][2]string{
+		"truncated fullchain": {b.FullChainPEM[:len(b.FullChainPEM)/2], b.PrivateKeyPEM},
+		"truncated last cert": {b.FullChainPEM[:len(b.FullChainPEM)-40], b.PrivateKeyPEM},
+		"mismatched key":      {b.FullChainPEM, a.PrivateKeyPEM},
+		"empty key":           {b.FullChainPEM, ""},
+	}
+	for name, files := range bad {
+		copyBundle(t, dir, files[0], files[1])
+		swapped, e := r.Reload()
+		if e == nil || swapped {
+			t.Errorf("%s: swapped %v, error %v", name, swapped, e)
+		}
+		if string(servedLeaf(t, r)) != string(a.Certs[0].Raw) {
+			t.Fatalf("%s replaced the good certificate", name)
+		}
+	}
+
+	copyBundle(t, dir, b.FullChainPEM, b.PrivateKeyPEM)
+	swapped, e := r.Reload()
+	if e != nil || !swapped {
+		t.Fatalf("swapped %v, error %v", swapped, e)
+	}
+	if string(servedLeaf(t, r)) != string(b.Certs[0].Raw) {
+		t.Fatal("b not served")
+	}
+	select {
+	case tc := <-r.Changes():
+		if string(tc.Certificate[0]) != string(b.Certs[0].Raw) {
+			t.Fatal("wrong certificate on Changes")
+		}
+	default:
+		t.Fatal("no change sent")
+	}
+	swapped, e = r.Reload()
+	if e != nil || swapped {
+		t.Fatalf("unchanged files swapped %v, error %v", swapped, e)
+	}
+}
+
+func TestCertReloaderConcurrentReloads(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	certs := []*Cert{obtainTestCert(t, client, "a.test"), obtainTestCert(t, client, "b.test")}
+	var n atomic.Int64
+	load := func() (*Cert, error) {
+		return certs[n.Add(1)%2], nil
+	}
+	r, e := newCertReloader(load, func(cert *Cert) []string { return nil }, client.logger())
+	if e != nil {
+		t.Fatal(e)
+	}
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 200; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.Reload()
+			}()
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("concurrent reloads blocked on Changes")
+	}
+	if len(r.Changes()) != 1 {
+		t.Fatalf("%d changes queued", len(r.Changes()))
+	}
+}
+
+func TestCertReloaderRunWatchesFiles(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	a := obtainTestCert(t, client, "a.test")
+	b := obtainTestCert(t, client, "b.test")
+	dir := t.TempDir()
+	copyBundle(t, dir, a.FullChainPEM, a.PrivateKeyPEM)
+	r, e := NewCertReloader(dir, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	r.PollInterval = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+	// give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	copyBundle(t, dir, b.FullChainPEM[:len(b.FullChainPEM)/2], b.PrivateKeyPEM)
+	time.Sleep(reloadDebounce + 200*time.Millisecond)
+	if string(servedLeaf(t, r)) != string(a.Certs[0].Raw) {
+		t.Fatal("half written file replaced the good certificate")
+	}
+	copyBundle(t, dir, b.FullChainPEM, b.PrivateKeyPEM)
+	select {
+	case tc := <-r.Changes():
+		if string(tc.Certificate[0]) != string(b.Certs[0].Raw) {
+			t.Fatal("wrong certificate on Changes")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not picked up")
+	}
+}
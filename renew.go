@@ -0,0 +1,300 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRenewalInfoUnsupported = errors.New("CA does not support ARI")
+
+// RenewalManager scans the certificate store and reorders certificates that
+// are close to expiry or that the CA asks to be replaced (ARI).
+type RenewalManager struct {
+	Client   *Client
+	Solver   Solver
+	Days     int
+	UseARI   bool
+	Force    bool
+	Interval time.Duration
+	Jitter   time.Duration
+	OnRenew  func(result *RenewalResult)
+	// lock is held while a certificate is checked and renewed, renewed is
+	// called after every successful renewal. Both are set by Manager.
+	lock    sync.Locker
+	renewed func(result *RenewalResult)
+	// points are the renewal times picked in ARI windows by cert ID.
+	pointsLock sync.Mutex
+	points     map[string]*renewalPoint
+}
+
+// renewalPointFile keeps the time picked in the ARI window next to the
+// certificate, so runs from cron agree on it.
+const renewalPointFile = "renewal.json"
+
+type renewalPoint struct {
+	CertID string    `json:"certId"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	At     time.Time `json:"at"`
+}
+
+type RenewalResult struct {
+	Name        string
+	Path        string
+	Identifiers []Identifier
+	NotAfter    time.Time
+	Renew       bool
+	Reason      string
+	Error       error
+}
+
+func NewRenewalManager(client *Client, solver Solver) *RenewalManager {
+	return &RenewalManager{
+		Client:   client,
+		Solver:   solver,
+		Days:     30,
+		UseARI:   true,
+		Interval: 12 * time.Hour,
+		Jitter:   time.Hour,
+	}
+}
+
+// CertID returns the ARI identifier of a certificate: the authority key id
+// and the serial number, both base64url encoded.
+func CertID(cert *x509.Certificate) (string, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return "", errors.New("certificate has no authority key identifier")
+	}
+	serial := cert.SerialNumber.Bytes()
+	if len(serial) > 0 && serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+	return base64.RawURLEncoding.EncodeToString(cert.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial), nil
+}
+
+func (client *Client) GetRenewalInfo(cert *x509.Certificate) (*RenewalInfo, error) {
+	e := client.InitDirectory()
+	if e != nil {
+		return nil, e
+	}
+	if client.Directory.RenewalInfo == "" {
+		return nil, ErrRenewalInfoUnsupported
+	}
+	id, e := CertID(cert)
+	if e != nil {
+		return nil, e
+	}
+	rtn := &RenewalInfo{}
+	res, e := client.request(HttpRequestParam{
+		Url:    strings.TrimSuffix(client.Directory.RenewalInfo, "/") + "/" + id,
+		Method: http.MethodGet,
+	})
+	if e != nil {
+		return nil, e
+	}
+	e = json.Unmarshal(res.Body(), rtn)
+	if e != nil {
+		return nil, e
+	}
+	rtn.RetryAfter = retryAfterSeconds(res.Header())
+	return rtn, nil
+}
+
+// certIdentifiers returns the SANs of the leaf to order again, the common
+// name first when it is one of them so the renewal keeps its name. An
+// empty or IP common name is skipped, duplicates differing in case only
+// once.
+func certIdentifiers(cert *Cert) []Identifier {
+	leaf := cert.Certs[0]
+	names := make([]string, 0)
+	if cn := leaf.Subject.CommonName; cn != "" && net.ParseIP(cn) == nil {
+		for _, name := range leaf.DNSNames {
+			if strings.EqualFold(name, cn) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	names = append(names, leaf.DNSNames...)
+	seen := make(map[string]bool)
+	rtn := make([]Identifier, 0)
+	for _, id := range leafIdentifiers(names, leaf.IPAddresses) {
+		key := id.Type + ":" + strings.ToLower(id.Value)
+		if id.Value == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		rtn = append(rtn, id)
+	}
+	return rtn
+}
+
+// Check decides whether a stored certificate has to be renewed now.
+func (m *RenewalManager) Check(cert *Cert) *RenewalResult {
+	rtn := &RenewalResult{
+		Name: cert.Name,
+		Path: cert.Path,
+	}
+	if len(cert.Certs) == 0 {
+		rtn.Error = errors.New("no certificate in fullchain.pem")
+		return rtn
+	}
+	leaf := cert.Certs[0]
+	rtn.Identifiers = certIdentifiers(cert)
+	rtn.NotAfter = leaf.NotAfter
+	if m.Force {
+		rtn.Renew = true
+		rtn.Reason = "forced"
+		return rtn
+	}
+	now := time.Now()
+	left := leaf.NotAfter.Sub(now)
+	if left < time.Duration(m.Days)*24*time.Hour {
+		rtn.Renew = true
+		rtn.Reason = fmt.Sprintf("expires in %d days", int(left.Hours()/24))
+		return rtn
+	}
+	if m.UseARI {
+		info, e := m.Client.GetRenewalInfo(leaf)
+		if e != nil {
+			if e != ErrRenewalInfoUnsupported {
+				m.Client.logger().Warn("fetch renewal info failed", "name", rtn.Name, "error", e)
+			}
+			return rtn
+		}
+		at := m.renewalTime(cert, info.SuggestedWindow.Start, info.SuggestedWindow.End)
+		if !now.Before(at) {
+			rtn.Renew = true
+			rtn.Reason = "inside ARI renewal window"
+			if info.ExplanationURL != "" {
+				rtn.Reason += " (" + info.ExplanationURL + ")"
+			}
+		}
+	}
+	return rtn
+}
+
+// renewalTime returns a random time in the ARI window of cert. It is
+// picked once per window: a later check of the same window, in this
+// process or a later one, returns the same time.
+func (m *RenewalManager) renewalTime(cert *Cert, start time.Time, end time.Time) time.Time {
+	id, _ := CertID(cert.Certs[0])
+	same := func(p *renewalPoint) bool {
+		return p != nil && p.CertID == id && p.Start.Equal(start) && p.End.Equal(end)
+	}
+	m.pointsLock.Lock()
+	defer m.pointsLock.Unlock()
+	if m.points == nil {
+		m.points = make(map[string]*renewalPoint)
+	}
+	if p := m.points[id]; same(p) {
+		return p.At
+	}
+	file := ""
+	if cert.Path != "" {
+		file = filepath.Join(cert.Path, renewalPointFile)
+	}
+	if file != "" {
+		p := &renewalPoint{}
+		bs, e := os.ReadFile(file)
+		if e == nil && json.Unmarshal(bs, p) == nil && same(p) {
+			m.points[id] = p
+			return p.At
+		}
+	}
+	p := &renewalPoint{CertID: id, Start: start, End: end, At: start}
+	if end.After(start) {
+		p.At = start.Add(time.Duration(rand.Int63n(int64(end.Sub(start)))))
+	}
+	m.points[id] = p
+	if file != "" {
+		bs, _ := json.Marshal(p)
+		e := os.WriteFile(file, bs, 0644)
+		if e != nil {
+			m.Client.logger().Warn("save renewal time failed", "name", cert.Name, "error", e)
+		}
+	}
+	return p.At
+}
+
+func (m *RenewalManager) renew(cert *Cert, result *RenewalResult) {
+	payload := NewOrderPayload{Identifiers: result.Identifiers}
+	if m.UseARI && m.Client.InitDirectory() == nil && m.Client.Directory.RenewalInfo != "" {
+		id, e := CertID(cert.Certs[0])
+		if e == nil {
+			payload.Replaces = id
+		}
+	}
+	dir, e := m.Client.obtain(result.Name, payload, m.Solver)
+	if dir != "" {
+		result.Path = dir
+	}
+	result.Error = e
+}
+
+// RunOnce checks every stored certificate once and renews the ones that are
+// due. It is meant to be called from cron.
+func (m *RenewalManager) RunOnce() ([]*RenewalResult, error) {
+	certs, e := m.Client.GetLocalCerts()
+	if e != nil {
+		return nil, e
+	}
+	rtn := make([]*RenewalResult, 0)
+	var errs []error
+	for i := range certs {
+		cert := &certs[i]
+		if m.lock != nil {
+			m.lock.Lock()
+		}
+		result := m.Check(cert)
+		if result.Renew && result.Error == nil {
+			m.Client.logger().Info("renewing", "name", result.Name, "reason", result.Reason)
+			m.renew(cert, result)
+			if result.Error == nil && m.renewed != nil {
+				m.renewed(result)
+			}
+		}
+		if m.lock != nil {
+			m.lock.Unlock()
+		}
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Name, result.Error))
+		}
+		if m.OnRenew != nil {
+			m.OnRenew(result)
+		}
+		rtn = append(rtn, result)
+	}
+	return rtn, errors.Join(errs...)
+}
+
+// Run calls RunOnce every Interval plus a random jitter until ctx is done.
+func (m *RenewalManager) Run(ctx context.Context) error {
+	for {
+		_, e := m.RunOnce()
+		if e != nil {
+			m.Client.logger().Error("renewal failed", "error", e)
+		}
+		delay := m.Interval
+		if m.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(m.Jitter)))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertIdentifiers(t *testing.T) {
+	tests := []struct {
+		name string
+		leaf *x509.Certificate
+		want []Identifier
+	}{
+		{
+			name: "no common name",
+			leaf: &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("192.0.2.1")}},
+			want: []Identifier{{Type: "ip", Value: "192.0.2.1"}},
+		},
+		{
+			name: "ip common name",
+			leaf: &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "192.0.2.1"},
+				IPAddresses: []net.IP{net.ParseIP("192.0.2.1")},
+			},
+			want: []Identifier{{Type: "ip", Value: "192.0.2.1"}},
+		},
+		{
+			name: "common name first",
+			leaf: &x509.Certificate{
+				Subject:  pkix.Name{CommonName: "www.example.test"},
+				DNSNames: []string{"example.test", "www.example.test"},
+			},
+			want: dnsIdentifiers("www.example.test", "example.test"),
+		},
+		{
+			name: "case duplicates",
+			leaf: &x509.Certificate{
+				Subject:  pkix.Name{CommonName: "Example.test"},
+				DNSNames: []string{"example.test", "EXAMPLE.test", "a.example.test"},
+			},
+			want: dnsIdentifiers("example.test", "a.example.test"),
+		},
+		{
+			name: "common name not a SAN",
+			leaf: &x509.Certificate{
+				Subject:  pkix.Name{CommonName: "other.test"},
+				DNSNames: []string{"example.test"},
+			},
+			want: dnsIdentifiers("example.test"),
+		},
+	}
+	for _, test := range tests {
+		got := certIdentifiers(&Cert{Certs: []*x509.Certificate{test.leaf}})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRenewIPOnly(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	solver := &acceptSolver{challenge: ChallengeTypeHTTP01}
+	_, e := client.ObtainCert([]Identifier{{Type: "ip", Value: "192.0.2.1"}}, solver)
+	if e != nil {
+		t.Fatal(e)
+	}
+	m := NewRenewalManager(client, solver)
+	m.Force = true
+	results, e := m.RunOnce()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(results) != 1 || !results[0].Renew {
+		t.Fatalf("results %+v", results)
+	}
+	cert, e := client.LoadStoredCert(results[0].Name)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if cert.Version != 2 {
+		t.Fatalf("renewed to version %d", cert.Version)
+	}
+	verifyTestCert(t, s, cert, "192.0.2.1")
+}
+
+func TestRenewalTimeStable(t *testing.T) {
+	client, s := newTestClient(t, nil)
+	cert := obtainStoredCert(t, client, "example.test")
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	end := start.Add(48 * time.Hour)
+	e := s.SetRenewalWindow(cert.Certs[0], start, end)
+	if e != nil {
+		t.Fatal(e)
+	}
+	m := NewRenewalManager(client, &acceptSolver{})
+	m.Days = 0
+	if result := m.Check(cert); result.Renew || result.Error != nil {
+		t.Fatalf("renew before the window: %+v", result)
+	}
+	at := m.renewalTime(cert, start, end)
+	if at.Before(start) || !at.Before(end) {
+		t.Fatalf("%s outside of the window", at)
+	}
+	for i := 0; i < 5; i++ {
+		m.Check(cert)
+		if again := m.renewalTime(cert, start, end); !again.Equal(at) {
+			t.Fatalf("picked %s, then %s", at, again)
+		}
+	}
+	// a later run, from cron
+	later := NewRenewalManager(client, &acceptSolver{})
+	if again := later.renewalTime(cert, start, end); !again.Equal(at) {
+		t.Fatalf("picked %s, then %s in a later run", at, again)
+	}
+
+	// a new window gets a new time, one that has passed renews
+	start = time.Now().Add(-time.Hour).Truncate(time.Second)
+	end = start.Add(time.Minute)
+	s.SetRenewalWindow(cert.Certs[0], start, end)
+	result := later.Check(cert)
+	if !result.Renew || !strings.Contains(result.Reason, "ARI") {
+		t.Fatalf("not renewed in a passed window: %+v", result)
+	}
+}
+
+func TestRenewReplaces(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	cert := obtainStoredCert(t, client, "example.test")
+	id, e := CertID(cert.Certs[0])
+	if e != nil {
+		t.Fatal(e)
+	}
+	// a new process, the directory is not fetched yet
+	fresh := NewAcmeClient(client.storeRoot)
+	fresh.DirectoryUrl = client.DirectoryUrl
+	fresh.PollInterval = client.PollInterval
+	fresh.Retry = client.Retry
+	out := &bytes.Buffer{}
+	fresh.Logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	fresh.DebugWire = true
+	m := NewRenewalManager(fresh, &acceptSolver{})
+	m.Force = true
+	_, e = m.RunOnce()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(out.String(), `"replaces":"`+id+`"`) {
+		t.Fatal("renewal order does not replace the certificate")
+	}
+}
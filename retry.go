@@ -0,0 +1,114 @@
+package acme
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy decides how Client.request retries requests the CA answered
+// with 429, 503 or badNonce. Client.Retry is nil to never retry.
+//
+// Only GETs and POST-as-GETs are retried on 429 and 503 unless
+// RetryNonIdempotent is set: retrying newOrder or finalize may create a
+// second order. badNonce is always retried, the CA did not process the
+// request. A Retry-After longer than MaxBackoff is not waited for, the
+// error is returned with Problem.RetryAfter set.
+type RetryPolicy struct {
+	MaxRetries         int
+	MinBackoff         time.Duration
+	MaxBackoff         time.Duration
+	RetryNonIdempotent bool
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries: 3,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date. It returns the zero time when there is none.
+func parseRetryAfter(header http.Header, now time.Time) time.Time {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return time.Time{}
+	}
+	seconds, e := strconv.Atoi(value)
+	if e == nil {
+		if seconds < 0 {
+			return time.Time{}
+		}
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	t, e := http.ParseTime(value)
+	if e != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// retryAfterSeconds is the Retry-After header in whole seconds, 0 when
+// there is none.
+func retryAfterSeconds(header http.Header) int {
+	now := time.Now()
+	t := parseRetryAfter(header, now)
+	if t.IsZero() || !t.After(now) {
+		return 0
+	}
+	return int(t.Sub(now).Round(time.Second) / time.Second)
+}
+
+// backoff returns the delay before retry attempt (0 based): exponential
+// from MinBackoff with up to 50% jitter, but never less than retryAfter.
+func (policy *RetryPolicy) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	delay := policy.MinBackoff << attempt
+	if delay <= 0 || delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// retry reports whether a failed request should be sent again and after
+// how long.
+func (policy *RetryPolicy) retry(req HttpRequestParam, e error, attempt int) (time.Duration, bool) {
+	if policy == nil || attempt >= policy.MaxRetries {
+		return 0, false
+	}
+	problem := &Problem{}
+	if !errors.As(e, &problem) {
+		return 0, false
+	}
+	if problem.Type == ProblemBadNonce {
+		return 0, true
+	}
+	if problem.Status != http.StatusTooManyRequests && problem.Status != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead || req.Payload == nil
+	if !idempotent && !policy.RetryNonIdempotent {
+		return 0, false
+	}
+	var wait time.Duration
+	if !problem.RetryAfter.IsZero() {
+		wait = time.Until(problem.RetryAfter)
+		if wait > policy.MaxBackoff {
+			return 0, false
+		}
+	}
+	return policy.backoff(attempt, wait), true
+}
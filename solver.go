@@ -0,0 +1,117 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const ChallengeTypeDNS01 = "dns-01"
+const ChallengeTypeHTTP01 = "http-01"
+const ChallengeTypeTLSALPN01 = "tls-alpn-01"
+
+// Solver fulfils one challenge type so that orders can be completed without
+// a human in the loop.
+type Solver interface {
+	Type() string
+	Present(domain string, token string, keyAuth string) error
+	CleanUp(domain string, token string, keyAuth string) error
+}
+
+type DNSRecord struct {
+	Domain string
+	// Challenge is the _acme-challenge name the CA queries, FQDN is where
+	// its CNAME chain ends and the TXT record has to be published.
+	Challenge string
+	FQDN      string
+	Value     string
+	Token     string
+}
+
+// DNSProvider publishes and removes the TXT records used by dns-01.
+type DNSProvider interface {
+	Present(record *DNSRecord) error
+	CleanUp(record *DNSRecord) error
+}
+
+// DNS01Solver publishes dns-01 records through Provider. _acme-challenge
+// names delegated with a CNAME are followed and the record goes to the
+// end of the chain, unless NoCNAME is set. Without a resolver that answers
+// the record goes to _acme-challenge itself.
+type DNS01Solver struct {
+	Provider        DNSProvider
+	PropagationWait time.Duration
+	NoCNAME         bool
+	// Resolvers are the host:port of recursive resolvers, those of
+	// /etc/resolv.conf when empty.
+	Resolvers []string
+	// Logger is slog.Default() when nil.
+	Logger *slog.Logger
+}
+
+func (solver *DNS01Solver) Type() string {
+	return ChallengeTypeDNS01
+}
+
+func (solver *DNS01Solver) logger() *slog.Logger {
+	if solver.Logger != nil {
+		return solver.Logger
+	}
+	return slog.Default()
+}
+
+func (solver *DNS01Solver) record(domain string, token string, keyAuth string) (*DNSRecord, error) {
+	domain = strings.TrimPrefix(domain, "*.")
+	rtn := &DNSRecord{
+		Domain:    domain,
+		Challenge: "_acme-challenge." + domain + ".",
+		Value:     dnsValue(keyAuth),
+		Token:     token,
+	}
+	rtn.FQDN = rtn.Challenge
+	if solver.NoCNAME {
+		return rtn, nil
+	}
+	resolvers, e := dnsResolvers(solver.Resolvers)
+	if e == nil {
+		rtn.FQDN, e = followCNAME(rtn.Challenge, resolvers, dnsTimeout)
+	}
+	if errors.Is(e, errNoResolver) {
+		solver.logger().Warn("cannot follow CNAME, using the challenge name", "challenge", rtn.Challenge, "error", e)
+		rtn.FQDN = rtn.Challenge
+		return rtn, nil
+	}
+	if e != nil {
+		return nil, fmt.Errorf("follow CNAME of %s: %w", rtn.Challenge, e)
+	}
+	if rtn.FQDN != rtn.Challenge {
+		solver.logger().Info("dns challenge delegated", "challenge", rtn.Challenge, "fqdn", rtn.FQDN)
+	}
+	return rtn, nil
+}
+
+func (solver *DNS01Solver) Present(domain string, token string, keyAuth string) error {
+	record, e := solver.record(domain, token, keyAuth)
+	if e != nil {
+		return e
+	}
+	e = solver.Provider.Present(record)
+	if e != nil {
+		return e
+	}
+	if solver.PropagationWait > 0 {
+		solver.logger().Info("waiting for dns propagation", "fqdn", record.FQDN, "wait", solver.PropagationWait)
+		time.Sleep(solver.PropagationWait)
+	}
+	return nil
+}
+
+func (solver *DNS01Solver) CleanUp(domain string, token string, keyAuth string) error {
+	record, e := solver.record(domain, token, keyAuth)
+	if e != nil {
+		return e
+	}
+	return solver.Provider.CleanUp(record)
+}
@@ -0,0 +1,19 @@
+#!/bin/sh
+# dns hook fixture for ExecDNSProvider: records its arguments, ACME_
+# environment and stdin in $HOOK_OUT.*, then acts as $HOOK_MODE says.
+printf '%s\n' "$@" > "$HOOK_OUT.args"
+env | grep '^ACME_' | sort > "$HOOK_OUT.env"
+cat > "$HOOK_OUT.stdin"
+case "$HOOK_MODE" in
+fail)
+	echo "looking up the zone"
+	echo "zone not found" >&2
+	exit 3
+	;;
+hang)
+	# the child keeps stdout open after the hook is killed
+	sleep 30 &
+	sleep 30
+	;;
+esac
+echo "done $ACME_ACTION"
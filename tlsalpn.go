@@ -0,0 +1,107 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACMETLS1Protocol is the ALPN protocol of tls-alpn-01 (RFC 8737).
+const ACMETLS1Protocol = "acme-tls/1"
+
+var oidAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// TLSALPN01Solver answers tls-alpn-01 challenges from a TLS listener that
+// asks its GetCertificate for acme-tls/1 handshakes, Manager does so.
+type TLSALPN01Solver struct {
+	lock  sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+func (solver *TLSALPN01Solver) Type() string {
+	return ChallengeTypeTLSALPN01
+}
+
+func (solver *TLSALPN01Solver) Present(domain string, token string, keyAuth string) error {
+	if strings.HasPrefix(domain, "*.") {
+		return fmt.Errorf("tls-alpn-01 cannot validate the wildcard %s", domain)
+	}
+	cert, e := tlsALPNCert(domain, keyAuth)
+	if e != nil {
+		return e
+	}
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	if solver.certs == nil {
+		solver.certs = make(map[string]*tls.Certificate)
+	}
+	solver.certs[strings.ToLower(domain)] = cert
+	return nil
+}
+
+func (solver *TLSALPN01Solver) CleanUp(domain string, token string, keyAuth string) error {
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	delete(solver.certs, strings.ToLower(domain))
+	return nil
+}
+
+// GetCertificate returns the challenge certificate of an acme-tls/1
+// handshake.
+func (solver *TLSALPN01Solver) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	cert := solver.certs[strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))]
+	if cert == nil {
+		return nil, fmt.Errorf("no tls-alpn-01 challenge for %q", hello.ServerName)
+	}
+	return cert, nil
+}
+
+func isACMETLS1(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == ACMETLS1Protocol
+}
+
+// tlsALPNCert builds the self signed certificate carrying the critical
+// acmeIdentifier extension with the digest of the key authorization.
+func tlsALPNCert(domain string, keyAuth string) (*tls.Certificate, error) {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		return nil, e
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	value, e := asn1.Marshal(sum[:])
+	if e != nil {
+		return nil, e
+	}
+	serial, e := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if e != nil {
+		return nil, e
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "acme tls-alpn-01 challenge"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		DNSNames:     []string{domain},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidAcmeIdentifier, Critical: true, Value: value},
+		},
+	}
+	der, e := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if e != nil {
+		return nil, e
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,84 @@
+package acme
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const userAgent = "tonyzzp-acme"
+
+// LoadCABundle returns the system roots plus every certificate in the PEM
+// files, for CAs like Pebble or step-ca that serve a private TLS chain.
+func LoadCABundle(files ...string) (*x509.CertPool, error) {
+	pool, e := x509.SystemCertPool()
+	if e != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, file := range files {
+		bs, e := os.ReadFile(file)
+		if e != nil {
+			return nil, e
+		}
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("%s contains no certificate", file)
+		}
+	}
+	return pool, nil
+}
+
+func (client *Client) userAgent() string {
+	rtn := fmt.Sprintf("%s (%s; %s) %s", userAgent, runtime.GOOS, runtime.GOARCH, runtime.Version())
+	if client.UserAgent != "" {
+		rtn += " " + client.UserAgent
+	}
+	return rtn
+}
+
+// newHTTPClient builds the http.Client used when Client.HTTPClient is nil.
+func (client *Client) newHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if client.Proxy != "" {
+		proxy := client.Proxy
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		u, e := url.Parse(proxy)
+		if e != nil {
+			return nil, fmt.Errorf("bad proxy %q: %w", client.Proxy, e)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if client.RootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: client.RootCAs}
+	}
+	return &http.Client{Transport: transport, Timeout: client.Timeout}, nil
+}
+
+// http returns the resty client shared by every request of client. It is
+// built on first use, so HTTPClient, Proxy, RootCAs, Timeout and UserAgent
+// have to be set before the first request.
+func (client *Client) http() (*resty.Client, error) {
+	client.httpLock.Lock()
+	defer client.httpLock.Unlock()
+	if client.rc != nil {
+		return client.rc, nil
+	}
+	hc := client.HTTPClient
+	if hc == nil {
+		var e error
+		hc, e = client.newHTTPClient()
+		if e != nil {
+			return nil, e
+		}
+	}
+	client.rc = resty.NewWithClient(hc).SetHeader("User-Agent", client.userAgent())
+	return client.rc, nil
+}
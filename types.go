@@ -1,9 +1,11 @@
 package acme
 
 import (
+	"crypto"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/tonyzzp/acme/utils"
 )
@@ -32,7 +34,7 @@ type Protected struct {
 
 type NewAccountPayload struct {
 	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
-	Contact              []string `json:"contact"`
+	Contact              []string `json:"contact,omitempty"`
 }
 
 type Req struct {
@@ -48,8 +50,9 @@ type Identifier struct {
 
 type NewOrderPayload struct {
 	Identifiers []Identifier `json:"identifiers"`
-	NotBefore   string       `json:"notBefore"`
-	NotAfter    string       `json:"notAfter"`
+	NotBefore   string       `json:"notBefore,omitempty"`
+	NotAfter    string       `json:"notAfter,omitempty"`
+	Replaces    string       `json:"replaces,omitempty"`
 }
 
 type Account struct {
@@ -59,10 +62,24 @@ type Account struct {
 	CreatedAt            string   `json:"createdAt"`
 	Status               string   `json:"status"`
 	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+	Orders               string   `json:"orders,omitempty"`
 }
 
+// OrderList is one page of the account orders list, Next is the url of
+// the following page from Link rel="next".
 type OrderList struct {
-	Orders []string
+	Orders []string `json:"orders"`
+	Next   string   `json:"-"`
+}
+
+// OrderSync is the result of Client.SyncOrders.
+type OrderSync struct {
+	Imported  []*Order
+	Refreshed []*Order
+	// LocalOnly are local orders the CA no longer lists, usually because
+	// they are invalid or expired.
+	LocalOnly []*Order
+	Failed    map[string]error
 }
 
 const OrderStatusPending = "pending"
@@ -85,31 +102,38 @@ type Order struct {
 }
 
 type Cert struct {
+	Name string
+	// Version is the current version in the store, 0 for certificates
+	// stored before versioning.
+	Version       int
 	Path          string
 	FullChainPEM  string
 	PrivateKeyPEM string
 	JWK           *JWK
+	PrivateKey    crypto.Signer
 	Certs         []*x509.Certificate
+	Chain         *ChainInfo
+	// OCSPStaple is the stored OCSP response when it is valid and good.
+	OCSPStaple []byte
 }
 
 func (order *Order) ShortDesc() string {
 	id := utils.Md5String([]byte(order.Uri))
 	id = id[:5]
+	if len(order.Identifiers) == 0 {
+		return fmt.Sprintf("%s %s", id, order.Status)
+	}
 	identifier := order.Identifiers[0]
 	return fmt.Sprintf("%s %s %s %s", id, order.Status, identifier.Type, identifier.Value)
 }
 
 type Challenge struct {
-	Type      string
-	Url       string
-	Status    string
-	Token     string
-	Validated string `json:"validated"`
-	Error     struct {
-		Type   string
-		Detail string
-		Status int
-	}
+	Type             string
+	Url              string
+	Status           string
+	Token            string
+	Validated        string   `json:"validated"`
+	Error            *Problem `json:"error,omitempty"`
 	ValidationRecord []struct {
 		Hostname string
 	}
@@ -123,6 +147,39 @@ type Authorization struct {
 	Wildcard   bool
 }
 
+const ProblemBadNonce = "urn:ietf:params:acme:error:badNonce"
+const ProblemRateLimited = "urn:ietf:params:acme:error:rateLimited"
+
+// Problem is an RFC 7807 problem document returned by the CA. RetryAfter is
+// taken from the Retry-After header, for rateLimited it is the time the
+// limit resets.
+type Problem struct {
+	Type        string      `json:"type"`
+	Detail      string      `json:"detail,omitempty"`
+	Status      int         `json:"status,omitempty"`
+	Instance    string      `json:"instance,omitempty"`
+	Identifier  *Identifier `json:"identifier,omitempty"`
+	Subproblems []Problem   `json:"subproblems,omitempty"`
+	RetryAfter  time.Time   `json:"-"`
+}
+
+func (p *Problem) Error() string {
+	rtn := p.Type
+	if p.Detail != "" {
+		if rtn != "" {
+			rtn += ": "
+		}
+		rtn += p.Detail
+	}
+	if !p.RetryAfter.IsZero() {
+		rtn += ", retry after " + p.RetryAfter.Format(time.RFC3339)
+	}
+	for _, sub := range p.Subproblems {
+		rtn += "; " + sub.Error()
+	}
+	return rtn
+}
+
 type HttpRequestParam struct {
 	Url     string
 	Method  string
@@ -131,6 +188,20 @@ type HttpRequestParam struct {
 	Result  any
 }
 
+type RenewalInfo struct {
+	SuggestedWindow struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"suggestedWindow"`
+	ExplanationURL string `json:"explanationURL"`
+	RetryAfter     int    `json:"-"`
+}
+
+type RevokePayload struct {
+	Certificate string `json:"certificate"`
+	Reason      int    `json:"reason"`
+}
+
 type FinalizePayload struct {
 	Csr string `json:"csr"`
 }
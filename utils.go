@@ -6,49 +6,22 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
-	"log"
-	"math/big"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
-func dumpJson(data any) {
-	bs, e := json.MarshalIndent(data, "", "    ")
-	if e != nil {
-		log.Println(e)
-	} else {
-		log.Println(string(bs))
-	}
-}
-
 func base64Json(data any) (string, error) {
 	bs, e := json.Marshal(data)
 	if e != nil {
-		log.Println("base64Json", data)
-		log.Println(e)
-		return "", nil
+		return "", fmt.Errorf("encode %T: %w", data, e)
 	}
 	return base64.RawURLEncoding.EncodeToString(bs), nil
 }
 
-func mustBase64Json(data any) string {
-	rtn, e := base64Json(data)
-	if e != nil {
-		panic("base64json failed")
-	}
-	return rtn
-}
-
-func base64ToBigInt(s string) *big.Int {
-	rtn := &big.Int{}
-	bs, e := base64.RawURLEncoding.DecodeString(s)
-	if e != nil {
-		log.Println(e)
-		os.Exit(-1)
-	}
-	rtn.SetBytes(bs)
-	return rtn
-}
-
 func writeJson(file string, data any) error {
 	bs, e := json.MarshalIndent(data, "", "    ")
 	if e != nil {
@@ -70,3 +43,83 @@ func convertPkToPEM(pk *ecdsa.PrivateKey) ([]byte, error) {
 	bs = pem.EncodeToMemory(block)
 	return bs, nil
 }
+
+func writeChain(dir string, body string, info *ChainInfo) error {
+	e := os.WriteFile(filepath.Join(dir, "fullchain.pem"), []byte(body), os.ModePerm)
+	if e != nil {
+		return e
+	}
+	return writeJson(filepath.Join(dir, "chain.json"), info)
+}
+
+func parseCertsPEM(bs []byte) ([]*x509.Certificate, error) {
+	rtn := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, bs = pem.Decode(bs)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, e := x509.ParseCertificate(block.Bytes)
+		if e != nil {
+			return nil, e
+		}
+		rtn = append(rtn, c)
+	}
+	return rtn, nil
+}
+
+// parseLinks returns the targets of the Link headers with the given rel,
+// resolved against the request url.
+func parseLinks(header http.Header, base string, rel string) []string {
+	rtn := make([]string, 0)
+	baseUrl, _ := url.Parse(base)
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = target[1 : len(target)-1]
+			matched := false
+			for _, param := range parts[1:] {
+				k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if ok && strings.EqualFold(k, "rel") && strings.Trim(v, `"`) == rel {
+					matched = true
+				}
+			}
+			if !matched {
+				continue
+			}
+			if baseUrl != nil {
+				u, e := baseUrl.Parse(target)
+				if e == nil {
+					target = u.String()
+				}
+			}
+			rtn = append(rtn, target)
+		}
+	}
+	return rtn
+}
+
+// parseProblem returns the problem document in body. When the CA did not
+// send one the raw body becomes the detail.
+func parseProblem(status int, body []byte) *Problem {
+	problem := &Problem{}
+	e := json.Unmarshal(body, problem)
+	if e != nil || problem.Type == "" {
+		problem = &Problem{Detail: strings.TrimSpace(string(body))}
+		if problem.Detail == "" {
+			problem.Detail = http.StatusText(status)
+		}
+	}
+	if problem.Status == 0 {
+		problem.Status = status
+	}
+	return problem
+}
@@ -57,3 +57,12 @@ func Md5String(data []byte) string {
 	bs := md5.Sum(data)
 	return strings.ToLower(hex.EncodeToString(bs[:]))
 }
+
+func Contains[T comparable](array []T, value T) bool {
+	for _, v := range array {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
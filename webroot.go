@@ -0,0 +1,165 @@
+package acme
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WebrootSolver answers http-01 challenges through a web server that
+// already serves a document root, by writing the key authorization to
+// <webroot>/.well-known/acme-challenge/<token>. CleanUp removes the file
+// and the directories Present created once they are empty.
+type WebrootSolver struct {
+	// Webroot serves the domains that have no entry in Webroots.
+	Webroot string
+	// Webroots maps domains to their document root, "*.example.com"
+	// matches the subdomains of example.com.
+	Webroots map[string]string
+	// Owner of the files and directories written, "user[:group]" or
+	// numeric ids, unchanged when empty.
+	Owner string
+	// Mode of the challenge files, 0644 when 0. Directories get 0755.
+	Mode    os.FileMode
+	lock    sync.Mutex
+	created map[string]bool
+}
+
+func (solver *WebrootSolver) Type() string {
+	return ChallengeTypeHTTP01
+}
+
+// webroot returns the document root serving domain.
+func (solver *WebrootSolver) webroot(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if root, ok := solver.Webroots[domain]; ok {
+		return root, nil
+	}
+	if _, parent, ok := strings.Cut(domain, "."); ok {
+		if root, ok := solver.Webroots["*."+parent]; ok {
+			return root, nil
+		}
+	}
+	if solver.Webroot == "" {
+		return "", fmt.Errorf("no webroot for %s", domain)
+	}
+	return solver.Webroot, nil
+}
+
+func (solver *WebrootSolver) challengeFile(domain string, token string) (string, error) {
+	if strings.HasPrefix(domain, "*.") {
+		return "", fmt.Errorf("http-01 cannot validate the wildcard %s", domain)
+	}
+	if token == "" || strings.ContainsAny(token, `/\.`) {
+		return "", fmt.Errorf("invalid token %q", token)
+	}
+	root, e := solver.webroot(domain)
+	if e != nil {
+		return "", e
+	}
+	return filepath.Join(root, ".well-known", "acme-challenge", token), nil
+}
+
+// mkdirs creates dir and its missing parents below root and remembers
+// the ones it created.
+func (solver *WebrootSolver) mkdirs(root string, dir string, uid int, gid int) error {
+	missing := make([]string, 0)
+	for d := dir; d != root && d != filepath.Dir(d); d = filepath.Dir(d) {
+		_, e := os.Stat(d)
+		if e == nil {
+			break
+		}
+		if !os.IsNotExist(e) {
+			return e
+		}
+		missing = append(missing, d)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		e := os.Mkdir(d, 0755)
+		if os.IsExist(e) {
+			continue
+		}
+		if e != nil {
+			return e
+		}
+		solver.created[d] = true
+		e = os.Chmod(d, 0755)
+		if e == nil && (uid != -1 || gid != -1) {
+			e = os.Chown(d, uid, gid)
+		}
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (solver *WebrootSolver) Present(domain string, token string, keyAuth string) error {
+	file, e := solver.challengeFile(domain, token)
+	if e != nil {
+		return e
+	}
+	uid, gid := -1, -1
+	if solver.Owner != "" {
+		uid, gid, e = lookupOwner(solver.Owner)
+		if e != nil {
+			return fmt.Errorf("webroot owner %q: %w", solver.Owner, e)
+		}
+	}
+	mode := solver.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	root, _ := solver.webroot(domain)
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	if solver.created == nil {
+		solver.created = make(map[string]bool)
+	}
+	e = solver.mkdirs(filepath.Clean(root), filepath.Dir(file), uid, gid)
+	if e != nil {
+		return fmt.Errorf("webroot %s: %w", root, e)
+	}
+	e = os.WriteFile(file, []byte(keyAuth), mode)
+	if e == nil {
+		e = os.Chmod(file, mode)
+	}
+	if e == nil && (uid != -1 || gid != -1) {
+		e = os.Chown(file, uid, gid)
+	}
+	if e != nil {
+		return fmt.Errorf("webroot %s: %w", root, e)
+	}
+	return nil
+}
+
+func (solver *WebrootSolver) CleanUp(domain string, token string, keyAuth string) error {
+	file, e := solver.challengeFile(domain, token)
+	if e != nil {
+		return e
+	}
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	e = os.Remove(file)
+	if e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	// deepest first, directories still used by other challenges or by
+	// the site stay
+	dirs := make([]string, 0)
+	for d := range solver.created {
+		dirs = append(dirs, d)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, d := range dirs {
+		e := os.Remove(d)
+		if e == nil || os.IsNotExist(e) {
+			delete(solver.created, d)
+		}
+	}
+	return nil
+}
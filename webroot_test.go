@@ -0,0 +1,135 @@
+package acme
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWebrootSolverMap(t *testing.T) {
+	dir := t.TempDir()
+	root := func(name string) string { return filepath.Join(dir, name) }
+	for _, name := range []string{"default", "apex", "sub", "www"} {
+		os.Mkdir(root(name), 0755)
+	}
+	solver := &WebrootSolver{
+		Webroot: root("default"),
+		Webroots: map[string]string{
+			"example.test":   root("apex"),
+			"*.example.test": root("sub"),
+			"www.other.test": root("www"),
+		},
+	}
+	tests := map[string]string{
+		"example.test":        "apex",
+		"Example.Test.":       "apex",
+		"www.example.test":    "sub",
+		"a.b.example.test":    "default",
+		"www.other.test":      "www",
+		"other.test":          "default",
+		"unrelated.test":      "default",
+		"example.test.evil":   "default",
+		"wwwexample.test":     "default",
+		"api.www.other.test":  "default",
+		"www.sub.example.net": "default",
+	}
+	for domain, want := range tests {
+		e := solver.Present(domain, "token", "keyAuth."+domain)
+		if e != nil {
+			t.Fatalf("%s: %v", domain, e)
+		}
+		file := filepath.Join(root(want), ".well-known", "acme-challenge", "token")
+		bs, e := os.ReadFile(file)
+		if e != nil || string(bs) != "keyAuth."+domain {
+			t.Errorf("%s: %s has %q: %v", domain, file, bs, e)
+		}
+		info, _ := os.Stat(file)
+		if info != nil && info.Mode().Perm() != 0644 {
+			t.Errorf("%s: mode %s", domain, info.Mode())
+		}
+		solver.CleanUp(domain, "token", "keyAuth."+domain)
+	}
+
+	solver.Webroot = ""
+	if e := solver.Present("unrelated.test", "token", "keyAuth"); e == nil {
+		t.Error("presented without a webroot")
+	}
+}
+
+func TestWebrootSolverRejects(t *testing.T) {
+	root := t.TempDir()
+	solver := &WebrootSolver{Webroot: root}
+	for _, token := range []string{"", "../token", "a/b", `a\b`, "..", "a.b", "/etc/passwd"} {
+		if e := solver.Present("example.test", token, "keyAuth"); e == nil {
+			t.Errorf("token %q accepted", token)
+		}
+		if e := solver.CleanUp("example.test", token, "keyAuth"); e == nil {
+			t.Errorf("token %q cleaned up", token)
+		}
+	}
+	if e := solver.Present("*.example.test", "token", "keyAuth"); e == nil {
+		t.Error("wildcard accepted")
+	}
+	entries, _ := os.ReadDir(root)
+	if len(entries) != 0 {
+		t.Fatalf("written into the webroot: %v", entries)
+	}
+}
+
+func TestWebrootSolverCleanUp(t *testing.T) {
+	fresh := t.TempDir()
+	site := t.TempDir()
+	wellKnown := filepath.Join(site, ".well-known")
+	e := os.Mkdir(wellKnown, 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	os.WriteFile(filepath.Join(wellKnown, "security.txt"), []byte("contact"), 0644)
+	solver := &WebrootSolver{
+		Webroot:  fresh,
+		Webroots: map[string]string{"site.test": site},
+		Mode:     0640,
+	}
+
+	for _, token := range []string{"one", "two"} {
+		for _, domain := range []string{"fresh.test", "site.test"} {
+			e := solver.Present(domain, token, "keyAuth")
+			if e != nil {
+				t.Fatal(e)
+			}
+		}
+	}
+	info, e := os.Stat(filepath.Join(site, ".well-known", "acme-challenge", "one"))
+	if e != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("challenge file %v: %v", info, e)
+	}
+
+	// the directory is still used by the other token
+	for _, domain := range []string{"fresh.test", "site.test"} {
+		solver.CleanUp(domain, "one", "keyAuth")
+	}
+	for _, root := range []string{fresh, site} {
+		if _, e := os.Stat(filepath.Join(root, ".well-known", "acme-challenge", "two")); e != nil {
+			t.Fatalf("other challenge removed: %v", e)
+		}
+	}
+
+	for _, domain := range []string{"fresh.test", "site.test"} {
+		e := solver.CleanUp(domain, "two", "keyAuth")
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	if _, e := os.Stat(filepath.Join(fresh, ".well-known")); !os.IsNotExist(e) {
+		t.Errorf("created .well-known left behind: %v", e)
+	}
+	if _, e := os.Stat(filepath.Join(site, ".well-known", "acme-challenge")); !os.IsNotExist(e) {
+		t.Errorf("created acme-challenge left behind: %v", e)
+	}
+	if _, e := os.Stat(filepath.Join(site, ".well-known", "security.txt")); e != nil {
+		t.Errorf("existing .well-known removed: %v", e)
+	}
+	if _, e := os.Stat(fresh); e != nil {
+		t.Errorf("webroot removed: %v", e)
+	}
+}